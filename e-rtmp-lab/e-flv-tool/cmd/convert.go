@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"eflv/flv"
+
+	"github.com/spf13/cobra"
+)
+
+var convertOutput string
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <input.mp4>",
+	Short: "Convert an MP4 / fragmented MP4 file into an E-FLV file",
+	Long: `Convert an MP4 / fragmented MP4 file into an E-FLV file.
+
+The coded frames are copied as they are, without transcoding. Every audio
+and video track whose codec has an E-RTMP FourCC is converted (avc1, hvc1,
+av01, vp09, mp4a, .mp3, Opus, fLaC, ac-3, ec-3): its codec configuration
+box becomes a SequenceStart and its samples become CodedFrames tags,
+interleaved by DTS. The first video and audio tracks are written as
+single-track packets, further tracks as Multitrack packets.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return flv.ConvertMP4(args[0], convertOutput)
+	},
+}
+
+func init() {
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "Output file path (required)")
+	convertCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(convertCmd)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"eflv/flv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	gopJSON      bool
+	gopTolerance float64
+)
+
+var gopCmd = &cobra.Command{
+	Use:   "gop <input.flv>",
+	Short: "Report keyframes and GOP lengths of the video tracks",
+	Long: `Report keyframes and GOP lengths of the video tracks.
+
+Lists every keyframe with its tag index, file offset and DTS, the length of
+the GOP it starts in frames and milliseconds, and min/avg/max GOP lengths per
+track. GOPs whose duration differs from the median by more than --tolerance
+percent are flagged as irregular.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return flv.GOPFLV(args[0], gopJSON, gopTolerance)
+	},
+}
+
+func init() {
+	gopCmd.Flags().BoolVar(&gopJSON, "json", false, "Output machine-readable JSON instead of text")
+	gopCmd.Flags().Float64Var(&gopTolerance, "tolerance", 10, "Allowed deviation from the median GOP duration, in percent")
+	rootCmd.AddCommand(gopCmd)
+}
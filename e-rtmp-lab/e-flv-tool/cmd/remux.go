@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"eflv/flv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	remuxOutput           string
+	remuxFormat           string
+	remuxFragmentDuration uint32
+)
+
+var remuxCmd = &cobra.Command{
+	Use:   "remux <input.flv>",
+	Short: "Remux an FLV / E-FLV file into another container",
+	Long: `Remux an FLV / E-FLV file into another container.
+
+The coded frames are copied as they are, without transcoding. Supported
+output formats (--to):
+
+  mp4   progressive MP4 with the moov box ahead of the media data
+  fmp4  fragmented MP4 (CMAF brands): an init segment followed by
+        moof/mdat fragments that start at keyframes
+  ts    MPEG-2 transport stream (H.264, H.265, AAC and MP3)
+  webm  WebM (VP8, VP9, AV1 and Opus) with Cues for the keyframes
+
+Tracks whose codec the output format cannot carry are skipped with a
+warning.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return flv.RemuxFLV(args[0], remuxOutput, remuxFormat, remuxFragmentDuration)
+	},
+}
+
+func init() {
+	remuxCmd.Flags().StringVarP(&remuxOutput, "output", "o", "", "Output file path (required)")
+	remuxCmd.MarkFlagRequired("output")
+	remuxCmd.Flags().StringVar(&remuxFormat, "to", "mp4", "Output format: mp4, fmp4, ts or webm")
+	remuxCmd.Flags().Uint32Var(&remuxFragmentDuration, "fragment-duration", 2000, "Minimum fragment duration for fmp4, in milliseconds")
+	rootCmd.AddCommand(remuxCmd)
+}
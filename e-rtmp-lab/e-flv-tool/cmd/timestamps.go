@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"eflv/flv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	timestampsJSON        bool
+	timestampsMaxGap      uint32
+	timestampsMaxAVOffset uint32
+)
+
+var timestampsCmd = &cobra.Command{
+	Use:   "timestamps <input.flv>",
+	Short: "Check the timestamps of the audio and video tracks",
+	Long: `Check the timestamps of the audio and video tracks.
+
+Reports, per track, DTS that go back, gaps larger than --max-gap, duplicate
+timestamps and 24-bit timestamp wraparounds that do not match
+TimestampExtended, plus the start offset and drift between audio and video.
+Exits with a non-zero status if any problem is found.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return flv.TimestampsFLV(args[0], timestampsJSON, timestampsMaxGap, timestampsMaxAVOffset)
+	},
+}
+
+func init() {
+	timestampsCmd.Flags().BoolVar(&timestampsJSON, "json", false, "Output machine-readable JSON instead of text")
+	timestampsCmd.Flags().Uint32Var(&timestampsMaxGap, "max-gap", 1000, "Largest allowed step between two frames of a track, in ms")
+	timestampsCmd.Flags().Uint32Var(&timestampsMaxAVOffset, "max-av-offset", 200, "Largest allowed A/V start offset and drift, in ms")
+	rootCmd.AddCommand(timestampsCmd)
+}
@@ -4,191 +4,762 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"time"
 )
 
 // AMF0 type markers.
 const (
-	amf0Number     = 0x00
-	amf0Boolean    = 0x01
-	amf0String     = 0x02
-	amf0Object     = 0x03
-	amf0Null       = 0x05
-	amf0Undefined  = 0x06
-	amf0ECMAArray  = 0x08
-	amf0StrictArr  = 0x0A
-	amf0Date       = 0x0B
-	amf0LongString = 0x0C
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0MovieClip   = 0x04 // reserved, not supported
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0Reference   = 0x07
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArr   = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+	amf0Unsupported = 0x0D
+	amf0RecordSet   = 0x0E // reserved, not supported
+	amf0XMLDocument = 0x0F
+	amf0TypedObject = 0x10
+	amf0AVMPlus     = 0x11
 )
 
-// amf0Property is a named value from an AMF0 object or ECMA array.
-type amf0Property struct {
-	name  string
-	value any
+// AMF0Property is a named value from an AMF0 object or ECMA array.
+type AMF0Property struct {
+	Name  string
+	Value any
+}
+
+// AMF0ECMAArray is an AMF0 ECMA array (associative array), the type used for
+// the onMetaData argument. A plain []AMF0Property is an anonymous object.
+// Count is the associative count written before the members; it is only a
+// hint, and some encoders write 0. NewAMF0ECMAArray sets it to the number of
+// properties.
+type AMF0ECMAArray struct {
+	Properties []AMF0Property
+	Count      uint32
+}
+
+// NewAMF0ECMAArray returns an ECMA array of props with a matching count.
+func NewAMF0ECMAArray(props []AMF0Property) AMF0ECMAArray {
+	return AMF0ECMAArray{Properties: props, Count: uint32(len(props))}
+}
+
+// AMF0LongString is always encoded as an AMF0 long string. A plain string is
+// only encoded as a long string if it does not fit the 16-bit length of an
+// AMF0 string.
+type AMF0LongString string
+
+// AMF0Undefined is the AMF0 undefined value.
+type AMF0Undefined struct{}
+
+// AMF0Unsupported is the AMF0 unsupported value, sent in place of values
+// that have no AMF0 representation.
+type AMF0Unsupported struct{}
+
+// AMF0Reserved is a value with one of the reserved markers movieclip (0x04)
+// or recordset (0x0E). Neither has a defined body, so only the marker is
+// kept.
+type AMF0Reserved struct {
+	Marker byte
+}
+
+// AMF0XMLDocument is an AMF0 XML document, carried as a long string.
+type AMF0XMLDocument string
+
+// AMF0TypedObject is an object with a registered class name.
+type AMF0TypedObject struct {
+	ClassName  string
+	Properties []AMF0Property
+}
+
+// AMF0Kind is the AMF0 type of a value. Its values are the type markers.
+type AMF0Kind byte
+
+const (
+	AMF0KindNumber      AMF0Kind = amf0Number
+	AMF0KindBoolean     AMF0Kind = amf0Boolean
+	AMF0KindString      AMF0Kind = amf0String
+	AMF0KindObject      AMF0Kind = amf0Object
+	AMF0KindMovieClip   AMF0Kind = amf0MovieClip
+	AMF0KindNull        AMF0Kind = amf0Null
+	AMF0KindUndefined   AMF0Kind = amf0Undefined
+	AMF0KindReference   AMF0Kind = amf0Reference
+	AMF0KindECMAArray   AMF0Kind = amf0ECMAArray
+	AMF0KindStrictArray AMF0Kind = amf0StrictArr
+	AMF0KindDate        AMF0Kind = amf0Date
+	AMF0KindLongString  AMF0Kind = amf0LongString
+	AMF0KindUnsupported AMF0Kind = amf0Unsupported
+	AMF0KindRecordSet   AMF0Kind = amf0RecordSet
+	AMF0KindXMLDocument AMF0Kind = amf0XMLDocument
+	AMF0KindTypedObject AMF0Kind = amf0TypedObject
+	AMF0KindAVMPlus     AMF0Kind = amf0AVMPlus // AMF3-encoded value
+)
+
+var amf0KindNames = map[byte]string{
+	amf0Number:      "Number",
+	amf0Boolean:     "Boolean",
+	amf0String:      "String",
+	amf0Object:      "Object",
+	amf0MovieClip:   "MovieClip",
+	amf0Null:        "Null",
+	amf0Undefined:   "Undefined",
+	amf0Reference:   "Reference",
+	amf0ECMAArray:   "ECMAArray",
+	amf0StrictArr:   "StrictArray",
+	amf0Date:        "Date",
+	amf0LongString:  "LongString",
+	amf0Unsupported: "Unsupported",
+	amf0RecordSet:   "RecordSet",
+	amf0XMLDocument: "XMLDocument",
+	amf0TypedObject: "TypedObject",
+	amf0AVMPlus:     "AVMPlus",
+}
+
+func (k AMF0Kind) String() string {
+	return enumName(amf0KindNames, byte(k))
+}
+
+// AMF0Value is a decoded AMF0 value that keeps its exact AMF0 type and
+// encoding details, so that AppendAMF0 writes it back byte for byte. Only the
+// fields that apply to Kind are set.
+type AMF0Value struct {
+	Kind AMF0Kind
+
+	Number   float64 // Number; Date: milliseconds since the epoch
+	Bool     bool    // Boolean
+	String   string  // String, LongString, XMLDocument; TypedObject: class name
+	TimeZone int16   // Date: time zone offset in minutes
+
+	Members []AMF0Member // Object, ECMAArray, TypedObject
+	Count   uint32       // ECMAArray: associative count as encoded
+	Items   []AMF0Value  // StrictArray
+
+	// Reference: index into the object table of the message and the value
+	// it refers to (nil if the reference is invalid or points to an object
+	// that contains the reference).
+	Index  uint16
+	Target *AMF0Value
+
+	// AVMPlus: the AMF3 encoding following the 0x11 marker and its decoded
+	// value (see parseAMF3Value).
+	AMF3Data  []byte
+	AMF3Value any
+}
+
+// AMF0Member is a named member of an object, typed object or ECMA array.
+type AMF0Member struct {
+	Name  string
+	Value AMF0Value
+}
+
+// Time returns the date of a Date value in its encoded time zone.
+func (v *AMF0Value) Time() time.Time {
+	return time.UnixMilli(int64(v.Number)).In(time.FixedZone("", int(v.TimeZone)*60))
+}
+
+// Interface converts v to the untyped representation used by AMF0Property:
+//
+//	Number                  float64
+//	Boolean                 bool
+//	String                  string
+//	LongString              AMF0LongString
+//	Object                  []AMF0Property
+//	ECMAArray               AMF0ECMAArray (with the encoded count)
+//	TypedObject             AMF0TypedObject
+//	StrictArray             []any
+//	Date                    time.Time
+//	Null                    nil
+//	Undefined               AMF0Undefined
+//	Unsupported             AMF0Unsupported
+//	MovieClip, RecordSet    AMF0Reserved
+//	XMLDocument             AMF0XMLDocument
+//	Reference               the converted referenced value
+//	AVMPlus                 the AMF3 value
+func (v *AMF0Value) Interface() any {
+	return v.toInterface(map[*AMF0Value]bool{})
+}
+
+// toInterface converts v, resolving references at most once per path so
+// that cyclic references end in nil.
+func (v *AMF0Value) toInterface(resolving map[*AMF0Value]bool) any {
+	switch v.Kind {
+	case AMF0KindNumber:
+		return v.Number
+	case AMF0KindBoolean:
+		return v.Bool
+	case AMF0KindString:
+		return v.String
+	case AMF0KindLongString:
+		return AMF0LongString(v.String)
+	case AMF0KindObject:
+		return membersToProperties(v.Members, resolving)
+	case AMF0KindECMAArray:
+		return AMF0ECMAArray{Properties: membersToProperties(v.Members, resolving), Count: v.Count}
+	case AMF0KindTypedObject:
+		return AMF0TypedObject{ClassName: v.String, Properties: membersToProperties(v.Members, resolving)}
+	case AMF0KindStrictArray:
+		items := make([]any, 0, len(v.Items))
+		for i := range v.Items {
+			items = append(items, v.Items[i].toInterface(resolving))
+		}
+		return items
+	case AMF0KindDate:
+		return v.Time()
+	case AMF0KindUndefined:
+		return AMF0Undefined{}
+	case AMF0KindUnsupported:
+		return AMF0Unsupported{}
+	case AMF0KindMovieClip, AMF0KindRecordSet:
+		return AMF0Reserved{Marker: byte(v.Kind)}
+	case AMF0KindXMLDocument:
+		return AMF0XMLDocument(v.String)
+	case AMF0KindReference:
+		if v.Target == nil || resolving[v.Target] {
+			return nil
+		}
+		resolving[v.Target] = true
+		defer delete(resolving, v.Target)
+		return v.Target.toInterface(resolving)
+	case AMF0KindAVMPlus:
+		return v.AMF3Value
+	default:
+		return nil
+	}
+}
+
+func membersToProperties(members []AMF0Member, resolving map[*AMF0Value]bool) []AMF0Property {
+	props := make([]AMF0Property, 0, len(members))
+	for i := range members {
+		props = append(props, AMF0Property{Name: members[i].Name, Value: members[i].Value.toInterface(resolving)})
+	}
+	return props
+}
+
+// amf0Decoder decodes the AMF0 values of one message and keeps its object
+// reference table. Anonymous objects, typed objects, ECMA arrays and strict
+// arrays are added to the table in the order their markers are read.
+type amf0Decoder struct {
+	data    []byte
+	offset  int
+	objects []*AMF0Value
+}
+
+// DecodeAMF0 reads one AMF0-encoded value from data[offset:] and returns it
+// with its AMF0 type and the offset after it. Objects and arrays that fail
+// part-way are returned with the members decoded so far alongside the error.
+func DecodeAMF0(data []byte, offset int) (AMF0Value, int, error) {
+	d := &amf0Decoder{data: data, offset: offset}
+	v, err := d.readValue()
+	return v, d.offset, err
 }
 
 // parseAMF0Value reads one AMF0-encoded value from data[offset:] and returns
-// the decoded value and the new offset. Returns an error on malformed input.
+// the decoded value (see AMF0Value.Interface) and the new offset. Returns an
+// error on malformed input, together with the partial value of an object or
+// array.
 func parseAMF0Value(data []byte, offset int) (any, int, error) {
-	if offset >= len(data) {
-		return nil, offset, fmt.Errorf("AMF0: unexpected end of data")
+	v, next, err := DecodeAMF0(data, offset)
+	return v.Interface(), next, err
+}
+
+func (d *amf0Decoder) readValue() (AMF0Value, error) {
+	if d.offset >= len(d.data) {
+		return AMF0Value{}, fmt.Errorf("AMF0: unexpected end of data")
 	}
 
-	marker := data[offset]
-	offset++
+	marker := d.data[d.offset]
+	d.offset++
+	v := AMF0Value{Kind: AMF0Kind(marker)}
 
 	switch marker {
 	case amf0Number:
-		if offset+8 > len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated number")
+		b, err := d.readBytes(8, "number")
+		if err != nil {
+			return v, err
 		}
-		bits := binary.BigEndian.Uint64(data[offset : offset+8])
-		offset += 8
-		return math.Float64frombits(bits), offset, nil
+		v.Number = math.Float64frombits(binary.BigEndian.Uint64(b))
+		return v, nil
 
 	case amf0Boolean:
-		if offset >= len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated boolean")
+		b, err := d.readBytes(1, "boolean")
+		if err != nil {
+			return v, err
 		}
-		v := data[offset] != 0
-		offset++
-		return v, offset, nil
+		v.Bool = b[0] != 0
+		return v, nil
 
 	case amf0String:
-		s, newOff, err := readAMF0String(data, offset)
-		if err != nil {
-			return nil, offset, err
-		}
-		return s, newOff, nil
+		s, err := d.readString()
+		v.String = s
+		return v, err
 
 	case amf0Object:
-		return readAMF0Object(data, offset)
+		return d.readObject(v)
+
+	case amf0MovieClip, amf0RecordSet, amf0Null, amf0Undefined, amf0Unsupported:
+		return v, nil
 
-	case amf0Null, amf0Undefined:
-		return nil, offset, nil
+	case amf0Reference:
+		b, err := d.readBytes(2, "reference")
+		if err != nil {
+			return v, err
+		}
+		v.Index = binary.BigEndian.Uint16(b)
+		if int(v.Index) >= len(d.objects) {
+			return v, fmt.Errorf("AMF0: invalid reference %d at offset %d", v.Index, d.offset-3)
+		}
+		v.Target = d.objects[v.Index]
+		return v, nil
 
 	case amf0ECMAArray:
-		if offset+4 > len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated ECMA array count")
+		// The count is a hint only; members are read until the end marker.
+		b, err := d.readBytes(4, "ECMA array count")
+		if err != nil {
+			return v, err
 		}
-		// The count is approximate; we still read until the end marker.
-		offset += 4
-		return readAMF0Object(data, offset)
+		v.Count = binary.BigEndian.Uint32(b)
+		return d.readObject(v)
 
 	case amf0StrictArr:
-		if offset+4 > len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated strict array count")
+		b, err := d.readBytes(4, "strict array count")
+		if err != nil {
+			return v, err
+		}
+		count := binary.BigEndian.Uint32(b)
+		if uint64(count) > uint64(len(d.data)-d.offset) {
+			return v, fmt.Errorf("AMF0: strict array count %d exceeds data", count)
 		}
-		count := binary.BigEndian.Uint32(data[offset : offset+4])
-		offset += 4
-		arr := make([]any, 0, count)
+		obj := d.addObject(v)
+		obj.Items = make([]AMF0Value, 0, count)
 		for i := uint32(0); i < count; i++ {
-			v, newOff, err := parseAMF0Value(data, offset)
+			item, err := d.readValue()
 			if err != nil {
-				return nil, offset, err
+				return *obj, err
 			}
-			offset = newOff
-			arr = append(arr, v)
+			obj.Items = append(obj.Items, item)
 		}
-		return arr, offset, nil
+		return *obj, nil
 
 	case amf0Date:
-		if offset+10 > len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated date")
+		// [milliseconds since the epoch (DOUBLE)] [time zone offset in minutes (S16)]
+		b, err := d.readBytes(10, "date")
+		if err != nil {
+			return v, err
 		}
-		bits := binary.BigEndian.Uint64(data[offset : offset+8])
-		offset += 10 // 8 bytes timestamp + 2 bytes timezone
-		return math.Float64frombits(bits), offset, nil
+		v.Number = math.Float64frombits(binary.BigEndian.Uint64(b[0:8]))
+		v.TimeZone = int16(binary.BigEndian.Uint16(b[8:10]))
+		return v, nil
 
-	case amf0LongString:
-		if offset+4 > len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated long string length")
-		}
-		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-		offset += 4
-		if offset+length > len(data) {
-			return nil, offset, fmt.Errorf("AMF0: truncated long string")
+	case amf0LongString, amf0XMLDocument:
+		s, err := d.readLongString()
+		v.String = s
+		return v, err
+
+	case amf0TypedObject:
+		className, err := d.readString()
+		if err != nil {
+			return v, err
 		}
-		s := string(data[offset : offset+length])
-		offset += length
-		return s, offset, nil
+		v.String = className
+		return d.readObject(v)
+
+	case amf0AVMPlus:
+		// The next value is AMF3-encoded. The switch only applies to this
+		// value; the one after it is AMF0 again.
+		start := d.offset
+		amf3, next, err := parseAMF3Value(d.data, d.offset)
+		d.offset = next
+		v.AMF3Data = d.data[start:next]
+		v.AMF3Value = amf3
+		return v, err
 
 	default:
-		return nil, offset, fmt.Errorf("AMF0: unsupported type marker 0x%02X at offset %d", marker, offset-1)
+		return v, fmt.Errorf("AMF0: unsupported type marker 0x%02X at offset %d", marker, d.offset-1)
 	}
 }
 
-func readAMF0String(data []byte, offset int) (string, int, error) {
-	if offset+2 > len(data) {
-		return "", offset, fmt.Errorf("AMF0: truncated string length")
+func (d *amf0Decoder) readBytes(n int, what string) ([]byte, error) {
+	if d.offset+n > len(d.data) {
+		return nil, fmt.Errorf("AMF0: truncated %s", what)
 	}
-	length := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-	offset += 2
-	if offset+length > len(data) {
-		return "", offset, fmt.Errorf("AMF0: truncated string")
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+// addObject adds v to the reference table before its members are decoded
+// and returns the table entry to fill in.
+func (d *amf0Decoder) addObject(v AMF0Value) *AMF0Value {
+	obj := &v
+	d.objects = append(d.objects, obj)
+	return obj
+}
+
+// readString reads a UTF-8 string with a 16-bit length (without marker).
+func (d *amf0Decoder) readString() (string, error) {
+	b, err := d.readBytes(2, "string length")
+	if err != nil {
+		return "", err
+	}
+	s, err := d.readBytes(int(binary.BigEndian.Uint16(b)), "string")
+	return string(s), err
+}
+
+// readLongString reads a UTF-8 string with a 32-bit length (without marker).
+func (d *amf0Decoder) readLongString() (string, error) {
+	b, err := d.readBytes(4, "long string length")
+	if err != nil {
+		return "", err
 	}
-	s := string(data[offset : offset+length])
-	offset += length
-	return s, offset, nil
+	length := binary.BigEndian.Uint32(b)
+	if uint64(length) > uint64(len(d.data)-d.offset) {
+		return "", fmt.Errorf("AMF0: truncated long string")
+	}
+	s, err := d.readBytes(int(length), "long string")
+	return string(s), err
 }
 
-func readAMF0Object(data []byte, offset int) ([]amf0Property, int, error) {
-	var props []amf0Property
+// readObject reads the members of an object, typed object or ECMA array up
+// to the object end marker (0x00 0x00 0x09). On error the members read so far
+// are returned, the last one holding the partial value if it is an object or
+// array.
+func (d *amf0Decoder) readObject(v AMF0Value) (AMF0Value, error) {
+	obj := d.addObject(v)
 	for {
-		if offset+3 > len(data) {
-			return props, offset, fmt.Errorf("AMF0: truncated object")
+		if d.offset+3 > len(d.data) {
+			return *obj, fmt.Errorf("AMF0: truncated object")
 		}
-		// Check for object end marker: 0x00 0x00 0x09
-		if data[offset] == 0x00 && data[offset+1] == 0x00 && data[offset+2] == 0x09 {
-			offset += 3
-			return props, offset, nil
+		if d.data[d.offset] == 0x00 && d.data[d.offset+1] == 0x00 && d.data[d.offset+2] == amf0ObjectEnd {
+			d.offset += 3
+			return *obj, nil
 		}
-		name, newOff, err := readAMF0String(data, offset)
+		name, err := d.readString()
 		if err != nil {
-			return props, offset, err
+			return *obj, err
 		}
-		offset = newOff
 
-		value, newOff, err := parseAMF0Value(data, offset)
+		value, err := d.readValue()
 		if err != nil {
-			return props, offset, err
+			switch value.Kind {
+			case AMF0KindObject, AMF0KindECMAArray, AMF0KindTypedObject, AMF0KindStrictArray:
+				obj.Members = append(obj.Members, AMF0Member{Name: name, Value: value})
+			}
+			return *obj, fmt.Errorf("%s: %w", name, err)
 		}
-		offset = newOff
+		obj.Members = append(obj.Members, AMF0Member{Name: name, Value: value})
+	}
+}
+
+// amf0Properties returns the properties of an object or ECMA array value.
+func amf0Properties(v any) ([]AMF0Property, bool) {
+	switch v := v.(type) {
+	case []AMF0Property:
+		return v, true
+	case AMF0ECMAArray:
+		return v.Properties, true
+	}
+	return nil, false
+}
+
+// amf0StringValue returns the text of a string or long string value.
+func amf0StringValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case AMF0LongString:
+		return string(v), true
+	}
+	return "", false
+}
 
-		props = append(props, amf0Property{name: name, value: value})
+// amf0Lookup returns the value of the property called name.
+func amf0Lookup(props []AMF0Property, name string) (any, bool) {
+	for _, p := range props {
+		if p.Name == name {
+			return p.Value, true
+		}
 	}
+	return nil, false
 }
 
-func printAMF0Property(p amf0Property, indent int) {
+func printAMF0Property(p AMF0Property, indent int) {
 	prefix := ""
 	for i := 0; i < indent; i++ {
 		prefix += "  "
 	}
-	switch v := p.value.(type) {
-	case []amf0Property:
-		fmt.Printf("%s%s:\n", prefix, p.name)
+	switch v := p.Value.(type) {
+	case []AMF0Property:
+		fmt.Printf("%s%s:\n", prefix, p.Name)
 		for _, sub := range v {
 			printAMF0Property(sub, indent+1)
 		}
+	case AMF0ECMAArray:
+		fmt.Printf("%s%s:\n", prefix, p.Name)
+		for _, sub := range v.Properties {
+			printAMF0Property(sub, indent+1)
+		}
+	case AMF0TypedObject:
+		fmt.Printf("%s%s: (%s)\n", prefix, p.Name, v.ClassName)
+		for _, sub := range v.Properties {
+			printAMF0Property(sub, indent+1)
+		}
 	case float64:
 		n := int64(v)
 		if v == float64(n) {
-			if (p.name == "videocodecid" || p.name == "audiocodecid") && n > 15 {
+			if (p.Name == "videocodecid" || p.Name == "audiocodecid") && n > 15 {
 				// Values 0–15 are legacy CodecId's. Values > 15 are a FourCC from E-RTMP.
 				fourCC := [4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
-				fmt.Printf("%s%s: %d (%s)\n", prefix, p.name, n, string(fourCC[:]))
+				fmt.Printf("%s%s: %d (%s)\n", prefix, p.Name, n, string(fourCC[:]))
 			} else {
-				fmt.Printf("%s%s: %d\n", prefix, p.name, n)
+				fmt.Printf("%s%s: %d\n", prefix, p.Name, n)
 			}
 		} else {
-			fmt.Printf("%s%s: %g\n", prefix, p.name, v)
+			fmt.Printf("%s%s: %g\n", prefix, p.Name, v)
 		}
 	case bool:
-		fmt.Printf("%s%s: %t\n", prefix, p.name, v)
+		fmt.Printf("%s%s: %t\n", prefix, p.Name, v)
 	case string:
-		fmt.Printf("%s%s: %s\n", prefix, p.name, v)
+		fmt.Printf("%s%s: %s\n", prefix, p.Name, v)
+	case AMF0LongString:
+		fmt.Printf("%s%s: %s\n", prefix, p.Name, string(v))
 	case nil:
-		fmt.Printf("%s%s: null\n", prefix, p.name)
+		fmt.Printf("%s%s: null\n", prefix, p.Name)
+	case AMF0Undefined:
+		fmt.Printf("%s%s: undefined\n", prefix, p.Name)
+	case AMF0Unsupported:
+		fmt.Printf("%s%s: unsupported\n", prefix, p.Name)
+	case AMF0Reserved:
+		fmt.Printf("%s%s: reserved (marker 0x%02X)\n", prefix, p.Name, v.Marker)
+	case AMF0XMLDocument:
+		fmt.Printf("%s%s: %s\n", prefix, p.Name, string(v))
+	case time.Time:
+		fmt.Printf("%s%s: %s\n", prefix, p.Name, v.Format(time.RFC3339Nano))
+	case []byte:
+		fmt.Printf("%s%s: byte array (%d bytes)\n", prefix, p.Name, len(v))
 	default:
-		fmt.Printf("%s%s: %v\n", prefix, p.name, v)
+		fmt.Printf("%s%s: %v\n", prefix, p.Name, v)
+	}
+}
+
+// EncodeAMF0 returns the AMF0 encoding of v. See AppendAMF0 for the
+// supported Go types.
+func EncodeAMF0(v any) ([]byte, error) {
+	return AppendAMF0(nil, v)
+}
+
+// AppendAMF0 appends the AMF0 encoding of v to buf. An AMF0Value (as
+// returned by DecodeAMF0) is written exactly as it was decoded, so that
+// well-formed input survives decode and encode byte for byte. Other Go types
+// map to AMF0 as follows:
+//
+//	float64, float32 and integer types  number
+//	bool                                boolean
+//	string                              string (long string above 65535 bytes)
+//	AMF0LongString                      long string
+//	[]AMF0Property                      object
+//	AMF0ECMAArray                       ECMA array (with its Count)
+//	[]any                               strict array
+//	nil                                 null
+//	AMF0Undefined                       undefined
+//	AMF0Unsupported                     unsupported
+//	AMF0Reserved                        movieclip or recordset marker
+//	AMF0XMLDocument                     XML document
+//	AMF0TypedObject                     typed object
+//	time.Time                           date (time zone offset in minutes)
+func AppendAMF0(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case float64:
+		buf = append(buf, amf0Number)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v)), nil
+	case float32:
+		return AppendAMF0(buf, float64(v))
+	case int:
+		return AppendAMF0(buf, float64(v))
+	case int8:
+		return AppendAMF0(buf, float64(v))
+	case int16:
+		return AppendAMF0(buf, float64(v))
+	case int32:
+		return AppendAMF0(buf, float64(v))
+	case int64:
+		return AppendAMF0(buf, float64(v))
+	case uint:
+		return AppendAMF0(buf, float64(v))
+	case uint8:
+		return AppendAMF0(buf, float64(v))
+	case uint16:
+		return AppendAMF0(buf, float64(v))
+	case uint32:
+		return AppendAMF0(buf, float64(v))
+	case uint64:
+		return AppendAMF0(buf, float64(v))
+	case bool:
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		return append(buf, amf0Boolean, b), nil
+	case string:
+		if len(v) > 0xFFFF {
+			return AppendAMF0(buf, AMF0LongString(v))
+		}
+		buf = append(buf, amf0String)
+		return appendAMF0String(buf, v)
+	case AMF0LongString:
+		if uint64(len(v)) > math.MaxUint32 {
+			return buf, fmt.Errorf("AMF0: long string too long (%d bytes)", len(v))
+		}
+		buf = append(buf, amf0LongString)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		return append(buf, v...), nil
+	case []AMF0Property:
+		buf = append(buf, amf0Object)
+		return appendAMF0Properties(buf, v)
+	case AMF0ECMAArray:
+		buf = append(buf, amf0ECMAArray)
+		buf = binary.BigEndian.AppendUint32(buf, v.Count)
+		return appendAMF0Properties(buf, v.Properties)
+	case []any:
+		buf = append(buf, amf0StrictArr)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		for _, item := range v {
+			var err error
+			if buf, err = AppendAMF0(buf, item); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	case nil:
+		return append(buf, amf0Null), nil
+	case AMF0Undefined:
+		return append(buf, amf0Undefined), nil
+	case AMF0Unsupported:
+		return append(buf, amf0Unsupported), nil
+	case AMF0Reserved:
+		if v.Marker != amf0MovieClip && v.Marker != amf0RecordSet {
+			return buf, fmt.Errorf("AMF0: 0x%02X is not a reserved marker", v.Marker)
+		}
+		return append(buf, v.Marker), nil
+	case AMF0XMLDocument:
+		if uint64(len(v)) > math.MaxUint32 {
+			return buf, fmt.Errorf("AMF0: XML document too long (%d bytes)", len(v))
+		}
+		buf = append(buf, amf0XMLDocument)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		return append(buf, v...), nil
+	case AMF0TypedObject:
+		buf = append(buf, amf0TypedObject)
+		buf, err := appendAMF0String(buf, v.ClassName)
+		if err != nil {
+			return buf, err
+		}
+		return appendAMF0Properties(buf, v.Properties)
+	case time.Time:
+		_, offset := v.Zone()
+		buf = append(buf, amf0Date)
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(float64(v.UnixMilli())))
+		return binary.BigEndian.AppendUint16(buf, uint16(int16(offset/60))), nil
+	case AMF0Value:
+		return appendAMF0Value(buf, &v)
+	case *AMF0Value:
+		return appendAMF0Value(buf, v)
+	default:
+		return buf, fmt.Errorf("AMF0: cannot encode value of type %T", v)
+	}
+}
+
+// appendAMF0Value appends v with its recorded kind and encoding details.
+func appendAMF0Value(buf []byte, v *AMF0Value) ([]byte, error) {
+	var err error
+	buf = append(buf, byte(v.Kind))
+	switch v.Kind {
+	case AMF0KindNumber:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Number)), nil
+	case AMF0KindBoolean:
+		if v.Bool {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case AMF0KindString:
+		return appendAMF0String(buf, v.String)
+	case AMF0KindLongString, AMF0KindXMLDocument:
+		if uint64(len(v.String)) > math.MaxUint32 {
+			return buf, fmt.Errorf("AMF0: %s too long (%d bytes)", v.Kind, len(v.String))
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v.String)))
+		return append(buf, v.String...), nil
+	case AMF0KindObject:
+		return appendAMF0Members(buf, v.Members)
+	case AMF0KindECMAArray:
+		buf = binary.BigEndian.AppendUint32(buf, v.Count)
+		return appendAMF0Members(buf, v.Members)
+	case AMF0KindTypedObject:
+		if buf, err = appendAMF0String(buf, v.String); err != nil {
+			return buf, err
+		}
+		return appendAMF0Members(buf, v.Members)
+	case AMF0KindStrictArray:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v.Items)))
+		for i := range v.Items {
+			if buf, err = appendAMF0Value(buf, &v.Items[i]); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	case AMF0KindDate:
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Number))
+		return binary.BigEndian.AppendUint16(buf, uint16(v.TimeZone)), nil
+	case AMF0KindReference:
+		return binary.BigEndian.AppendUint16(buf, v.Index), nil
+	case AMF0KindAVMPlus:
+		return append(buf, v.AMF3Data...), nil
+	case AMF0KindMovieClip, AMF0KindRecordSet, AMF0KindNull, AMF0KindUndefined, AMF0KindUnsupported:
+		return buf, nil
+	default:
+		return buf[:len(buf)-1], fmt.Errorf("AMF0: cannot encode kind %s", v.Kind)
+	}
+}
+
+func appendAMF0Members(buf []byte, members []AMF0Member) ([]byte, error) {
+	var err error
+	for i := range members {
+		if buf, err = appendAMF0String(buf, members[i].Name); err != nil {
+			return buf, err
+		}
+		if buf, err = appendAMF0Value(buf, &members[i].Value); err != nil {
+			return buf, err
+		}
+	}
+	return append(buf, 0x00, 0x00, 0x09), nil
+}
+
+// appendAMF0String appends a length-prefixed UTF-8 string without a type
+// marker, as used for property names.
+func appendAMF0String(buf []byte, s string) ([]byte, error) {
+	if len(s) > 0xFFFF {
+		return buf, fmt.Errorf("AMF0: string too long (%d bytes)", len(s))
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...), nil
+}
+
+// appendAMF0Properties appends name/value pairs followed by the object end
+// marker.
+func appendAMF0Properties(buf []byte, props []AMF0Property) ([]byte, error) {
+	var err error
+	for _, p := range props {
+		if buf, err = appendAMF0String(buf, p.Name); err != nil {
+			return buf, err
+		}
+		if buf, err = AppendAMF0(buf, p.Value); err != nil {
+			return buf, err
+		}
 	}
+	return append(buf, 0x00, 0x00, 0x09), nil
 }
@@ -0,0 +1,221 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// amf0Bytes concatenates byte slices and strings into one AMF0 encoding.
+func amf0Bytes(parts ...any) []byte {
+	var b []byte
+	for _, p := range parts {
+		switch p := p.(type) {
+		case byte:
+			b = append(b, p)
+		case int:
+			b = append(b, byte(p))
+		case []byte:
+			b = append(b, p...)
+		case string:
+			b = append(b, p...)
+		}
+	}
+	return b
+}
+
+func u16(n uint16) []byte { return binary.BigEndian.AppendUint16(nil, n) }
+func u32(n uint32) []byte { return binary.BigEndian.AppendUint32(nil, n) }
+func f64(f float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(f))
+}
+
+var amf0ObjectEndBytes = []byte{0x00, 0x00, 0x09}
+
+func TestAMF0DecodeEncode(t *testing.T) {
+	dateMillis := float64(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).UnixMilli())
+
+	tests := []struct {
+		name string
+		data []byte
+		kind AMF0Kind
+		want any // result of Interface
+	}{
+		{"number", amf0Bytes(0x00, f64(29.97)), AMF0KindNumber, 29.97},
+		{"boolean", amf0Bytes(0x01, 0x01), AMF0KindBoolean, true},
+		{"string", amf0Bytes(0x02, u16(3), "avc"), AMF0KindString, "avc"},
+		{"object", amf0Bytes(0x03, u16(5), "width", 0x00, f64(1920), amf0ObjectEndBytes),
+			AMF0KindObject, []AMF0Property{{Name: "width", Value: 1920.0}}},
+		{"movieclip", amf0Bytes(0x04), AMF0KindMovieClip, AMF0Reserved{Marker: 0x04}},
+		{"null", amf0Bytes(0x05), AMF0KindNull, nil},
+		{"undefined", amf0Bytes(0x06), AMF0KindUndefined, AMF0Undefined{}},
+		// The strict array is object 0 and the anonymous object object 1.
+		{"reference", amf0Bytes(0x0A, u32(2),
+			0x03, u16(1), "a", 0x01, 0x00, amf0ObjectEndBytes,
+			0x07, u16(1)),
+			AMF0KindStrictArray, []any{
+				[]AMF0Property{{Name: "a", Value: false}},
+				[]AMF0Property{{Name: "a", Value: false}},
+			}},
+		{"ECMA array", amf0Bytes(0x08, u32(1), u16(8), "duration", 0x00, f64(10), amf0ObjectEndBytes),
+			AMF0KindECMAArray, AMF0ECMAArray{Properties: []AMF0Property{{Name: "duration", Value: 10.0}}, Count: 1}},
+		{"ECMA array count hint 0", amf0Bytes(0x08, u32(0), u16(1), "a", 0x05, u16(1), "b", 0x05, amf0ObjectEndBytes),
+			AMF0KindECMAArray, AMF0ECMAArray{Properties: []AMF0Property{{Name: "a"}, {Name: "b"}}, Count: 0}},
+		{"ECMA array count hint too large", amf0Bytes(0x08, u32(7), amf0ObjectEndBytes),
+			AMF0KindECMAArray, AMF0ECMAArray{Properties: []AMF0Property{}, Count: 7}},
+		{"strict array", amf0Bytes(0x0A, u32(2), 0x00, f64(1), 0x02, u16(1), "x"),
+			AMF0KindStrictArray, []any{1.0, "x"}},
+		{"date UTC", amf0Bytes(0x0B, f64(dateMillis), u16(0)),
+			AMF0KindDate, time.UnixMilli(int64(dateMillis)).In(time.FixedZone("", 0))},
+		{"date with time zone", amf0Bytes(0x0B, f64(dateMillis), u16(0xFF88)), // -120 minutes
+			AMF0KindDate, time.UnixMilli(int64(dateMillis)).In(time.FixedZone("", -7200))},
+		{"long string", amf0Bytes(0x0C, u32(5), "hello"), AMF0KindLongString, AMF0LongString("hello")},
+		{"unsupported", amf0Bytes(0x0D), AMF0KindUnsupported, AMF0Unsupported{}},
+		{"recordset", amf0Bytes(0x0E), AMF0KindRecordSet, AMF0Reserved{Marker: 0x0E}},
+		{"XML document", amf0Bytes(0x0F, u32(4), "<a/>"), AMF0KindXMLDocument, AMF0XMLDocument("<a/>")},
+		{"typed object", amf0Bytes(0x10, u16(5), "Point", u16(1), "x", 0x00, f64(3), amf0ObjectEndBytes),
+			AMF0KindTypedObject, AMF0TypedObject{ClassName: "Point", Properties: []AMF0Property{{Name: "x", Value: 3.0}}}},
+		{"AMF3 integer", amf0Bytes(0x11, 0x04, 0x05), AMF0KindAVMPlus, 5.0},
+		{"AMF3 string", amf0Bytes(0x11, 0x06, 0x07, "abc"), AMF0KindAVMPlus, "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, next, err := DecodeAMF0(tt.data, 0)
+			if err != nil {
+				t.Fatalf("DecodeAMF0: %v", err)
+			}
+			if next != len(tt.data) {
+				t.Errorf("DecodeAMF0 offset = %d, want %d", next, len(tt.data))
+			}
+			if v.Kind != tt.kind {
+				t.Errorf("Kind = %s, want %s", v.Kind, tt.kind)
+			}
+
+			got := v.Interface()
+			if want, ok := tt.want.(time.Time); ok {
+				gt, ok := got.(time.Time)
+				_, gotOffset := gt.Zone()
+				_, wantOffset := want.Zone()
+				if !ok || !gt.Equal(want) || gotOffset != wantOffset {
+					t.Errorf("Interface() = %v, want %v", got, want)
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Interface() = %#v, want %#v", got, tt.want)
+			}
+
+			out, err := AppendAMF0(nil, v)
+			if err != nil {
+				t.Fatalf("AppendAMF0: %v", err)
+			}
+			if !bytes.Equal(out, tt.data) {
+				t.Errorf("AppendAMF0 = % X, want % X", out, tt.data)
+			}
+		})
+	}
+}
+
+func TestAMF0ECMAArrayCountSurvivesInterface(t *testing.T) {
+	for _, count := range []uint32{0, 1, 9} {
+		data := amf0Bytes(0x08, u32(count), u16(5), "width", 0x00, f64(640), amf0ObjectEndBytes)
+		v, _, err := DecodeAMF0(data, 0)
+		if err != nil {
+			t.Fatalf("DecodeAMF0: %v", err)
+		}
+		out, err := AppendAMF0(nil, v.Interface())
+		if err != nil {
+			t.Fatalf("AppendAMF0: %v", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("count %d: AppendAMF0(Interface()) = % X, want % X", count, out, data)
+		}
+	}
+}
+
+func TestAMF0EncodeDecode(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("", 90*60))
+	long := string(bytes.Repeat([]byte{'x'}, 0x10000))
+
+	tests := []struct {
+		name string
+		in   any
+		kind AMF0Kind
+		want any // result of Interface; nil means in
+	}{
+		{"float64", 2.5, AMF0KindNumber, nil},
+		{"int", 1920, AMF0KindNumber, 1920.0},
+		{"uint32", uint32(7), AMF0KindNumber, 7.0},
+		{"bool", false, AMF0KindBoolean, nil},
+		{"string", "onMetaData", AMF0KindString, nil},
+		{"string over 65535 bytes", long, AMF0KindLongString, AMF0LongString(long)},
+		{"long string", AMF0LongString("short"), AMF0KindLongString, nil},
+		{"object", []AMF0Property{{Name: "a", Value: 1.0}, {Name: "b", Value: "c"}}, AMF0KindObject, nil},
+		{"ECMA array", NewAMF0ECMAArray([]AMF0Property{{Name: "duration", Value: 10.0}}), AMF0KindECMAArray, nil},
+		{"ECMA array count", AMF0ECMAArray{Properties: []AMF0Property{{Name: "a", Value: nil}}, Count: 0}, AMF0KindECMAArray, nil},
+		{"strict array", []any{1.0, true, nil}, AMF0KindStrictArray, nil},
+		{"null", nil, AMF0KindNull, nil},
+		{"undefined", AMF0Undefined{}, AMF0KindUndefined, nil},
+		{"unsupported", AMF0Unsupported{}, AMF0KindUnsupported, nil},
+		{"recordset", AMF0Reserved{Marker: 0x0E}, AMF0KindRecordSet, nil},
+		{"XML document", AMF0XMLDocument("<x/>"), AMF0KindXMLDocument, nil},
+		{"typed object", AMF0TypedObject{ClassName: "C", Properties: []AMF0Property{{Name: "n", Value: 1.0}}}, AMF0KindTypedObject, nil},
+		{"date", date, AMF0KindDate, nil},
+		{"nested", []AMF0Property{{Name: "colorInfo", Value: []AMF0Property{{Name: "colorConfig", Value: []AMF0Property{}}}}}, AMF0KindObject, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := EncodeAMF0(tt.in)
+			if err != nil {
+				t.Fatalf("EncodeAMF0: %v", err)
+			}
+			v, next, err := DecodeAMF0(data, 0)
+			if err != nil {
+				t.Fatalf("DecodeAMF0: %v", err)
+			}
+			if next != len(data) {
+				t.Errorf("DecodeAMF0 offset = %d, want %d", next, len(data))
+			}
+			if v.Kind != tt.kind {
+				t.Errorf("Kind = %s, want %s", v.Kind, tt.kind)
+			}
+
+			want := tt.want
+			if want == nil {
+				want = tt.in
+			}
+			got := v.Interface()
+			if wt, ok := want.(time.Time); ok {
+				gt, ok := got.(time.Time)
+				_, gotOffset := gt.Zone()
+				_, wantOffset := wt.Zone()
+				if !ok || !gt.Equal(wt) || gotOffset != wantOffset {
+					t.Errorf("Interface() = %v, want %v", got, wt)
+				}
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("Interface() = %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestAMF0EncodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+	}{
+		{"unknown type", struct{}{}},
+		{"invalid reserved marker", AMF0Reserved{Marker: 0x05}},
+		{"property name too long", []AMF0Property{{Name: string(make([]byte, 0x10000))}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncodeAMF0(tt.in); err == nil {
+				t.Errorf("EncodeAMF0(%T) succeeded, want error", tt.in)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// AMF3 type markers.
+const (
+	amf3Undefined    = 0x00
+	amf3Null         = 0x01
+	amf3False        = 0x02
+	amf3True         = 0x03
+	amf3Integer      = 0x04
+	amf3Double       = 0x05
+	amf3String       = 0x06
+	amf3XMLDoc       = 0x07
+	amf3Date         = 0x08
+	amf3Array        = 0x09
+	amf3Object       = 0x0A
+	amf3XML          = 0x0B
+	amf3ByteArray    = 0x0C
+	amf3VectorInt    = 0x0D
+	amf3VectorUint   = 0x0E
+	amf3VectorDouble = 0x0F
+	amf3VectorObject = 0x10
+	amf3Dictionary   = 0x11
+)
+
+// amf3Traits describes the class of an AMF3 object.
+type amf3Traits struct {
+	className      string
+	externalizable bool
+	dynamic        bool
+	sealed         []string
+}
+
+// amf3Decoder decodes AMF3 values and keeps the string, object and trait
+// reference tables of one AMF3 context. A context starts at every AMF0
+// avmplus-object-marker.
+type amf3Decoder struct {
+	data    []byte
+	offset  int
+	strings []string
+	objects []any
+	traits  []amf3Traits
+}
+
+// parseAMF3Value reads one AMF3-encoded value from data[offset:] with fresh
+// reference tables and returns the decoded value and the new offset.
+//
+// AMF3 values are decoded into the same Go types as AMF0 values so that
+// callers can treat both alike: integers and doubles become float64, XML
+// becomes string, objects become []AMF0Property (sealed members first, then
+// dynamic members), arrays become []any, or []AMF0Property when they have
+// associative members (dense members are then named by their index), and
+// vectors become []any. Dates become time.Time, byte arrays []byte, and
+// dictionaries []AMF0Property whose names are the formatted keys.
+func parseAMF3Value(data []byte, offset int) (any, int, error) {
+	d := &amf3Decoder{data: data, offset: offset}
+	v, err := d.readValue()
+	return v, d.offset, err
+}
+
+func (d *amf3Decoder) readValue() (any, error) {
+	marker, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf3Undefined:
+		return AMF0Undefined{}, nil
+	case amf3Null:
+		return nil, nil
+	case amf3False:
+		return false, nil
+	case amf3True:
+		return true, nil
+	case amf3Integer:
+		u, err := d.readU29()
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend the 29-bit integer.
+		return float64(int32(u<<3) >> 3), nil
+	case amf3Double:
+		if d.offset+8 > len(d.data) {
+			return nil, fmt.Errorf("AMF3: truncated double")
+		}
+		bits := binary.BigEndian.Uint64(d.data[d.offset : d.offset+8])
+		d.offset += 8
+		return math.Float64frombits(bits), nil
+	case amf3String:
+		return d.readString()
+	case amf3XMLDoc, amf3XML:
+		return d.readXML()
+	case amf3Date:
+		return d.readDate()
+	case amf3Array:
+		return d.readArray()
+	case amf3Object:
+		return d.readObject()
+	case amf3ByteArray:
+		return d.readByteArray()
+	case amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+		return d.readVector(marker)
+	case amf3Dictionary:
+		return d.readDictionary()
+	default:
+		return nil, fmt.Errorf("AMF3: unsupported type marker 0x%02X at offset %d", marker, d.offset-1)
+	}
+}
+
+func (d *amf3Decoder) readByte() (byte, error) {
+	if d.offset >= len(d.data) {
+		return 0, fmt.Errorf("AMF3: unexpected end of data")
+	}
+	b := d.data[d.offset]
+	d.offset++
+	return b, nil
+}
+
+// readU29 reads a variable-length unsigned 29-bit integer: up to three bytes
+// carrying 7 bits each with the high bit as continuation flag, then one byte
+// carrying 8 bits.
+func (d *amf3Decoder) readU29() (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		b, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if i == 3 {
+			return v<<8 | uint32(b), nil
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v, nil
+}
+
+// readReference reads a U29 header. If its low bit is clear the header is a
+// reference and ref holds the table index; otherwise n holds the remaining
+// bits (usually a length or count).
+func (d *amf3Decoder) readReference() (n int, ref int, isRef bool, err error) {
+	u, err := d.readU29()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if u&1 == 0 {
+		return 0, int(u >> 1), true, nil
+	}
+	return int(u >> 1), 0, false, nil
+}
+
+func (d *amf3Decoder) objectRef(ref int) (any, error) {
+	if ref >= len(d.objects) {
+		return nil, fmt.Errorf("AMF3: invalid object reference %d", ref)
+	}
+	return d.objects[ref], nil
+}
+
+// addObject reserves a slot in the object table before the value is decoded,
+// as required for the numbering of nested references, and returns a function
+// that fills it in.
+func (d *amf3Decoder) addObject() func(any) {
+	i := len(d.objects)
+	d.objects = append(d.objects, nil)
+	return func(v any) { d.objects[i] = v }
+}
+
+func (d *amf3Decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || d.offset+n > len(d.data) {
+		return nil, fmt.Errorf("AMF3: truncated data")
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+// readString reads a UTF-8-vr string (without marker).
+func (d *amf3Decoder) readString() (string, error) {
+	n, ref, isRef, err := d.readReference()
+	if err != nil {
+		return "", err
+	}
+	if isRef {
+		if ref >= len(d.strings) {
+			return "", fmt.Errorf("AMF3: invalid string reference %d", ref)
+		}
+		return d.strings[ref], nil
+	}
+	b, err := d.readBytes(n)
+	if err != nil {
+		return "", err
+	}
+	s := string(b)
+	if s != "" {
+		// The empty string is never sent by reference.
+		d.strings = append(d.strings, s)
+	}
+	return s, nil
+}
+
+func (d *amf3Decoder) readXML() (any, error) {
+	n, ref, isRef, err := d.readReference()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return d.objectRef(ref)
+	}
+	b, err := d.readBytes(n)
+	if err != nil {
+		return nil, err
+	}
+	d.objects = append(d.objects, string(b))
+	return string(b), nil
+}
+
+func (d *amf3Decoder) readDate() (any, error) {
+	_, ref, isRef, err := d.readReference()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return d.objectRef(ref)
+	}
+	b, err := d.readBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	ms := math.Float64frombits(binary.BigEndian.Uint64(b))
+	t := time.UnixMilli(int64(ms)).UTC()
+	d.objects = append(d.objects, t)
+	return t, nil
+}
+
+func (d *amf3Decoder) readArray() (any, error) {
+	count, ref, isRef, err := d.readReference()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return d.objectRef(ref)
+	}
+	set := d.addObject()
+
+	var assoc []AMF0Property
+	for {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			break
+		}
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		assoc = append(assoc, AMF0Property{Name: key, Value: v})
+	}
+
+	if count > len(d.data)-d.offset {
+		return nil, fmt.Errorf("AMF3: array count %d exceeds data", count)
+	}
+	dense := make([]any, 0, count)
+	for i := 0; i < count; i++ {
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		dense = append(dense, v)
+	}
+
+	if assoc == nil {
+		set(dense)
+		return dense, nil
+	}
+	for i, v := range dense {
+		assoc = append(assoc, AMF0Property{Name: strconv.Itoa(i), Value: v})
+	}
+	set(assoc)
+	return assoc, nil
+}
+
+func (d *amf3Decoder) readObject() (any, error) {
+	u, err := d.readU29()
+	if err != nil {
+		return nil, err
+	}
+	if u&1 == 0 {
+		return d.objectRef(int(u >> 1))
+	}
+	set := d.addObject()
+
+	var traits amf3Traits
+	if u&2 == 0 {
+		ref := int(u >> 2)
+		if ref >= len(d.traits) {
+			return nil, fmt.Errorf("AMF3: invalid traits reference %d", ref)
+		}
+		traits = d.traits[ref]
+	} else {
+		traits.externalizable = u&4 != 0
+		traits.dynamic = u&8 != 0
+		sealedCount := int(u >> 4)
+		if traits.className, err = d.readString(); err != nil {
+			return nil, err
+		}
+		if sealedCount > len(d.data)-d.offset {
+			return nil, fmt.Errorf("AMF3: sealed member count %d exceeds data", sealedCount)
+		}
+		for i := 0; i < sealedCount; i++ {
+			name, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			traits.sealed = append(traits.sealed, name)
+		}
+		d.traits = append(d.traits, traits)
+	}
+
+	if traits.externalizable {
+		switch traits.className {
+		case "flex.messaging.io.ArrayCollection", "flex.messaging.io.ObjectProxy":
+			// Serialized as the wrapped array or object.
+			v, err := d.readValue()
+			if err != nil {
+				return nil, err
+			}
+			set(v)
+			return v, nil
+		default:
+			return nil, fmt.Errorf("AMF3: cannot decode externalizable class %q", traits.className)
+		}
+	}
+
+	props := make([]AMF0Property, 0, len(traits.sealed))
+	for _, name := range traits.sealed {
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, AMF0Property{Name: name, Value: v})
+	}
+	if traits.dynamic {
+		for {
+			name, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			if name == "" {
+				break
+			}
+			v, err := d.readValue()
+			if err != nil {
+				return nil, err
+			}
+			props = append(props, AMF0Property{Name: name, Value: v})
+		}
+	}
+	set(props)
+	return props, nil
+}
+
+func (d *amf3Decoder) readByteArray() (any, error) {
+	n, ref, isRef, err := d.readReference()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return d.objectRef(ref)
+	}
+	b, err := d.readBytes(n)
+	if err != nil {
+		return nil, err
+	}
+	d.objects = append(d.objects, b)
+	return b, nil
+}
+
+func (d *amf3Decoder) readVector(marker byte) (any, error) {
+	count, ref, isRef, err := d.readReference()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return d.objectRef(ref)
+	}
+	set := d.addObject()
+
+	if _, err := d.readByte(); err != nil { // fixed-vector flag
+		return nil, err
+	}
+	if marker == amf3VectorObject {
+		if _, err := d.readString(); err != nil { // object type name
+			return nil, err
+		}
+	}
+	if count > len(d.data)-d.offset {
+		return nil, fmt.Errorf("AMF3: vector count %d exceeds data", count)
+	}
+
+	items := make([]any, 0, count)
+	for i := 0; i < count; i++ {
+		switch marker {
+		case amf3VectorInt, amf3VectorUint:
+			b, err := d.readBytes(4)
+			if err != nil {
+				return nil, err
+			}
+			n := binary.BigEndian.Uint32(b)
+			if marker == amf3VectorInt {
+				items = append(items, float64(int32(n)))
+			} else {
+				items = append(items, float64(n))
+			}
+		case amf3VectorDouble:
+			b, err := d.readBytes(8)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		default:
+			v, err := d.readValue()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+	}
+	set(items)
+	return items, nil
+}
+
+func (d *amf3Decoder) readDictionary() (any, error) {
+	count, ref, isRef, err := d.readReference()
+	if err != nil {
+		return nil, err
+	}
+	if isRef {
+		return d.objectRef(ref)
+	}
+	set := d.addObject()
+
+	if _, err := d.readByte(); err != nil { // weak-keys flag
+		return nil, err
+	}
+	if count > len(d.data)-d.offset {
+		return nil, fmt.Errorf("AMF3: dictionary count %d exceeds data", count)
+	}
+
+	props := make([]AMF0Property, 0, count)
+	for i := 0; i < count; i++ {
+		key, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, AMF0Property{Name: fmt.Sprint(key), Value: v})
+	}
+	set(props)
+	return props, nil
+}
@@ -0,0 +1,202 @@
+package flv
+
+import "fmt"
+
+// AudioPacketType is the E-RTMP AudioPacketType. Legacy AAC packets are
+// mapped onto it (sequence header → SequenceStart, raw → CodedFrames).
+type AudioPacketType byte
+
+const (
+	AudioPacketTypeSequenceStart      AudioPacketType = 0
+	AudioPacketTypeCodedFrames        AudioPacketType = 1
+	AudioPacketTypeSequenceEnd        AudioPacketType = 2
+	AudioPacketTypeMultichannelConfig AudioPacketType = 4
+	AudioPacketTypeMultitrack         AudioPacketType = 5
+	AudioPacketTypeModEx              AudioPacketType = 7
+)
+
+func (t AudioPacketType) String() string {
+	return enumName(audioPacketTypeNames, byte(t))
+}
+
+// AudioPacket is a decoded audio tag payload.
+type AudioPacket struct {
+	Enhanced bool // ExAudioTagHeader; false for the legacy AudioTagHeader
+	// PacketType is the packet type of the tracks. For Multitrack packets it
+	// is the packet type shared by all tracks, Multitrack is set and
+	// MultitrackType holds the AvMultitrackType (0 OneTrack, 1 ManyTracks,
+	// 2 ManyTracksManyCodecs).
+	PacketType     AudioPacketType
+	Multitrack     bool
+	MultitrackType byte
+
+	// Legacy AudioTagHeader fields, zero for enhanced packets.
+	SoundFormat byte
+	SoundRate   byte // 0 5.5 kHz, 1 11 kHz, 2 22 kHz, 3 44 kHz
+	SoundSize   byte // 0 8-bit, 1 16-bit
+	SoundType   byte // 0 mono, 1 stereo
+
+	// ModEx holds the packet modifier extensions that preceded the packet
+	// type, in bitstream order. TimestampOffsetNano (ModExType 0) is also
+	// decoded into the fields below; it refines the tag timestamp by up to
+	// 999999 ns and applies to every track of the packet.
+	ModEx                  []ModEx
+	HasTimestampOffsetNano bool
+	TimestampOffsetNano    uint32
+
+	Tracks []AudioTrack
+}
+
+// AudioTrack is the body of one track of an audio packet. Single-track
+// packets have exactly one track with TrackID 0.
+type AudioTrack struct {
+	TrackID byte
+	FourCC  string // "mp4a"/".mp3" for legacy AAC/MP3, "" for other legacy formats
+	// Data is the track body. It aliases the slice passed to
+	// ParseAudioPacket.
+	Data []byte
+}
+
+// AudioPacket decodes the payload of an audio tag.
+func (t *Tag) AudioPacket() (*AudioPacket, error) {
+	if t.Type != TagTypeAudio {
+		return nil, fmt.Errorf("not an audio tag: %s", t.Type)
+	}
+	return ParseAudioPacket(t.Data)
+}
+
+// ParseAudioPacket decodes an audio tag payload with either a legacy
+// AudioTagHeader or an E-RTMP ExAudioTagHeader:
+//
+//	Legacy:      [SoundFormat(4)|SoundRate(2)|SoundSize(1)|SoundType(1)] ([AACPacketType(1)] for AAC) [body...]
+//	Enhanced:    [SoundFormat=9(4)|PacketType(4)] [FourCC(4)] [body...]
+//	Multitrack:  [SoundFormat=9(4)|PacketType=5(4)] [AvMultitrackType(4)|PacketType(4)]
+//	             ([FourCC(4)]) then per track ([FourCC(4)]) [TrackID(1)] ([Size(3)]) [body]
+//	ModEx:       [header with PacketType=7] then per modifier [Size(1 or 3)] [modExData]
+//	             [ModExType(4)|PacketType(4)] while PacketType is ModEx, then one of the above
+func ParseAudioPacket(data []byte) (*AudioPacket, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("empty audio tag")
+	}
+
+	if data[0]>>4 != soundFormatExAudio {
+		return parseLegacyAudioPacket(data)
+	}
+
+	pkt := &AudioPacket{
+		Enhanced:   true,
+		PacketType: AudioPacketType(data[0] & 0x0F),
+	}
+	pos := 1
+
+	if pkt.PacketType == AudioPacketTypeModEx {
+		modEx, packetType, next, err := readModEx(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		pkt.ModEx = modEx.entries
+		pkt.HasTimestampOffsetNano = modEx.hasNano
+		pkt.TimestampOffsetNano = modEx.nanoOffset
+		pkt.PacketType = AudioPacketType(packetType)
+		pos = next
+	}
+
+	if pkt.PacketType != AudioPacketTypeMultitrack {
+		if len(data) < pos+4 {
+			return nil, fmt.Errorf("truncated ExAudioTagHeader")
+		}
+		pkt.Tracks = []AudioTrack{{FourCC: string(data[pos : pos+4]), Data: data[pos+4:]}}
+		return pkt, nil
+	}
+
+	if len(data) < pos+1 {
+		return nil, fmt.Errorf("truncated multitrack header")
+	}
+	pkt.Multitrack = true
+	pkt.MultitrackType = data[pos] >> 4
+	pkt.PacketType = AudioPacketType(data[pos] & 0x0F)
+	pos++
+	if pkt.PacketType == AudioPacketTypeMultitrack || pkt.PacketType == AudioPacketTypeModEx {
+		return nil, fmt.Errorf("invalid packet type %s inside multitrack packet", pkt.PacketType)
+	}
+	if pkt.MultitrackType > avMultitrackManyTracksManyCodecs {
+		return nil, fmt.Errorf("unknown AvMultitrackType %d", pkt.MultitrackType)
+	}
+
+	var sharedFourCC string
+	if pkt.MultitrackType != avMultitrackManyTracksManyCodecs {
+		if len(data) < pos+4 {
+			return nil, fmt.Errorf("truncated multitrack header")
+		}
+		sharedFourCC = string(data[pos : pos+4])
+		pos += 4
+	}
+
+	for pos < len(data) {
+		track := AudioTrack{FourCC: sharedFourCC}
+		if pkt.MultitrackType == avMultitrackManyTracksManyCodecs {
+			if len(data) < pos+4 {
+				return nil, fmt.Errorf("truncated track FourCC")
+			}
+			track.FourCC = string(data[pos : pos+4])
+			pos += 4
+		}
+		if len(data) < pos+1 {
+			return nil, fmt.Errorf("truncated track ID")
+		}
+		track.TrackID = data[pos]
+		pos++
+
+		end := len(data)
+		if pkt.MultitrackType != avMultitrackOneTrack {
+			if len(data) < pos+3 {
+				return nil, fmt.Errorf("truncated track size")
+			}
+			size := int(data[pos])<<16 | int(data[pos+1])<<8 | int(data[pos+2])
+			pos += 3
+			if pos+size > len(data) {
+				return nil, fmt.Errorf("track %d: size %d exceeds packet", track.TrackID, size)
+			}
+			end = pos + size
+		}
+
+		track.Data = data[pos:end]
+		pkt.Tracks = append(pkt.Tracks, track)
+		pos = end
+		if pkt.MultitrackType == avMultitrackOneTrack {
+			break
+		}
+	}
+	return pkt, nil
+}
+
+func parseLegacyAudioPacket(data []byte) (*AudioPacket, error) {
+	pkt := &AudioPacket{
+		PacketType:  AudioPacketTypeCodedFrames,
+		SoundFormat: data[0] >> 4,
+		SoundRate:   (data[0] >> 2) & 0x03,
+		SoundSize:   (data[0] >> 1) & 0x01,
+		SoundType:   data[0] & 0x01,
+	}
+
+	switch pkt.SoundFormat {
+	case soundFormatAAC:
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated AAC audio tag")
+		}
+		switch data[1] {
+		case 0:
+			pkt.PacketType = AudioPacketTypeSequenceStart
+		case 1:
+			pkt.PacketType = AudioPacketTypeCodedFrames
+		default:
+			return nil, fmt.Errorf("unknown AAC packet type %d", data[1])
+		}
+		pkt.Tracks = []AudioTrack{{FourCC: "mp4a", Data: data[2:]}}
+	case soundFormatMP3:
+		pkt.Tracks = []AudioTrack{{FourCC: ".mp3", Data: data[1:]}}
+	default:
+		pkt.Tracks = []AudioTrack{{Data: data[1:]}}
+	}
+	return pkt, nil
+}
@@ -13,24 +13,30 @@ type videoResolution struct {
 	height int
 }
 
-// codecConfig holds the parsed fields from a codec configuration record.
-type codecConfig struct {
-	trackType string        // "video" or "audio"
-	codec     string        // FourCC string, e.g. "hvc1", "mp4a", "avc1"
-	fields    []configField // parsed key/value pairs
+// CodecConfig holds the parsed fields from a codec configuration record.
+type CodecConfig struct {
+	TrackType  string        // "video" or "audio"
+	Codec      string        // FourCC string, e.g. "hvc1", "mp4a", "avc1"
+	Multitrack bool          // the record was carried in a Multitrack packet
+	TrackID    byte          // trackId of the record, valid if Multitrack
+	Fields     []ConfigField // parsed key/value pairs
 }
 
-// configField is a single named value from a config record.
-type configField struct {
-	name  string
-	value any
+// ConfigField is a single named value from a config record.
+type ConfigField struct {
+	Name  string
+	Value any
 }
 
-// Packet type for sequence start (same value for both video and audio in E-RTMP).
-const packetTypeSequenceStart = 0
-
 // VideoPacketType values.
-const videoPacketTypeMultitrack = 6
+const (
+	videoPacketTypeMetadata   = 4
+	videoPacketTypeMultitrack = 6
+	videoPacketTypeModEx      = 7
+)
+
+// AudioPacketType values.
+const audioPacketTypeMultitrack = 5
 
 // AvMultitrackType values.
 const (
@@ -42,6 +48,7 @@ const (
 // Legacy codec identifiers.
 const (
 	videoCodecIDAVC    = 7
+	soundFormatMP3     = 2
 	soundFormatAAC     = 10
 	soundFormatExAudio = 9
 )
@@ -51,319 +58,77 @@ var aacSamplingFrequencies = [...]int{
 	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
 }
 
-// parseVideoConfig reads a video tag payload from r. If the tag contains
-// sequence header(s), it parses the codec configuration record(s) and returns
-// them. Otherwise it skips the payload. The full dataSize bytes are always
-// consumed from r.
-//
-// Extended video tag header layout (E-RTMP v2):
+// parseVideoConfig reads a video tag payload from r and decodes it with
+// ParseVideoPacket. It returns the codec configuration record of every
+// SequenceStart track and the frame size of the first VP9 keyframe found in
+// the packet. The full dataSize bytes are always consumed from r.
 //
-//	Non-multitrack: [IsEx(1)|FrameType(3)|PacketType(4)] [FourCC(4)] [payload...]
-//	Multitrack:     [IsEx(1)|FrameType(3)|PacketType=6(4)] [AvMultitrackType(4)|innerPacketType(4)] [...]
-func parseVideoConfig(r io.Reader, dataSize int) ([]codecConfig, *videoResolution, error) {
-	if dataSize < 1 {
-		return nil, nil, nil
-	}
-
-	var first [1]byte
-	if _, err := io.ReadFull(r, first[:]); err != nil {
-		return nil, nil, err
-	}
-	remaining := dataSize - 1
-
-	isExHeader := first[0]&0x80 != 0
-
-	if isExHeader {
-		packetType := first[0] & 0x0F
-
-		if packetType == videoPacketTypeMultitrack {
-			// Multitrack: no FourCC in outer header.
-			// Next byte: [AvMultitrackType(4)][innerVideoPacketType(4)]
-			cfgs, res, err := parseVideoMultitrackConfigs(r, remaining)
-			return cfgs, res, err
-		}
-
-		// Non-multitrack extended: next 4 bytes are the FourCC.
-		if remaining < 4 {
-			_, err := io.CopyN(io.Discard, r, int64(remaining))
-			return nil, nil, err
-		}
-		var fourCCBytes [4]byte
-		if _, err := io.ReadFull(r, fourCCBytes[:]); err != nil {
-			return nil, nil, err
-		}
-		remaining -= 4
-		fourCC := string(fourCCBytes[:])
-
-		if packetType == packetTypeSequenceStart {
-			configData, err := readRemaining(r, remaining)
-			if err != nil {
-				return nil, nil, err
-			}
-			fields := parseVideoConfigByFourCC(fourCC, configData)
-			return []codecConfig{{trackType: "video", codec: fourCC, fields: fields}}, nil, nil
-		}
-
-		if fourCC == "vp09" {
-			frameData, err := readRemaining(r, remaining)
-			if err != nil {
-				return nil, nil, err
-			}
-			if w, h, ok := parseVP9KeyframeResolution(frameData); ok {
-				res := &videoResolution{codec: "vp09", width: w, height: h}
-				return nil, res, nil
-			}
-			return nil, nil, nil
-		}
-
-		_, err := io.CopyN(io.Discard, r, int64(remaining))
+// Malformed or unsupported packets carry nothing to report and are skipped
+// without an error; only read errors are returned.
+func parseVideoConfig(r io.Reader, dataSize int) ([]CodecConfig, *videoResolution, error) {
+	data, err := readRemaining(r, dataSize)
+	if err != nil {
 		return nil, nil, err
 	}
-
-	// Legacy video: [FrameType(4)|CodecID(4)] [AvcPacketType(1)] [CTO(3)] [payload...]
-	codecID := first[0] & 0x0F
-	if codecID == videoCodecIDAVC {
-		// Need AvcPacketType + CompositionTimeOffset (4 bytes total).
-		if remaining < 4 {
-			_, err := io.CopyN(io.Discard, r, int64(remaining))
-			return nil, nil, err
-		}
-		var avcHeader [4]byte
-		if _, err := io.ReadFull(r, avcHeader[:]); err != nil {
-			return nil, nil, err
-		}
-		remaining -= 4
-		if avcHeader[0] == 0 { // AvcPacketType == 0 → sequence header
-			configData, err := readRemaining(r, remaining)
-			if err != nil {
-				return nil, nil, err
-			}
-			fields := parseAVCConfig(configData)
-			return []codecConfig{{trackType: "video", codec: "avc1", fields: fields}}, nil, nil
-		}
-	}
-
-	_, err := io.CopyN(io.Discard, r, int64(remaining))
-	return nil, nil, err
-}
-
-// parseVideoMultitrackConfigs parses a VideoPacketTypeMultitrack payload and
-// returns any sequence-start codec configs found within it.
-//
-// Per E-RTMP v2 spec, after the outer 1-byte header the multitrack payload is:
-//
-//	[AvMultitrackType(4)|innerVideoPacketType(4)]  — 1 byte
-//	if avType != ManyTracksManyCodecs: [shared FourCC (4)]
-//	then per-track: see inline comments below.
-func parseVideoMultitrackConfigs(r io.Reader, remaining int) ([]codecConfig, *videoResolution, error) {
-	if remaining < 1 {
+	pkt, err := ParseVideoPacket(data)
+	if err != nil {
 		return nil, nil, nil
 	}
-	var typeByte [1]byte
-	if _, err := io.ReadFull(r, typeByte[:]); err != nil {
-		return nil, nil, err
-	}
-	remaining--
-	avType := int(typeByte[0] >> 4)
-	innerPacketType := byte(typeByte[0] & 0x0F)
-
-	discard := func(n int) error {
-		_, err := io.CopyN(io.Discard, r, int64(n))
-		return err
-	}
-
-	switch avType {
-	case avMultitrackOneTrack, avMultitrackManyTracks:
-		// Shared codec: read FourCC once.
-		if remaining < 4 {
-			return nil, nil, discard(remaining)
-		}
-		var fourCCBytes [4]byte
-		if _, err := io.ReadFull(r, fourCCBytes[:]); err != nil {
-			return nil, nil, err
-		}
-		remaining -= 4
-		fourCC := string(fourCCBytes[:])
-
-		if avType == avMultitrackOneTrack {
-			// OneTrack: [TrackID (1)] [payload (rest)]
-			if remaining < 1 {
-				return nil, nil, nil
-			}
-			var trackID [1]byte
-			if _, err := io.ReadFull(r, trackID[:]); err != nil {
-				return nil, nil, err
-			}
-			remaining--
-			if innerPacketType != packetTypeSequenceStart {
-				if fourCC == "vp09" {
-					frameData, err := readRemaining(r, remaining)
-					if err != nil {
-						return nil, nil, err
-					}
-					if w, h, ok := parseVP9KeyframeResolution(frameData); ok {
-						return nil, &videoResolution{codec: "vp09", width: w, height: h}, nil
-					}
-					return nil, nil, nil
-				}
-				return nil, nil, discard(remaining)
-			}
-			configData, err := readRemaining(r, remaining)
-			if err != nil {
-				return nil, nil, err
-			}
-			fields := parseVideoConfigByFourCC(fourCC, configData)
-			return []codecConfig{{trackType: "video", codec: fourCC, fields: fields}}, nil, nil
-		}
 
-		// ManyTracks: repeated [TrackID (1)] [SizeOfVideoData (3)] [payload]
-		var configs []codecConfig
-		var resolution *videoResolution
-		for remaining >= 4 {
-			var chunk [4]byte
-			if _, err := io.ReadFull(r, chunk[:]); err != nil {
-				return nil, nil, err
-			}
-			remaining -= 4
-			chunkSize := int(chunk[1])<<16 | int(chunk[2])<<8 | int(chunk[3])
-			if chunkSize > remaining {
-				return configs, resolution, discard(remaining)
-			}
-			if innerPacketType == packetTypeSequenceStart {
-				configData, err := readRemaining(r, chunkSize)
-				if err != nil {
-					return nil, nil, err
-				}
-				fields := parseVideoConfigByFourCC(fourCC, configData)
-				configs = append(configs, codecConfig{trackType: "video", codec: fourCC, fields: fields})
-			} else if fourCC == "vp09" {
-				frameData, err := readRemaining(r, chunkSize)
-				if err != nil {
-					return nil, nil, err
-				}
-				if resolution == nil {
-					if w, h, ok := parseVP9KeyframeResolution(frameData); ok {
-						resolution = &videoResolution{codec: "vp09", width: w, height: h}
-					}
-				}
-			} else {
-				if err := discard(chunkSize); err != nil {
-					return nil, nil, err
-				}
-			}
-			remaining -= chunkSize
-		}
-		return configs, resolution, discard(remaining)
-
-	case avMultitrackManyTracksManyCodecs:
-		// Each track has its own FourCC: repeated [FourCC (4)] [TrackID (1)] [SizeOfVideoData (3)] [payload]
-		var configs []codecConfig
-		var resolution *videoResolution
-		for remaining >= 8 {
-			var chunk [8]byte
-			if _, err := io.ReadFull(r, chunk[:]); err != nil {
-				return nil, nil, err
-			}
-			remaining -= 8
-			fourCC := string(chunk[0:4])
-			chunkSize := int(chunk[5])<<16 | int(chunk[6])<<8 | int(chunk[7])
-			if chunkSize > remaining {
-				return configs, resolution, discard(remaining)
-			}
-			if innerPacketType == packetTypeSequenceStart {
-				configData, err := readRemaining(r, chunkSize)
-				if err != nil {
-					return nil, nil, err
-				}
-				fields := parseVideoConfigByFourCC(fourCC, configData)
-				configs = append(configs, codecConfig{trackType: "video", codec: fourCC, fields: fields})
-			} else if fourCC == "vp09" {
-				frameData, err := readRemaining(r, chunkSize)
-				if err != nil {
-					return nil, nil, err
-				}
-				if resolution == nil {
-					if w, h, ok := parseVP9KeyframeResolution(frameData); ok {
-						resolution = &videoResolution{codec: "vp09", width: w, height: h}
-					}
-				}
-			} else {
-				if err := discard(chunkSize); err != nil {
-					return nil, nil, err
+	var configs []CodecConfig
+	var resolution *videoResolution
+	for _, track := range pkt.Tracks {
+		switch pkt.PacketType {
+		case VideoPacketTypeSequenceStart:
+			configs = append(configs, CodecConfig{
+				TrackType:  "video",
+				Codec:      track.FourCC,
+				Multitrack: pkt.Multitrack,
+				TrackID:    track.TrackID,
+				Fields:     parseVideoConfigByFourCC(track.FourCC, track.Data),
+			})
+		case VideoPacketTypeCodedFrames, VideoPacketTypeCodedFramesX:
+			if track.FourCC == "vp09" && resolution == nil {
+				if w, h, ok := parseVP9KeyframeResolution(track.Data); ok {
+					resolution = &videoResolution{codec: "vp09", width: w, height: h}
 				}
 			}
-			remaining -= chunkSize
 		}
-		return configs, resolution, discard(remaining)
-
-	default:
-		return nil, nil, discard(remaining)
 	}
+	return configs, resolution, nil
 }
 
-// parseAudioConfig reads an audio tag payload from r. If the tag is a
-// sequence header, it parses the codec configuration record and returns it.
-// Otherwise it skips the payload. The full dataSize bytes are always consumed.
-func parseAudioConfig(r io.Reader, dataSize int) ([]codecConfig, error) {
-	if dataSize < 2 {
-		return discardAndReturn(r, dataSize)
-	}
-
-	var header [5]byte
-	headerSize := 5
-	if dataSize < 5 {
-		headerSize = dataSize
-	}
-	if _, err := io.ReadFull(r, header[:headerSize]); err != nil {
+// parseAudioConfig reads an audio tag payload from r and decodes it with
+// ParseAudioPacket. It returns the codec configuration record of every
+// SequenceStart track, including each track of a Multitrack packet. The full
+// dataSize bytes are always consumed from r.
+//
+// Malformed or unsupported packets carry nothing to report and are skipped
+// without an error; only read errors are returned.
+func parseAudioConfig(r io.Reader, dataSize int) ([]CodecConfig, error) {
+	data, err := readRemaining(r, dataSize)
+	if err != nil {
 		return nil, err
 	}
-	remaining := dataSize - headerSize
-
-	soundFormat := header[0] >> 4
-
-	if soundFormat == soundFormatExAudio {
-		if headerSize < 5 {
-			return discardAndReturn(r, remaining)
-		}
-		audioPacketType := header[0] & 0x0F
-		fourCC := string(header[1:5])
-
-		if audioPacketType != packetTypeSequenceStart {
-			return discardAndReturn(r, remaining)
-		}
-
-		configData, err := readRemaining(r, remaining)
-		if err != nil {
-			return nil, err
-		}
-
-		fields := parseAudioConfigByFourCC(fourCC, configData)
-		return []codecConfig{{trackType: "audio", codec: fourCC, fields: fields}}, nil
+	pkt, err := ParseAudioPacket(data)
+	if err != nil || pkt.PacketType != AudioPacketTypeSequenceStart {
+		return nil, nil
 	}
 
-	if soundFormat == soundFormatAAC {
-		aacPacketType := header[1]
-		// For legacy AAC we read 5 bytes but only need 2 for detection.
-		// Adjust remaining: the config data starts at byte 2 of the original payload.
-		leftover := dataSize - 2
-		if aacPacketType == 0 {
-			// We already consumed headerSize bytes; re-assemble what we have.
-			extraData, err := readRemaining(r, remaining)
-			if err != nil {
-				return nil, err
-			}
-			configData := make([]byte, 0, leftover)
-			configData = append(configData, header[2:headerSize]...)
-			configData = append(configData, extraData...)
-			fields := parseAACConfig(configData)
-			return []codecConfig{{trackType: "audio", codec: "mp4a", fields: fields}}, nil
-		}
+	var configs []CodecConfig
+	for _, track := range pkt.Tracks {
+		configs = append(configs, CodecConfig{
+			TrackType:  "audio",
+			Codec:      track.FourCC,
+			Multitrack: pkt.Multitrack,
+			TrackID:    track.TrackID,
+			Fields:     parseAudioConfigByFourCC(track.FourCC, track.Data),
+		})
 	}
-
-	return discardAndReturn(r, remaining)
+	return configs, nil
 }
 
-func parseVideoConfigByFourCC(fourCC string, data []byte) []configField {
+func parseVideoConfigByFourCC(fourCC string, data []byte) []ConfigField {
 	switch fourCC {
 	case "avc1":
 		return parseAVCConfig(data)
@@ -374,11 +139,11 @@ func parseVideoConfigByFourCC(fourCC string, data []byte) []configField {
 	case "vp09":
 		return parseVP9Config(data)
 	default:
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 }
 
-func parseAudioConfigByFourCC(fourCC string, data []byte) []configField {
+func parseAudioConfigByFourCC(fourCC string, data []byte) []ConfigField {
 	switch fourCC {
 	case "mp4a":
 		return parseAACConfig(data)
@@ -387,7 +152,7 @@ func parseAudioConfigByFourCC(fourCC string, data []byte) []configField {
 	case "fLaC":
 		return parseFLACConfig(data)
 	default:
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 }
 
@@ -703,17 +468,17 @@ func parseHEVCSPSResolution(nalUnit []byte) (width, height int, ok bool) {
 
 // --- AVC (H.264) ---
 
-func parseAVCConfig(data []byte) []configField {
+func parseAVCConfig(data []byte) []ConfigField {
 	if len(data) < 6 {
-		return []configField{{name: "error", value: "truncated"}}
+		return []ConfigField{{Name: "error", Value: "truncated"}}
 	}
-	fields := []configField{
-		{name: "configurationVersion", value: int(data[0])},
-		{name: "AVCProfileIndication", value: int(data[1])},
-		{name: "profile_compatibility", value: fmt.Sprintf("0x%02X", data[2])},
-		{name: "AVCLevelIndication", value: int(data[3])},
-		{name: "lengthSizeMinusOne", value: int(data[4] & 0x03)},
-		{name: "numOfSPS", value: int(data[5] & 0x1F)},
+	fields := []ConfigField{
+		{Name: "configurationVersion", Value: int(data[0])},
+		{Name: "AVCProfileIndication", Value: int(data[1])},
+		{Name: "profile_compatibility", Value: fmt.Sprintf("0x%02X", data[2])},
+		{Name: "AVCLevelIndication", Value: int(data[3])},
+		{Name: "lengthSizeMinusOne", Value: int(data[4] & 0x03)},
+		{Name: "numOfSPS", Value: int(data[5] & 0x1F)},
 	}
 	// Extract resolution from the first SPS NAL unit.
 	numSPS := int(data[5] & 0x1F)
@@ -724,8 +489,8 @@ func parseAVCConfig(data []byte) []configField {
 		if pos+spsLen <= len(data) {
 			if w, h, ok := parseAVCSPSResolution(data[pos : pos+spsLen]); ok {
 				fields = append(fields,
-					configField{name: "width", value: w},
-					configField{name: "height", value: h},
+					ConfigField{Name: "width", Value: w},
+					ConfigField{Name: "height", Value: h},
 				)
 			}
 		}
@@ -735,23 +500,23 @@ func parseAVCConfig(data []byte) []configField {
 
 // --- HEVC (H.265) ---
 
-func parseHEVCConfig(data []byte) []configField {
+func parseHEVCConfig(data []byte) []ConfigField {
 	if len(data) < 23 {
-		return []configField{{name: "error", value: "truncated"}}
-	}
-	fields := []configField{
-		{name: "configurationVersion", value: int(data[0])},
-		{name: "general_profile_space", value: int(data[1] >> 6)},
-		{name: "general_tier_flag", value: int((data[1] >> 5) & 0x01)},
-		{name: "general_profile_idc", value: int(data[1] & 0x1F)},
-		{name: "general_level_idc", value: int(data[12])},
-		{name: "chroma_format_idc", value: int(data[16] & 0x03)},
-		{name: "bit_depth_luma", value: int(data[17]&0x07) + 8},
-		{name: "bit_depth_chroma", value: int(data[18]&0x07) + 8},
-		{name: "avgFrameRate", value: int(binary.BigEndian.Uint16(data[19:21]))},
-		{name: "numTemporalLayers", value: int((data[21] >> 3) & 0x07)},
-		{name: "lengthSizeMinusOne", value: int(data[21] & 0x03)},
-		{name: "numOfArrays", value: int(data[22])},
+		return []ConfigField{{Name: "error", Value: "truncated"}}
+	}
+	fields := []ConfigField{
+		{Name: "configurationVersion", Value: int(data[0])},
+		{Name: "general_profile_space", Value: int(data[1] >> 6)},
+		{Name: "general_tier_flag", Value: int((data[1] >> 5) & 0x01)},
+		{Name: "general_profile_idc", Value: int(data[1] & 0x1F)},
+		{Name: "general_level_idc", Value: int(data[12])},
+		{Name: "chroma_format_idc", Value: int(data[16] & 0x03)},
+		{Name: "bit_depth_luma", Value: int(data[17]&0x07) + 8},
+		{Name: "bit_depth_chroma", Value: int(data[18]&0x07) + 8},
+		{Name: "avgFrameRate", Value: int(binary.BigEndian.Uint16(data[19:21]))},
+		{Name: "numTemporalLayers", Value: int((data[21] >> 3) & 0x07)},
+		{Name: "lengthSizeMinusOne", Value: int(data[21] & 0x03)},
+		{Name: "numOfArrays", Value: int(data[22])},
 	}
 	// Walk NAL unit arrays to find the SPS (NAL unit type 33 = SPS_NUT).
 	numArrays := int(data[22])
@@ -771,8 +536,8 @@ func parseHEVCConfig(data []byte) []configField {
 			if nalUnitType == 33 {
 				if w, h, ok := parseHEVCSPSResolution(nalu); ok {
 					fields = append(fields,
-						configField{name: "width", value: w},
-						configField{name: "height", value: h},
+						ConfigField{Name: "width", Value: w},
+						ConfigField{Name: "height", Value: h},
 					)
 					return fields
 				}
@@ -784,9 +549,9 @@ func parseHEVCConfig(data []byte) []configField {
 
 // --- AV1 ---
 
-func parseAV1Config(data []byte) []configField {
+func parseAV1Config(data []byte) []ConfigField {
 	if len(data) < 4 {
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 	marker := int((data[0] >> 7) & 0x01)
 	version := int(data[0] & 0x7F)
@@ -809,29 +574,29 @@ func parseAV1Config(data []byte) []configField {
 		bitDepth = 10
 	}
 
-	fields := []configField{
-		{name: "marker", value: marker},
-		{name: "version", value: version},
-		{name: "seq_profile", value: seqProfile},
-		{name: "seq_level_idx_0", value: seqLevelIdx0},
-		{name: "seq_tier_0", value: seqTier0},
-		{name: "bit_depth", value: bitDepth},
-		{name: "monochrome", value: monochrome},
-		{name: "chroma_subsampling_x", value: chromaSubsamplingX},
-		{name: "chroma_subsampling_y", value: chromaSubsamplingY},
-		{name: "chroma_sample_position", value: chromaSamplePosition},
-		{name: "initial_presentation_delay_present", value: initialPresentationDelayPresent},
+	fields := []ConfigField{
+		{Name: "marker", Value: marker},
+		{Name: "version", Value: version},
+		{Name: "seq_profile", Value: seqProfile},
+		{Name: "seq_level_idx_0", Value: seqLevelIdx0},
+		{Name: "seq_tier_0", Value: seqTier0},
+		{Name: "bit_depth", Value: bitDepth},
+		{Name: "monochrome", Value: monochrome},
+		{Name: "chroma_subsampling_x", Value: chromaSubsamplingX},
+		{Name: "chroma_subsampling_y", Value: chromaSubsamplingY},
+		{Name: "chroma_sample_position", Value: chromaSamplePosition},
+		{Name: "initial_presentation_delay_present", Value: initialPresentationDelayPresent},
 	}
 	if initialPresentationDelayPresent != 0 {
-		fields = append(fields, configField{name: "initial_presentation_delay_minus_one", value: initialPresentationDelayMinusOne})
+		fields = append(fields, ConfigField{Name: "initial_presentation_delay_minus_one", Value: initialPresentationDelayMinusOne})
 	}
 
 	configOBUs := data[4:]
-	fields = append(fields, configField{name: "config_obus_size", value: len(configOBUs)})
+	fields = append(fields, ConfigField{Name: "config_obus_size", Value: len(configOBUs)})
 	if w, h, ok := parseAV1MaxFrameSizeFromConfigOBUs(configOBUs); ok {
 		fields = append(fields,
-			configField{name: "max_frame_width", value: w},
-			configField{name: "max_frame_height", value: h},
+			ConfigField{Name: "max_frame_width", Value: w},
+			ConfigField{Name: "max_frame_height", Value: h},
 		)
 	}
 
@@ -1035,14 +800,14 @@ func parseAV1SequenceHeaderMaxFrameSize(payload []byte) (width int, height int,
 
 // --- VP9 ---
 
-func parseVP9Config(data []byte) []configField {
+func parseVP9Config(data []byte) []ConfigField {
 	// VPcodecConfigurationRecord is carried in a FullBox payload:
 	// [fullbox_version(1)][fullbox_flags(3)]
 	// [profile(1)][level(1)][bitDepth/chroma/fullRange(1)]
 	// [colourPrimaries(1)][transferCharacteristics(1)][matrixCoefficients(1)]
 	// [codecInitializationDataSize(2)][codecInitializationData(N)]
 	if len(data) < 12 {
-		return []configField{{name: "error", value: "truncated"}}
+		return []ConfigField{{Name: "error", Value: "truncated"}}
 	}
 	fullboxVersion := int(data[0])
 	vpcc := data[4:]
@@ -1057,18 +822,32 @@ func parseVP9Config(data []byte) []configField {
 	matrixCoefficients := int(vpcc[5])
 	codecInitializationDataSize := int(binary.BigEndian.Uint16(vpcc[6:8]))
 
-	return []configField{
-		{name: "fullbox_version", value: fullboxVersion},
-		{name: "profile", value: profile},
-		{name: "level", value: level},
-		{name: "bit_depth", value: bitDepth},
-		{name: "chroma_subsampling", value: chromaSubsampling},
-		{name: "videoFullRangeFlag", value: videoFullRangeFlag},
-		{name: "colour_primaries", value: colourPrimaries},
-		{name: "transfer_characteristics", value: transferCharacteristics},
-		{name: "matrix_coefficients", value: matrixCoefficients},
-		{name: "codec_initialization_data_size", value: codecInitializationDataSize},
+	return []ConfigField{
+		{Name: "fullbox_version", Value: fullboxVersion},
+		{Name: "profile", Value: profile},
+		{Name: "level", Value: level},
+		{Name: "bit_depth", Value: bitDepth},
+		{Name: "chroma_subsampling", Value: chromaSubsampling},
+		{Name: "videoFullRangeFlag", Value: videoFullRangeFlag},
+		{Name: "colour_primaries", Value: colourPrimaries},
+		{Name: "transfer_characteristics", Value: transferCharacteristics},
+		{Name: "matrix_coefficients", Value: matrixCoefficients},
+		{Name: "codec_initialization_data_size", Value: codecInitializationDataSize},
+	}
+}
+
+// parseVP8KeyframeResolution reads the frame size of a VP8 key frame
+// (RFC 6386 section 9.1).
+func parseVP8KeyframeResolution(data []byte) (width int, height int, ok bool) {
+	if len(data) < 10 || data[0]&0x01 != 0 { // frame tag: key_frame is 0
+		return 0, 0, false
+	}
+	if data[3] != 0x9D || data[4] != 0x01 || data[5] != 0x2A {
+		return 0, 0, false
 	}
+	width = int(binary.LittleEndian.Uint16(data[6:]) & 0x3FFF)
+	height = int(binary.LittleEndian.Uint16(data[8:]) & 0x3FFF)
+	return width, height, true
 }
 
 func parseVP9KeyframeResolution(data []byte) (width int, height int, ok bool) {
@@ -1167,9 +946,9 @@ func parseVP9KeyframeResolution(data []byte) (width int, height int, ok bool) {
 
 // --- AAC ---
 
-func parseAACConfig(data []byte) []configField {
+func parseAACConfig(data []byte) []ConfigField {
 	if len(data) < 2 {
-		return []configField{{name: "error", value: "truncated"}}
+		return []ConfigField{{Name: "error", Value: "truncated"}}
 	}
 	audioObjectType := int(data[0] >> 3)
 	samplingIndex := int((data[0]&0x07)<<1) | int(data[1]>>7)
@@ -1180,23 +959,23 @@ func parseAACConfig(data []byte) []configField {
 		samplingFreq = aacSamplingFrequencies[samplingIndex]
 	}
 
-	return []configField{
-		{name: "audioObjectType", value: audioObjectType},
-		{name: "samplingFrequency", value: samplingFreq},
-		{name: "channelConfiguration", value: channelConfig},
+	return []ConfigField{
+		{Name: "audioObjectType", Value: audioObjectType},
+		{Name: "samplingFrequency", Value: samplingFreq},
+		{Name: "channelConfiguration", Value: channelConfig},
 	}
 }
 
 // --- Opus (RFC 7845 OpusHead) ---
 
-func parseOpusConfig(data []byte) []configField {
+func parseOpusConfig(data []byte) []ConfigField {
 	// OpusHead: "OpusHead"(8) + version(1) + channels(1) + preSkip(2) + sampleRate(4) + outputGain(2) + mappingFamily(1) = 19 bytes min
 	if len(data) < 19 {
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 	magic := string(data[0:8])
 	if magic != "OpusHead" {
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 	version := int(data[8])
 	channels := int(data[9])
@@ -1205,25 +984,25 @@ func parseOpusConfig(data []byte) []configField {
 	outputGain := int(int16(binary.LittleEndian.Uint16(data[16:18])))
 	mappingFamily := int(data[18])
 
-	return []configField{
-		{name: "version", value: version},
-		{name: "channels", value: channels},
-		{name: "preSkip", value: preSkip},
-		{name: "inputSampleRate", value: inputSampleRate},
-		{name: "outputGain", value: outputGain},
-		{name: "mappingFamily", value: mappingFamily},
+	return []ConfigField{
+		{Name: "version", Value: version},
+		{Name: "channels", Value: channels},
+		{Name: "preSkip", Value: preSkip},
+		{Name: "inputSampleRate", Value: inputSampleRate},
+		{Name: "outputGain", Value: outputGain},
+		{Name: "mappingFamily", Value: mappingFamily},
 	}
 }
 
 // --- FLAC ---
 
-func parseFLACConfig(data []byte) []configField {
+func parseFLACConfig(data []byte) []ConfigField {
 	// FLAC STREAMINFO block: marker(1) + type/length(4) + STREAMINFO(34) = min ~39 bytes
 	// But the enhanced audio payload may just be the raw STREAMINFO.
 	// STREAMINFO: minBlockSize(2) + maxBlockSize(2) + minFrameSize(3) + maxFrameSize(3) +
 	//   sampleRate(20bits) + channels(3bits) + bitsPerSample(5bits) + totalSamples(36bits) + md5(16) = 34 bytes
 	if len(data) < 34 {
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 	// Try to detect if there's a fLaC marker or metadata block header before STREAMINFO.
 	offset := 0
@@ -1231,14 +1010,14 @@ func parseFLACConfig(data []byte) []configField {
 		offset = 4 // skip the fLaC marker
 	}
 	if offset+34 > len(data) {
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 	// If next byte looks like a metadata block header (type in upper 7 bits), skip 4 bytes.
 	if offset+4+34 <= len(data) && (data[offset]&0x7F) == 0 {
 		offset += 4 // skip block header (type=0 STREAMINFO + 3 byte length)
 	}
 	if offset+34 > len(data) {
-		return []configField{{name: "size", value: len(data)}}
+		return []ConfigField{{Name: "size", Value: len(data)}}
 	}
 	d := data[offset:]
 	minBlockSize := int(binary.BigEndian.Uint16(d[0:2]))
@@ -1247,35 +1026,30 @@ func parseFLACConfig(data []byte) []configField {
 	channels := int((d[12]>>1)&0x07) + 1
 	bitsPerSample := int(d[12]&0x01)<<4 | int(d[13]>>4) + 1
 
-	return []configField{
-		{name: "minBlockSize", value: minBlockSize},
-		{name: "maxBlockSize", value: maxBlockSize},
-		{name: "sampleRate", value: sampleRate},
-		{name: "channels", value: channels},
-		{name: "bitsPerSample", value: bitsPerSample},
+	return []ConfigField{
+		{Name: "minBlockSize", Value: minBlockSize},
+		{Name: "maxBlockSize", Value: maxBlockSize},
+		{Name: "sampleRate", Value: sampleRate},
+		{Name: "channels", Value: channels},
+		{Name: "bitsPerSample", Value: bitsPerSample},
 	}
 }
 
 // --- Printing ---
 
-func printCodecConfig(cfg codecConfig) {
-	fmt.Printf("codecConfigurationRecord (%s: %s)\n", cfg.trackType, cfg.codec)
-	for _, f := range cfg.fields {
-		fmt.Printf("  %s: %v\n", f.name, f.value)
+func printCodecConfig(cfg CodecConfig) {
+	if cfg.Multitrack {
+		fmt.Printf("codecConfigurationRecord (%s: %s, track %d)\n", cfg.TrackType, cfg.Codec, cfg.TrackID)
+	} else {
+		fmt.Printf("codecConfigurationRecord (%s: %s)\n", cfg.TrackType, cfg.Codec)
+	}
+	for _, f := range cfg.Fields {
+		fmt.Printf("  %s: %v\n", f.Name, f.Value)
 	}
 }
 
 // --- Helpers ---
 
-func discardAndReturn(r io.Reader, n int) ([]codecConfig, error) {
-	if n > 0 {
-		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
-			return nil, err
-		}
-	}
-	return nil, nil
-}
-
 func readRemaining(r io.Reader, n int) ([]byte, error) {
 	if n <= 0 {
 		return nil, nil
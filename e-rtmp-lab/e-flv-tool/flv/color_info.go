@@ -0,0 +1,191 @@
+package flv
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// colorInfoChange records a colorInfo object carried by a
+// VideoPacketType.Metadata packet that differs from the previous one seen
+// on the same track. A reset has no properties.
+type colorInfoChange struct {
+	timestamp  uint32
+	codec      string
+	multitrack bool
+	trackID    byte
+	props      []AMF0Property
+}
+
+// Valid ranges of the colorInfo properties (E-RTMP v2, Metadata Frame).
+var (
+	colorInfoBitDepths = []float64{8, 10, 12}
+
+	colorInfoH273Keys = []string{"colorPrimaries", "transferCharacteristics", "matrixCoefficients"}
+
+	colorInfoCllKeys = []string{"maxFall", "maxCLL"}
+
+	colorInfoMdcvXKeys = []string{"redX", "greenX", "blueX", "whitePointX"}
+	colorInfoMdcvYKeys = []string{"redY", "greenY", "blueY", "whitePointY"}
+)
+
+// parseVideoMetadata decodes the body of a VideoPacketType.Metadata packet:
+// a series of AMF0 [name, value] pairs. It returns the colorInfo object, or
+// nil if the body has none. A colorInfo of undefined or null resets the
+// color information and is returned as an empty object. Pairs other than
+// colorInfo are skipped.
+func parseVideoMetadata(body []byte) ([]AMF0Property, error) {
+	var colorInfo []AMF0Property
+	offset := 0
+	for offset < len(body) {
+		name, next, err := parseAMF0Value(body, offset)
+		if err != nil {
+			return nil, fmt.Errorf("metadata name: %w", err)
+		}
+		value, next, err := parseAMF0Value(body, next)
+		if err != nil {
+			return nil, fmt.Errorf("metadata value: %w", err)
+		}
+		offset = next
+
+		if s, _ := amf0StringValue(name); s == "colorInfo" {
+			switch value.(type) {
+			case AMF0Undefined, nil:
+				colorInfo = []AMF0Property{}
+				continue
+			}
+			props, ok := amf0Properties(value)
+			if !ok {
+				return nil, fmt.Errorf("colorInfo is not an object")
+			}
+			colorInfo = props
+		}
+	}
+	return colorInfo, nil
+}
+
+// collectColorInfo decodes the colorInfo of every track of a Metadata packet
+// and appends it to info if it changed since the last colorInfo of that
+// track. Problems are reported as warnings.
+func (info *fileInfo) collectColorInfo(timestamp uint32, pkt *VideoPacket) {
+	for _, track := range pkt.Tracks {
+		where := fmt.Sprintf("colorInfo at %d ms", timestamp)
+		if pkt.Multitrack {
+			where = fmt.Sprintf("colorInfo at %d ms (track %d)", timestamp, track.TrackID)
+		}
+
+		props, err := parseVideoMetadata(track.Data)
+		if err != nil {
+			info.warnings = append(info.warnings, fmt.Sprintf("%s: %v", where, err))
+			continue
+		}
+		if props == nil {
+			continue
+		}
+
+		var last []AMF0Property
+		for i := len(info.colorInfos) - 1; i >= 0; i-- {
+			if c := info.colorInfos[i]; c.trackID == track.TrackID {
+				last = c.props
+				break
+			}
+		}
+		if last != nil && reflect.DeepEqual(last, props) {
+			continue
+		}
+
+		info.colorInfos = append(info.colorInfos, colorInfoChange{
+			timestamp:  timestamp,
+			codec:      track.FourCC,
+			multitrack: pkt.Multitrack,
+			trackID:    track.TrackID,
+			props:      props,
+		})
+		for _, problem := range validateColorInfo(props) {
+			info.warnings = append(info.warnings, fmt.Sprintf("%s: %s", where, problem))
+		}
+	}
+}
+
+// validateColorInfo checks colorInfo values against the ranges given by the
+// E-RTMP v2 spec and returns one message per problem.
+func validateColorInfo(colorInfo []AMF0Property) []string {
+	var problems []string
+	check := func(object string, props []AMF0Property, key string, valid func(float64) bool, want string) {
+		v, ok := amf0Lookup(props, key)
+		if !ok {
+			return
+		}
+		n, isNumber := v.(float64)
+		switch {
+		case !isNumber:
+			problems = append(problems, fmt.Sprintf("%s.%s is not a number", object, key))
+		case !valid(n):
+			problems = append(problems, fmt.Sprintf("%s.%s = %g, want %s", object, key, n, want))
+		}
+	}
+	inRange := func(lo, hi float64) func(float64) bool {
+		return func(n float64) bool { return n >= lo && n <= hi }
+	}
+
+	for _, p := range colorInfo {
+		props, ok := amf0Properties(p.Value)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not an object", p.Name))
+			continue
+		}
+		switch p.Name {
+		case "colorConfig":
+			check(p.Name, props, "bitDepth", func(n float64) bool {
+				for _, d := range colorInfoBitDepths {
+					if n == d {
+						return true
+					}
+				}
+				return false
+			}, "8, 10 or 12")
+			for _, key := range colorInfoH273Keys {
+				check(p.Name, props, key, func(n float64) bool {
+					return n == math.Trunc(n) && n >= 0 && n <= 255
+				}, "an H.273 index in [0, 255]")
+			}
+		case "hdrCll":
+			for _, key := range colorInfoCllKeys {
+				check(p.Name, props, key, inRange(0.0001, 10000), "[0.0001, 10000] cd/m2")
+			}
+		case "hdrMdcv":
+			for _, key := range colorInfoMdcvXKeys {
+				check(p.Name, props, key, inRange(0.0001, 0.74), "[0.0001, 0.7400]")
+			}
+			for _, key := range colorInfoMdcvYKeys {
+				check(p.Name, props, key, inRange(0.0001, 0.84), "[0.0001, 0.8400]")
+			}
+			check(p.Name, props, "maxLuminance", inRange(5, 10000), "[5, 10000] cd/m2")
+			check(p.Name, props, "minLuminance", inRange(0.0001, 5), "[0.0001, 5] cd/m2")
+			maxL, okMax := amf0Lookup(props, "maxLuminance")
+			minL, okMin := amf0Lookup(props, "minLuminance")
+			if okMax && okMin {
+				if maxN, ok := maxL.(float64); ok {
+					if minN, ok := minL.(float64); ok && minN >= maxN {
+						problems = append(problems, fmt.Sprintf("hdrMdcv.minLuminance %g is not below maxLuminance %g", minN, maxN))
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func printColorInfo(c colorInfoChange) {
+	if c.multitrack {
+		fmt.Printf("colorInfo (video: %s, track %d) at %d ms\n", c.codec, c.trackID, c.timestamp)
+	} else {
+		fmt.Printf("colorInfo (video: %s) at %d ms\n", c.codec, c.timestamp)
+	}
+	if len(c.props) == 0 {
+		fmt.Printf("  (reset)\n")
+	}
+	for _, p := range c.props {
+		printAMF0Property(p, 1)
+	}
+}
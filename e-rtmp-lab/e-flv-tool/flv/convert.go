@@ -0,0 +1,363 @@
+package flv
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// convertTrack is an MP4 track written to the FLV output, with the FLV
+// timing of its samples.
+type convertTrack struct {
+	*mp4InputTrack
+	fourCC  string
+	trackID byte    // E-RTMP trackId; trackId 0 is written as single-track packets
+	dts     []int64 // ms
+	cto     []int32 // ms, avc1/hvc1 only
+	end     int64   // ms, end of the last sample
+	entry   int     // sample description of the last SequenceStart
+	written int
+	skipped int // samples of a sample description with another codec
+}
+
+// ConvertMP4 converts an MP4 or fragmented MP4 file into an E-FLV file. The
+// coded frames are copied as they are; every audio and video track whose
+// codec has an E-RTMP FourCC is converted.
+//
+// The output starts with an onMetaData script tag and a SequenceStart for
+// every track built from its codec configuration box, followed by the
+// CodedFrames tags of all tracks interleaved by DTS. The first video and the
+// first audio track are written as single-track packets; further tracks use
+// Multitrack packets with trackId 1, 2 and so on. Tag timestamps are the
+// decode times in ms after the edit list of the track is applied, and
+// avc1/hvc1 frames carry the composition time offset. For the other codecs,
+// which have no offset in E-RTMP, the timestamp is the presentation time.
+func ConvertMP4(inputPath, outputPath string) error {
+	in, err := readMP4Input(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.f.Close()
+
+	tracks := in.selectTracks()
+	if len(tracks) == 0 {
+		// The warnings tell why every track was skipped.
+		for _, w := range in.warnings {
+			fmt.Printf("warning: %s\n", w)
+		}
+		return fmt.Errorf("no tracks to convert")
+	}
+	convertTimes(tracks)
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("creating output: %w", err)
+	}
+	defer out.Close()
+
+	hasAudio, hasVideo := false, false
+	for _, t := range tracks {
+		hasAudio = hasAudio || t.trackType == "audio"
+		hasVideo = hasVideo || t.trackType == "video"
+	}
+	bw := bufio.NewWriterSize(out, 1<<20)
+	w, err := NewWriter(bw, hasAudio, hasVideo)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := convertMetadata(tracks)
+	if err != nil {
+		return fmt.Errorf("encoding onMetaData: %w", err)
+	}
+	if err := w.WriteScriptTag(0, metadata); err != nil {
+		return fmt.Errorf("writing tag: %w", err)
+	}
+	for _, t := range tracks {
+		if err := t.writeSequenceStart(w, 0); err != nil {
+			return err
+		}
+	}
+
+	var buf []byte
+	for {
+		var t *convertTrack
+		for _, c := range tracks {
+			if c.written < len(c.samples) && (t == nil || c.dts[c.written] < t.dts[t.written]) {
+				t = c
+			}
+		}
+		if t == nil {
+			break
+		}
+		i := t.written
+		t.written++
+		s := &t.samples[i]
+		ts := uint32(t.dts[i])
+
+		if s.entry != t.entry {
+			if s.entry < 0 || s.entry >= len(t.entries) || t.entries[s.entry].fourCC != t.fourCC {
+				t.skipped++
+				continue
+			}
+			t.entry = s.entry
+			if err := t.writeSequenceStart(w, ts); err != nil {
+				return err
+			}
+		}
+
+		// avc1/hvc1 frames start with the SI24 composition time offset.
+		header := 0
+		if hasCompositionTimeOffset(t.fourCC) {
+			header = 3
+		}
+		if cap(buf) < header+s.size {
+			buf = make([]byte, header+s.size)
+		}
+		body := buf[:header+s.size]
+		if header > 0 {
+			cto := t.cto[i]
+			body[0], body[1], body[2] = byte(cto>>16), byte(cto>>8), byte(cto)
+		}
+		if n, err := in.f.ReadAt(body[header:], s.offset); n < s.size {
+			return fmt.Errorf("%s: reading sample %d: %w", t.name(), i+1, err)
+		}
+
+		pkt := trackPacket{packetType: byte(AudioPacketTypeCodedFrames), fourCC: t.fourCC, body: body}
+		if t.trackType == "video" {
+			pkt.packetType = byte(VideoPacketTypeCodedFrames)
+			pkt.frameType = byte(VideoFrameTypeInterFrame)
+			if s.key {
+				pkt.frameType = byte(VideoFrameTypeKeyFrame)
+			}
+		}
+		if err := t.writePacket(w, ts, pkt); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing output: %w", err)
+	}
+
+	for _, t := range tracks {
+		if t.skipped > 0 {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %d samples of a sample description with another codec skipped", t.name(), t.skipped))
+		}
+	}
+	for _, w := range in.warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	fmt.Printf("Output: %s\n", outputPath)
+	for _, t := range tracks {
+		fmt.Printf("  %s -> %s trackId %d, %d frames\n", t.name(), t.fourCC, t.trackID, len(t.samples)-t.skipped)
+	}
+	return nil
+}
+
+// selectTracks returns the audio and video tracks that can be converted,
+// video first, and assigns their trackIds. The codec of a track is the one
+// of the sample description of its first sample. Other tracks are skipped
+// with a warning.
+func (in *mp4Input) selectTracks() []*convertTrack {
+	var video, audio []*convertTrack
+	for _, t := range in.tracks {
+		switch {
+		case t.trackType == "":
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: not an audio or video track, skipping", t.name()))
+			continue
+		case len(t.samples) == 0:
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: no samples, skipping", t.name()))
+			continue
+		}
+		entry := t.samples[0].entry
+		if entry < 0 || entry >= len(t.entries) {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: invalid sample description index, skipping", t.name()))
+			continue
+		}
+		if e := t.entries[entry]; e.fourCC == "" {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %s, skipping", t.name(), e.reason))
+			continue
+		}
+
+		c := &convertTrack{mp4InputTrack: t, fourCC: t.entries[entry].fourCC, entry: entry}
+		if t.trackType == "video" {
+			c.trackID = byte(len(video))
+			video = append(video, c)
+		} else {
+			c.trackID = byte(len(audio))
+			audio = append(audio, c)
+		}
+	}
+	return append(video, audio...)
+}
+
+// convertTimes sets the FLV timing of the samples of every track. The edit
+// list maps the media times to the movie timeline: an empty edit delays the
+// track and the media time of the first edit is presented at its start. For
+// Opus, the pre-skip the edit list skips stays in the stream, as the decoder
+// applies it from the OpusHead. When some decode times end up negative, all
+// tracks are shifted so the earliest one is 0.
+func convertTimes(tracks []*convertTrack) {
+	var shift int64
+	for _, t := range tracks {
+		first := t.mediaTime
+		if t.fourCC == "Opus" {
+			first = max(first-int64(t.entries[t.entry].preSkip), 0)
+		}
+		ms := func(v int64) int64 {
+			return scaleNanos(t.delay+mediaNanos(v-first, t.timescale), 1000)
+		}
+
+		t.dts = make([]int64, len(t.samples))
+		if hasCompositionTimeOffset(t.fourCC) {
+			t.cto = make([]int32, len(t.samples))
+		}
+		for i := range t.samples {
+			s := &t.samples[i]
+			pts := ms(s.dts + s.cto)
+			if t.cto == nil {
+				t.dts[i] = pts
+				continue
+			}
+			t.dts[i] = ms(s.dts)
+			t.cto[i] = int32(pts - t.dts[i])
+		}
+		t.end = ms(t.next)
+		for _, d := range t.dts {
+			shift = max(shift, -d)
+		}
+	}
+	for _, t := range tracks {
+		for i := range t.dts {
+			t.dts[i] += shift
+		}
+		t.end += shift
+	}
+}
+
+// mediaNanos converts v units of timescale to nanoseconds without
+// overflowing for long media.
+func mediaNanos(v, timescale int64) int64 {
+	return v/timescale*1e9 + v%timescale*1e9/timescale
+}
+
+// writeSequenceStart writes the codec configuration of the current sample
+// description of the track, if its codec has one.
+func (t *convertTrack) writeSequenceStart(w *Writer, timestamp uint32) error {
+	e := t.entries[t.entry]
+	if e.config == nil {
+		return nil
+	}
+	pkt := trackPacket{packetType: byte(AudioPacketTypeSequenceStart), fourCC: t.fourCC, body: e.config}
+	if t.trackType == "video" {
+		pkt.packetType = byte(VideoPacketTypeSequenceStart)
+		pkt.frameType = byte(VideoFrameTypeKeyFrame)
+	}
+	return t.writePacket(w, timestamp, pkt)
+}
+
+// writePacket writes a packet of the track: an ExVideoTagHeader or
+// ExAudioTagHeader packet for trackId 0, a OneTrack Multitrack packet
+// otherwise.
+func (t *convertTrack) writePacket(w *Writer, timestamp uint32, pkt trackPacket) error {
+	var err error
+	switch {
+	case t.trackID == 0 && t.trackType == "video":
+		err = w.WriteEnhancedVideoTag(timestamp, pkt.frameType, pkt.packetType, pkt.fourCC, pkt.body)
+	case t.trackID == 0:
+		err = w.WriteEnhancedAudioTag(timestamp, pkt.packetType, pkt.fourCC, pkt.body)
+	default:
+		tagType := TagTypeAudio
+		if t.trackType == "video" {
+			tagType = TagTypeVideo
+		}
+		var data []byte
+		if data, err = buildMultitrackPayload(tagType, []trackPacket{pkt}, []byte{t.trackID}); err != nil {
+			return fmt.Errorf("%s: %w", t.name(), err)
+		}
+		err = w.WriteTag(&Tag{Type: tagType, Timestamp: timestamp, Data: data})
+	}
+	if err != nil {
+		return fmt.Errorf("writing tag: %w", err)
+	}
+	return nil
+}
+
+// convertMetadata encodes the onMetaData script tag payload. The top-level
+// fields describe the first video and audio tracks; the other tracks are
+// described in videoTrackIdInfoMap and audioTrackIdInfoMap.
+func convertMetadata(tracks []*convertTrack) ([]byte, error) {
+	var end int64
+	for _, t := range tracks {
+		end = max(end, t.end)
+	}
+	props := []AMF0Property{{Name: "duration", Value: float64(end) / 1000}}
+
+	var videoMap, audioMap []AMF0Property
+	for _, t := range tracks {
+		info := t.metadata()
+		switch {
+		case t.trackID == 0:
+			props = append(props, info...)
+		case t.trackType == "video":
+			videoMap = append(videoMap, AMF0Property{Name: fmt.Sprint(t.trackID), Value: info})
+		default:
+			audioMap = append(audioMap, AMF0Property{Name: fmt.Sprint(t.trackID), Value: info})
+		}
+	}
+	if videoMap != nil {
+		props = append(props, AMF0Property{Name: "videoTrackIdInfoMap", Value: videoMap})
+	}
+	if audioMap != nil {
+		props = append(props, AMF0Property{Name: "audioTrackIdInfoMap", Value: audioMap})
+	}
+	props = append(props, AMF0Property{Name: "encoder", Value: "eflv"})
+
+	buf, err := EncodeAMF0("onMetaData")
+	if err != nil {
+		return nil, err
+	}
+	return AppendAMF0(buf, NewAMF0ECMAArray(props))
+}
+
+// metadata returns the onMetaData properties of the track.
+func (t *convertTrack) metadata() []AMF0Property {
+	e := t.entries[t.entry]
+	var bytes int64
+	for i := range t.samples {
+		bytes += int64(t.samples[i].size)
+	}
+	duration := float64(t.next-t.samples[0].dts) / float64(t.timescale)
+
+	var props []AMF0Property
+	if t.trackType == "video" {
+		if e.width > 0 && e.height > 0 {
+			props = append(props,
+				AMF0Property{Name: "width", Value: float64(e.width)},
+				AMF0Property{Name: "height", Value: float64(e.height)})
+		}
+		if duration > 0 {
+			props = append(props,
+				AMF0Property{Name: "framerate", Value: roundRate(float64(len(t.samples))/duration, 3)},
+				AMF0Property{Name: "videodatarate", Value: roundRate(float64(bytes)*8/1000/duration, 1)})
+		}
+		return append(props, AMF0Property{Name: "videocodecid", Value: fourCCValue(t.fourCC)})
+	}
+
+	if e.sampleRate > 0 {
+		props = append(props, AMF0Property{Name: "audiosamplerate", Value: float64(e.sampleRate)})
+	}
+	if e.channels > 0 {
+		props = append(props,
+			AMF0Property{Name: "audiochannels", Value: float64(e.channels)},
+			AMF0Property{Name: "stereo", Value: e.channels > 1})
+	}
+	if duration > 0 {
+		props = append(props, AMF0Property{Name: "audiodatarate", Value: roundRate(float64(bytes)*8/1000/duration, 1)})
+	}
+	return append(props, AMF0Property{Name: "audiocodecid", Value: fourCCValue(t.fourCC)})
+}
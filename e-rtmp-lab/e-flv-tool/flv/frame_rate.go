@@ -0,0 +1,317 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// rateTolerance is the relative difference between a measured frame or
+// sample rate and an advertised one above which a warning is reported.
+const rateTolerance = 0.01
+
+// addSamples records the number of samples of an audio frame; 0 means the
+// count is not known for the codec.
+func (t *trackStats) addSamples(n int) {
+	if n == 0 {
+		t.samplesUnknown = true
+	}
+	t.samples += uint64(n)
+	t.lastSamples = uint64(n)
+}
+
+// span returns the DTS span from the first to the last frame in ms, or 0 if
+// it is not positive.
+func (t *trackStats) span() uint32 {
+	if t.frames < 2 || t.lastTS <= t.firstTS {
+		return 0
+	}
+	return t.lastTS - t.firstTS
+}
+
+// frameDurations returns the shortest, average and longest DTS step between
+// two frames in ms. Steps back are not counted.
+func (t *trackStats) frameDurations() (minMS uint32, avgMS float64, maxMS uint32, ok bool) {
+	span := t.span()
+	if span == 0 || len(t.deltas) == 0 {
+		return 0, 0, 0, false
+	}
+	first := true
+	for d := range t.deltas {
+		if first || d < minMS {
+			minMS = d
+		}
+		if d > maxMS {
+			maxMS = d
+		}
+		first = false
+	}
+	return minMS, float64(span) / float64(t.frames-1), maxMS, true
+}
+
+// frameRate returns the measured frame rate in frames per second.
+func (t *trackStats) frameRate() float64 {
+	span := t.span()
+	if span == 0 {
+		return 0
+	}
+	return float64(t.frames-1) * 1000 / float64(span)
+}
+
+// variableFrameRate reports whether more than 1% of the DTS steps differ
+// from the average step by more than the millisecond rounding of the
+// timestamps allows. Dropped frames also count as variable.
+func (t *trackStats) variableFrameRate() bool {
+	_, avg, _, ok := t.frameDurations()
+	if !ok {
+		return false
+	}
+	lo, hi := math.Floor(avg)-1, math.Ceil(avg)+1
+	var total, irregular uint64
+	for d, n := range t.deltas {
+		total += n
+		if float64(d) < lo || float64(d) > hi {
+			irregular += n
+		}
+	}
+	return irregular*100 > total
+}
+
+// sampleRate returns the audio sample rate implied by the number of samples
+// in the frames and their timestamps, or 0 if the sample counts are not
+// known.
+func (t *trackStats) sampleRate() float64 {
+	span := t.span()
+	if span == 0 || t.samplesUnknown || t.samples == 0 {
+		return 0
+	}
+	return float64(t.samples-t.lastSamples) * 1000 / float64(span)
+}
+
+// audioFrameSamples returns the number of samples of an audio frame body at
+// the codec's output sample rate (48 kHz for Opus), or 0 if it cannot be
+// determined. MP3 and E-AC-3 bodies are assumed to hold a single frame.
+func audioFrameSamples(fourCC string, data []byte) int {
+	switch fourCC {
+	case "mp4a":
+		return 1024
+	case "ac-3":
+		return 1536
+	case ".mp3":
+		return mp3FrameSamples(data)
+	case "Opus":
+		return opusPacketSamples(data)
+	case "fLaC":
+		return flacFrameSamples(data)
+	case "ec-3":
+		return eac3FrameSamples(data)
+	}
+	return 0
+}
+
+func mp3FrameSamples(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return 0
+	}
+	version := (data[1] >> 3) & 0x03 // 3 MPEG-1, 2 MPEG-2, 0 MPEG-2.5
+	switch (data[1] >> 1) & 0x03 {
+	case 3: // Layer I
+		return 384
+	case 2: // Layer II
+		return 1152
+	case 1: // Layer III
+		if version == 3 {
+			return 1152
+		}
+		return 576
+	}
+	return 0
+}
+
+// opusPacketSamples decodes the TOC byte of an Opus packet (RFC 6716
+// section 3.1) into its duration in 48 kHz samples.
+func opusPacketSamples(data []byte) int {
+	if len(data) < 1 {
+		return 0
+	}
+	config := data[0] >> 3
+	var frameSize int
+	switch {
+	case config < 12: // SILK-only: 10, 20, 40, 60 ms
+		frameSize = [...]int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10, 20 ms
+		frameSize = [...]int{480, 960}[config%2]
+	default: // CELT-only: 2.5, 5, 10, 20 ms
+		frameSize = [...]int{120, 240, 480, 960}[config%4]
+	}
+	switch data[0] & 0x03 {
+	case 0:
+		return frameSize
+	case 1, 2:
+		return 2 * frameSize
+	}
+	if len(data) < 2 {
+		return 0
+	}
+	return int(data[1]&0x3F) * frameSize
+}
+
+// flacFrameSamples reads the block size of a FLAC frame header.
+func flacFrameSamples(data []byte) int {
+	if len(data) < 5 || data[0] != 0xFF || data[1]&0xFE != 0xF8 {
+		return 0
+	}
+	switch code := int(data[2] >> 4); {
+	case code == 1:
+		return 192
+	case code >= 2 && code <= 5:
+		return 576 << (code - 2)
+	case code >= 8:
+		return 256 << (code - 8)
+	case code == 6 || code == 7:
+		// The block size follows the UTF-8 coded frame or sample number.
+		n := bits.LeadingZeros8(^data[4])
+		if n == 0 {
+			n = 1
+		}
+		pos := 4 + n
+		if code == 6 && pos < len(data) {
+			return int(data[pos]) + 1
+		}
+		if code == 7 && pos+2 <= len(data) {
+			return int(binary.BigEndian.Uint16(data[pos:])) + 1
+		}
+	}
+	return 0
+}
+
+// eac3FrameSamples reads the number of audio blocks of an E-AC-3 syncframe.
+func eac3FrameSamples(data []byte) int {
+	if len(data) < 5 || data[0] != 0x0B || data[1] != 0x77 {
+		return 0
+	}
+	if data[4]>>6 == 3 { // fscod 3: reduced sample rates, always 6 blocks
+		return 6 * 256
+	}
+	return [...]int{1, 2, 3, 6}[(data[4]>>4)&0x03] * 256
+}
+
+// rateReference is a frame or sample rate advertised for a track.
+type rateReference struct {
+	source string
+	value  float64
+}
+
+// rateReferences returns the frame rates (video) or sample rates (audio)
+// that onMetaData and the codec configuration record give for a track.
+func (info *fileInfo) rateReferences(t *trackStats) []rateReference {
+	var refs []rateReference
+	if meta, ok := info.advertisedTrack(t.trackType, t.trackID); ok {
+		if t.trackType == "video" && meta.frameRate != 0 {
+			refs = append(refs, rateReference{"onMetaData framerate", meta.frameRate})
+		}
+		if t.trackType == "audio" && meta.sampleRate != 0 {
+			refs = append(refs, rateReference{"onMetaData audiosamplerate", meta.sampleRate})
+		}
+	}
+
+	for _, cfg := range info.codecConfigs {
+		trackID := byte(0)
+		if cfg.Multitrack {
+			trackID = cfg.TrackID
+		}
+		if cfg.TrackType != t.trackType || trackID != t.trackID {
+			continue
+		}
+		for _, f := range cfg.Fields {
+			v, ok := f.Value.(int)
+			if !ok || v == 0 {
+				continue
+			}
+			switch {
+			case cfg.Codec == "hvc1" && f.Name == "avgFrameRate":
+				// In frames per 256 seconds.
+				refs = append(refs, rateReference{"hvcC avgFrameRate", float64(v) / 256})
+			case cfg.Codec == "mp4a" && f.Name == "samplingFrequency":
+				refs = append(refs, rateReference{"AudioSpecificConfig samplingFrequency", float64(v)})
+			case cfg.Codec == "fLaC" && f.Name == "sampleRate":
+				refs = append(refs, rateReference{"STREAMINFO sampleRate", float64(v)})
+			}
+		}
+		if cfg.Codec == "Opus" {
+			// Opus timestamps always run at 48 kHz, whatever inputSampleRate says.
+			refs = append(refs, rateReference{"Opus output rate", 48000})
+		}
+		break
+	}
+	return refs
+}
+
+// advertisedTrack returns what onMetaData says about a track: the track
+// table built from the track maps, or the top-level fields for trackId 0.
+func (info *fileInfo) advertisedTrack(trackType string, trackID byte) (metadataTrack, bool) {
+	for _, t := range info.metadataTracks {
+		if t.trackType == trackType && t.trackID == trackID {
+			return t, true
+		}
+	}
+	if trackID != 0 || len(info.metadataBlocks) == 0 {
+		return metadataTrack{}, false
+	}
+	return metadataTrackFrom(trackType, 0, info.metadataBlocks[0], nil)
+}
+
+// checkFrameRates warns about measured frame and sample rates that differ
+// from the advertised ones by more than rateTolerance.
+func (info *fileInfo) checkFrameRates() {
+	for _, t := range info.tracks {
+		measured, unit, what := t.frameRate(), "fps", "frame rate"
+		if t.trackType == "audio" {
+			measured, unit, what = t.sampleRate(), "Hz", "sample rate"
+		}
+		if measured == 0 {
+			continue
+		}
+		for _, ref := range info.rateReferences(t) {
+			if math.Abs(measured-ref.value) > ref.value*rateTolerance {
+				info.warnings = append(info.warnings, fmt.Sprintf("%s track %d: measured %s %s differs from %s %g",
+					t.trackType, t.trackID, what, formatRate(measured, unit), ref.source, ref.value))
+			}
+		}
+	}
+}
+
+func formatRate(v float64, unit string) string {
+	if unit == "Hz" {
+		return fmt.Sprintf("%.0f Hz", v)
+	}
+	return fmt.Sprintf("%.3f %s", v, unit)
+}
+
+func printFrameTiming(tracks []*trackStats) {
+	fmt.Printf("Frame Timing\n")
+	fmt.Printf("  %-5s  %5s  %-12s  %22s  %s\n", "Type", "Track", "Codec", "Frame ms (min/avg/max)", "Measured Rate")
+	for _, t := range tracks {
+		minMS, avgMS, maxMS, ok := t.frameDurations()
+		if !ok {
+			fmt.Printf("  %-5s  %5d  %-12s  %22s  %s\n", t.trackType, t.trackID, orDash(t.codec), "-", "-")
+			continue
+		}
+		durations := fmt.Sprintf("%d / %.2f / %d", minMS, avgMS, maxMS)
+		var rate string
+		if t.trackType == "video" {
+			mode := "CFR"
+			if t.variableFrameRate() {
+				mode = "VFR"
+			}
+			rate = fmt.Sprintf("%s (%s)", formatRate(t.frameRate(), "fps"), mode)
+		} else {
+			rate = fmt.Sprintf("%.2f frames/s", 1000/avgMS)
+			if sr := t.sampleRate(); sr != 0 {
+				rate += ", " + formatRate(sr, "Hz")
+			}
+		}
+		fmt.Printf("  %-5s  %5d  %-12s  %22s  %s\n", t.trackType, t.trackID, orDash(t.codec), durations, rate)
+	}
+}
@@ -0,0 +1,298 @@
+package flv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// gopKeyFrame is a keyframe of a video track and the GOP it starts, which
+// runs up to the next keyframe of the track (or to the end of the stream for
+// the last one).
+type gopKeyFrame struct {
+	tagIndex  uint64
+	offset    int64
+	timestamp uint32
+	frames    uint64 // frames of the GOP, keyframe included
+	duration  uint32 // ms from this keyframe to the next one
+	complete  bool   // false for the last GOP, whose end is not known
+	irregular bool
+}
+
+// gopTrack collects the keyframes of one video track.
+type gopTrack struct {
+	trackID   byte
+	codec     string
+	keyFrames []gopKeyFrame
+	lastTS    uint32 // DTS of the last frame
+}
+
+// gopSummary holds min/avg/max over the complete GOPs of a track.
+type gopSummary struct {
+	count                       int
+	minFrames, maxFrames        uint64
+	avgFrames                   float64
+	minDuration, maxDuration    uint32
+	avgDuration, medianDuration float64
+	irregular                   int
+}
+
+// GOPFLV reads the video tags of an FLV/E-FLV file and prints every keyframe
+// with the length of the GOP it starts, and min/avg/max GOP lengths per video
+// track. A GOP whose duration differs from the median GOP duration of its
+// track by more than tolerance percent is flagged as irregular.
+func GOPFLV(inputPath string, jsonOutput bool, tolerance float64) error {
+	tracks, err := readGOPs(inputPath)
+	if err != nil {
+		return err
+	}
+	summaries := make([]gopSummary, len(tracks))
+	for i, t := range tracks {
+		summaries[i] = t.summarize(tolerance)
+	}
+
+	if jsonOutput {
+		return printGOPJSON(inputPath, tracks, summaries)
+	}
+	printGOPText(inputPath, tracks, summaries, tolerance)
+	return nil
+}
+
+func readGOPs(inputPath string) ([]*gopTrack, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []*gopTrack
+	var tagIndex uint64
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tagIndex++
+		if tag.Type != TagTypeVideo {
+			continue
+		}
+
+		pkt, err := ParseVideoPacket(tag.Data)
+		if err != nil {
+			continue
+		}
+		if pkt.PacketType != VideoPacketTypeCodedFrames && pkt.PacketType != VideoPacketTypeCodedFramesX {
+			continue
+		}
+		for _, vt := range pkt.Tracks {
+			var t *gopTrack
+			for _, existing := range tracks {
+				if existing.trackID == vt.TrackID {
+					t = existing
+				}
+			}
+			if t == nil {
+				codec := vt.FourCC
+				if codec == "" {
+					codec = enumName(videoCodecNames, pkt.CodecID)
+				}
+				t = &gopTrack{trackID: vt.TrackID, codec: codec}
+				tracks = append(tracks, t)
+			}
+			t.addFrame(tagIndex, tag.Offset, tag.Timestamp, pkt.FrameType.IsKeyFrame())
+		}
+	}
+	return tracks, nil
+}
+
+func (t *gopTrack) addFrame(tagIndex uint64, offset int64, timestamp uint32, keyFrame bool) {
+	t.lastTS = timestamp
+	if keyFrame {
+		if n := len(t.keyFrames); n > 0 {
+			prev := &t.keyFrames[n-1]
+			prev.complete = true
+			if timestamp > prev.timestamp {
+				prev.duration = timestamp - prev.timestamp
+			}
+		}
+		t.keyFrames = append(t.keyFrames, gopKeyFrame{tagIndex: tagIndex, offset: offset, timestamp: timestamp})
+	}
+	// Frames before the first keyframe do not belong to any GOP.
+	if n := len(t.keyFrames); n > 0 {
+		t.keyFrames[n-1].frames++
+	}
+}
+
+// summarize computes the GOP statistics of the complete GOPs and flags the
+// irregular ones. The last GOP gets its duration up to the last frame.
+func (t *gopTrack) summarize(tolerance float64) gopSummary {
+	var s gopSummary
+	var durations []float64
+	for i := range t.keyFrames {
+		k := &t.keyFrames[i]
+		if !k.complete {
+			if t.lastTS > k.timestamp {
+				k.duration = t.lastTS - k.timestamp
+			}
+			continue
+		}
+		if s.count == 0 || k.frames < s.minFrames {
+			s.minFrames = k.frames
+		}
+		if k.frames > s.maxFrames {
+			s.maxFrames = k.frames
+		}
+		if s.count == 0 || k.duration < s.minDuration {
+			s.minDuration = k.duration
+		}
+		if k.duration > s.maxDuration {
+			s.maxDuration = k.duration
+		}
+		s.avgFrames += float64(k.frames)
+		s.avgDuration += float64(k.duration)
+		durations = append(durations, float64(k.duration))
+		s.count++
+	}
+	if s.count == 0 {
+		return s
+	}
+	s.avgFrames /= float64(s.count)
+	s.avgDuration /= float64(s.count)
+
+	sort.Float64s(durations)
+	if n := len(durations); n%2 == 1 {
+		s.medianDuration = durations[n/2]
+	} else {
+		s.medianDuration = (durations[n/2-1] + durations[n/2]) / 2
+	}
+	for i := range t.keyFrames {
+		k := &t.keyFrames[i]
+		if k.complete && s.medianDuration > 0 && math.Abs(float64(k.duration)-s.medianDuration) > s.medianDuration*tolerance/100 {
+			k.irregular = true
+			s.irregular++
+		}
+	}
+	return s
+}
+
+func printGOPText(inputPath string, tracks []*gopTrack, summaries []gopSummary, tolerance float64) {
+	fmt.Printf("File: %s\n", inputPath)
+	if len(tracks) == 0 {
+		fmt.Println()
+		fmt.Printf("No video frames\n")
+		return
+	}
+
+	for i, t := range tracks {
+		s := summaries[i]
+		fmt.Println()
+		fmt.Printf("GOP (video: %s, track %d)\n", t.codec, t.trackID)
+		fmt.Printf("  %6s  %10s  %10s  %10s  %10s\n", "Tag", "Offset", "DTS", "GOP frames", "GOP ms")
+		for _, k := range t.keyFrames {
+			note := ""
+			switch {
+			case !k.complete:
+				note = "  (until end of stream)"
+			case k.irregular:
+				note = fmt.Sprintf("  irregular (%+.0f%% from median)", (float64(k.duration)-s.medianDuration)/s.medianDuration*100)
+			}
+			fmt.Printf("  %6d  %10d  %10d  %10d  %10d%s\n", k.tagIndex, k.offset, k.timestamp, k.frames, k.duration, note)
+		}
+
+		fmt.Printf("  Keyframes: %d\n", len(t.keyFrames))
+		if s.count == 0 {
+			fmt.Printf("  No complete GOP\n")
+			continue
+		}
+		fmt.Printf("  GOPs:      %d complete\n", s.count)
+		fmt.Printf("  Frames:    min %d, avg %.1f, max %d\n", s.minFrames, s.avgFrames, s.maxFrames)
+		fmt.Printf("  Duration:  min %d ms, avg %.1f ms, max %d ms (median %.1f ms)\n",
+			s.minDuration, s.avgDuration, s.maxDuration, s.medianDuration)
+		fmt.Printf("  Irregular: %d (more than %g%% from median)\n", s.irregular, tolerance)
+	}
+}
+
+// gopReport is the document printed by `eflv gop --json`.
+type gopReport struct {
+	File   string           `json:"file"`
+	Tracks []gopReportTrack `json:"tracks"`
+}
+
+type gopReportTrack struct {
+	TrackID   byte                `json:"trackId"`
+	Codec     string              `json:"codec"`
+	KeyFrames []gopReportKeyFrame `json:"keyFrames"`
+	Summary   *gopReportSummary   `json:"summary"` // null without a complete GOP
+}
+
+type gopReportKeyFrame struct {
+	Index     uint64 `json:"index"`
+	Offset    int64  `json:"offset"`
+	DTS       uint32 `json:"dts"`
+	Frames    uint64 `json:"gopFrames"`
+	Duration  uint32 `json:"gopDuration"`
+	Complete  bool   `json:"complete"`
+	Irregular bool   `json:"irregular"`
+}
+
+type gopReportSummary struct {
+	GOPs           int     `json:"gops"`
+	MinFrames      uint64  `json:"minFrames"`
+	AvgFrames      float64 `json:"avgFrames"`
+	MaxFrames      uint64  `json:"maxFrames"`
+	MinDuration    uint32  `json:"minDuration"`
+	AvgDuration    float64 `json:"avgDuration"`
+	MaxDuration    uint32  `json:"maxDuration"`
+	MedianDuration float64 `json:"medianDuration"`
+	Irregular      int     `json:"irregular"`
+}
+
+func printGOPJSON(inputPath string, tracks []*gopTrack, summaries []gopSummary) error {
+	report := gopReport{File: inputPath, Tracks: []gopReportTrack{}}
+	for i, t := range tracks {
+		rt := gopReportTrack{TrackID: t.trackID, Codec: t.codec, KeyFrames: []gopReportKeyFrame{}}
+		for _, k := range t.keyFrames {
+			rt.KeyFrames = append(rt.KeyFrames, gopReportKeyFrame{
+				Index:     k.tagIndex,
+				Offset:    k.offset,
+				DTS:       k.timestamp,
+				Frames:    k.frames,
+				Duration:  k.duration,
+				Complete:  k.complete,
+				Irregular: k.irregular,
+			})
+		}
+		if s := summaries[i]; s.count > 0 {
+			rt.Summary = &gopReportSummary{
+				GOPs:           s.count,
+				MinFrames:      s.minFrames,
+				AvgFrames:      s.avgFrames,
+				MaxFrames:      s.maxFrames,
+				MinDuration:    s.minDuration,
+				AvgDuration:    s.avgDuration,
+				MaxDuration:    s.maxDuration,
+				MedianDuration: s.medianDuration,
+				Irregular:      s.irregular,
+			}
+		}
+		report.Tracks = append(report.Tracks, rt)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encoding JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// infoReport is the document printed by `eflv info --json`. Field names are
+// part of the tool's interface; add new fields rather than renaming existing
+// ones.
+type infoReport struct {
+	File                   string                 `json:"file"`
+	Size                   int64                  `json:"size"`
+	Format                 string                 `json:"format"`
+	Header                 infoReportHeader       `json:"header"`
+	Tags                   infoReportTags         `json:"tags"`
+	Tracks                 []infoReportTrack      `json:"tracks"`
+	Warnings               []string               `json:"warnings"`
+	Metadata               []jsonAMF0Object       `json:"metadata"`
+	CodecConfigs           []infoReportConfig     `json:"codecConfigs"`
+	VP9KeyframeResolutions []infoReportResolution `json:"vp9KeyframeResolutions"`
+	ColorInfo              []infoReportColorInfo  `json:"colorInfo"`
+	ScriptData             []infoReportScript     `json:"scriptData"`
+	MetadataTracks         []infoReportTrackInfo  `json:"metadataTracks"`
+	TagList                []infoReportTag        `json:"tagList,omitempty"` // --verbose only
+}
+
+type infoReportHeader struct {
+	Version    uint8  `json:"version"`
+	HasAudio   bool   `json:"hasAudio"`
+	HasVideo   bool   `json:"hasVideo"`
+	DataOffset uint32 `json:"dataOffset"`
+}
+
+type infoReportTags struct {
+	Total  uint64 `json:"total"`
+	Audio  uint64 `json:"audio"`
+	Video  uint64 `json:"video"`
+	Script uint64 `json:"script"`
+	Other  uint64 `json:"other"`
+}
+
+// infoReportTrack summarizes one audio or video track (see trackStats).
+type infoReportTrack struct {
+	TrackType   string  `json:"trackType"`
+	TrackID     byte    `json:"trackId"`
+	Codec       string  `json:"codec"`
+	Frames      uint64  `json:"frames"`
+	KeyFrames   *uint64 `json:"keyFrames,omitempty"` // video only
+	FirstDTS    *uint32 `json:"firstDts,omitempty"`  // omitted without frames
+	LastDTS     *uint32 `json:"lastDts,omitempty"`
+	Duration    float64 `json:"duration"` // ms
+	Bytes       uint64  `json:"bytes"`
+	AvgBitrate  float64 `json:"avgBitrate"`  // kbit/s
+	PeakBitrate float64 `json:"peakBitrate"` // kbit/s over one-second intervals
+
+	// Frame timing measured from the DTS steps, omitted with fewer than two
+	// frames.
+	MinFrameDuration  *uint32  `json:"minFrameDuration,omitempty"` // ms
+	AvgFrameDuration  *float64 `json:"avgFrameDuration,omitempty"`
+	MaxFrameDuration  *uint32  `json:"maxFrameDuration,omitempty"`
+	FrameRate         *float64 `json:"frameRate,omitempty"`         // video, fps
+	VariableFrameRate *bool    `json:"variableFrameRate,omitempty"` // video
+	SampleRate        *float64 `json:"sampleRate,omitempty"`        // audio, Hz, if the codec's frame size is known
+}
+
+type infoReportConfig struct {
+	TrackType string           `json:"trackType"`
+	Codec     string           `json:"codec"`
+	TrackID   *byte            `json:"trackId,omitempty"` // multitrack packets only
+	Fields    jsonConfigFields `json:"fields"`
+}
+
+type infoReportResolution struct {
+	Codec  string `json:"codec"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// infoReportColorInfo is a colorInfo object from a Metadata video packet,
+// listed each time it changes.
+type infoReportColorInfo struct {
+	DTS     uint32         `json:"dts"`
+	Codec   string         `json:"codec"`
+	TrackID *byte          `json:"trackId,omitempty"` // multitrack packets only
+	Value   jsonAMF0Object `json:"value"`
+}
+
+// infoReportScript is one script data tag.
+type infoReportScript struct {
+	Index     uint64        `json:"index"`
+	DTS       uint32        `json:"dts"`
+	Method    string        `json:"method"`
+	Wrapper   string        `json:"wrapper,omitempty"` // @setDataFrame or @clearDataFrame
+	AMF3      bool          `json:"amf3,omitempty"`    // tag type 15
+	Arguments jsonAMF0Array `json:"arguments"`
+}
+
+// infoReportTrackInfo is one track advertised by onMetaData. Properties
+// that are not given are omitted.
+type infoReportTrackInfo struct {
+	TrackType  string  `json:"trackType"`
+	TrackID    byte    `json:"trackId"`
+	Codec      string  `json:"codec,omitempty"`
+	Bitrate    float64 `json:"bitrate,omitempty"` // kbit/s
+	Width      float64 `json:"width,omitempty"`
+	Height     float64 `json:"height,omitempty"`
+	FrameRate  float64 `json:"frameRate,omitempty"`
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	Channels   float64 `json:"channels,omitempty"`
+	Language   string  `json:"language,omitempty"`
+}
+
+// infoReportTag is one tagList entry. Header-derived fields that do not
+// apply to a tag are omitted.
+type infoReportTag struct {
+	Index                 uint64  `json:"index"`
+	Offset                int64   `json:"offset"`
+	Type                  string  `json:"type"`
+	DataSize              int     `json:"dataSize"`
+	DTS                   uint32  `json:"dts"`
+	StreamID              uint32  `json:"streamId"`
+	CompositionTimeOffset *int32  `json:"compositionTimeOffset,omitempty"`
+	PTS                   *int64  `json:"pts,omitempty"`
+	TimestampOffsetNano   *uint32 `json:"timestampOffsetNano,omitempty"`
+	FrameType             string  `json:"frameType,omitempty"`
+	Codec                 string  `json:"codec,omitempty"`
+	PacketType            string  `json:"packetType,omitempty"`
+}
+
+func printInfoJSON(inputPath string, info *fileInfo) error {
+	report := infoReport{
+		File:   inputPath,
+		Size:   info.size,
+		Format: "FLV/E-FLV",
+		Header: infoReportHeader{
+			Version:    info.header.Version,
+			HasAudio:   info.header.HasAudio,
+			HasVideo:   info.header.HasVideo,
+			DataOffset: info.header.DataOffset,
+		},
+		Tags: infoReportTags{
+			Total:  info.totalTags,
+			Audio:  info.audioTags,
+			Video:  info.videoTags,
+			Script: info.scriptTags,
+			Other:  info.otherTags,
+		},
+		// Empty lists are emitted as [] rather than null so that jq filters
+		// such as `.codecConfigs | length` work on every file.
+		Tracks:                 []infoReportTrack{},
+		Warnings:               []string{},
+		Metadata:               []jsonAMF0Object{},
+		CodecConfigs:           []infoReportConfig{},
+		VP9KeyframeResolutions: []infoReportResolution{},
+		ColorInfo:              []infoReportColorInfo{},
+		ScriptData:             []infoReportScript{},
+		MetadataTracks:         []infoReportTrackInfo{},
+	}
+	report.Warnings = append(report.Warnings, info.warnings...)
+	for _, props := range info.metadataBlocks {
+		report.Metadata = append(report.Metadata, jsonAMF0Object(props))
+	}
+	for _, t := range info.tracks {
+		rt := infoReportTrack{
+			TrackType:   t.trackType,
+			TrackID:     t.trackID,
+			Codec:       t.codec,
+			Frames:      t.frames,
+			Duration:    t.duration(),
+			Bytes:       t.bytes,
+			AvgBitrate:  t.avgBitrate(),
+			PeakBitrate: t.peakBitrate(),
+		}
+		if t.trackType == "video" {
+			keyFrames := t.keyFrames
+			rt.KeyFrames = &keyFrames
+		}
+		if t.frames > 0 {
+			first, last := t.firstTS, t.lastTS
+			rt.FirstDTS, rt.LastDTS = &first, &last
+		}
+		if minMS, avgMS, maxMS, ok := t.frameDurations(); ok {
+			rt.MinFrameDuration, rt.AvgFrameDuration, rt.MaxFrameDuration = &minMS, &avgMS, &maxMS
+			if t.trackType == "video" {
+				frameRate, vfr := t.frameRate(), t.variableFrameRate()
+				rt.FrameRate, rt.VariableFrameRate = &frameRate, &vfr
+			} else if sr := t.sampleRate(); sr != 0 {
+				rt.SampleRate = &sr
+			}
+		}
+		report.Tracks = append(report.Tracks, rt)
+	}
+	for _, cfg := range info.codecConfigs {
+		rc := infoReportConfig{
+			TrackType: cfg.TrackType,
+			Codec:     cfg.Codec,
+			Fields:    jsonConfigFields(cfg.Fields),
+		}
+		if cfg.Multitrack {
+			trackID := cfg.TrackID
+			rc.TrackID = &trackID
+		}
+		report.CodecConfigs = append(report.CodecConfigs, rc)
+	}
+	for _, res := range info.vp9Resolutions {
+		report.VP9KeyframeResolutions = append(report.VP9KeyframeResolutions, infoReportResolution{
+			Codec:  res.codec,
+			Width:  res.width,
+			Height: res.height,
+		})
+	}
+	for _, c := range info.colorInfos {
+		rc := infoReportColorInfo{DTS: c.timestamp, Codec: c.codec, Value: jsonAMF0Object(c.props)}
+		if c.multitrack {
+			trackID := c.trackID
+			rc.TrackID = &trackID
+		}
+		report.ColorInfo = append(report.ColorInfo, rc)
+	}
+	for _, sd := range info.scripts {
+		report.ScriptData = append(report.ScriptData, infoReportScript{
+			Index:     sd.tagIndex,
+			DTS:       sd.timestamp,
+			Method:    sd.method,
+			Wrapper:   sd.wrapper,
+			AMF3:      sd.amf3,
+			Arguments: jsonAMF0Array(sd.args),
+		})
+	}
+	for _, t := range info.metadataTracks {
+		report.MetadataTracks = append(report.MetadataTracks, infoReportTrackInfo{
+			TrackType:  t.trackType,
+			TrackID:    t.trackID,
+			Codec:      t.codec,
+			Bitrate:    t.bitrate,
+			Width:      t.width,
+			Height:     t.height,
+			FrameRate:  t.frameRate,
+			SampleRate: t.sampleRate,
+			Channels:   t.channels,
+			Language:   t.language,
+		})
+	}
+	for _, e := range info.tagList {
+		tag := infoReportTag{
+			Index:      e.index,
+			Offset:     e.offset,
+			Type:       e.tagType.String(),
+			DataSize:   e.dataSize,
+			DTS:        e.timestamp,
+			StreamID:   e.streamID,
+			FrameType:  e.frameType,
+			Codec:      e.codec,
+			PacketType: e.packetType,
+		}
+		if e.hasCTO {
+			cto := e.cto
+			tag.CompositionTimeOffset = &cto
+		}
+		if e.hasPTS {
+			pts := e.pts
+			tag.PTS = &pts
+		}
+		if e.hasNano {
+			nano := e.nanoOffset
+			tag.TimestampOffsetNano = &nano
+		}
+		report.TagList = append(report.TagList, tag)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encoding JSON: %w", err)
+	}
+	return nil
+}
+
+// jsonAMF0Object marshals AMF0 properties as a JSON object in file order.
+// Each value is wrapped as {"type": <AMF0 type>, "value": <value>} so the
+// AMF0 type survives the conversion to JSON.
+type jsonAMF0Object []AMF0Property
+
+func (o jsonAMF0Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalAMF0Value(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonAMF0Typed is the JSON form of a single AMF0 value.
+type jsonAMF0Typed struct {
+	Type      string  `json:"type"`
+	ClassName string  `json:"className,omitempty"` // typed objects only
+	Count     *uint32 `json:"count,omitempty"`     // ECMA arrays only
+	Value     any     `json:"value"`
+}
+
+// jsonAMF0Array marshals AMF0 values as a JSON array of typed values.
+type jsonAMF0Array []any
+
+func (a jsonAMF0Array) MarshalJSON() ([]byte, error) {
+	items := make([]json.RawMessage, 0, len(a))
+	for _, v := range a {
+		b, err := marshalAMF0Value(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, b)
+	}
+	return json.Marshal(items)
+}
+
+func marshalAMF0Value(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			// Not representable as a JSON number.
+			return json.Marshal(jsonAMF0Typed{Type: "number", Value: fmt.Sprint(v)})
+		}
+		return json.Marshal(jsonAMF0Typed{Type: "number", Value: v})
+	case bool:
+		return json.Marshal(jsonAMF0Typed{Type: "boolean", Value: v})
+	case string:
+		return json.Marshal(jsonAMF0Typed{Type: "string", Value: v})
+	case AMF0LongString:
+		return json.Marshal(jsonAMF0Typed{Type: "longString", Value: string(v)})
+	case []AMF0Property:
+		return json.Marshal(jsonAMF0Typed{Type: "object", Value: jsonAMF0Object(v)})
+	case AMF0ECMAArray:
+		return json.Marshal(jsonAMF0Typed{Type: "ecmaArray", Count: &v.Count, Value: jsonAMF0Object(v.Properties)})
+	case []any:
+		return json.Marshal(jsonAMF0Typed{Type: "strictArray", Value: jsonAMF0Array(v)})
+	case nil:
+		return json.Marshal(jsonAMF0Typed{Type: "null", Value: nil})
+	case AMF0Undefined:
+		return json.Marshal(jsonAMF0Typed{Type: "undefined", Value: nil})
+	case AMF0Unsupported:
+		return json.Marshal(jsonAMF0Typed{Type: "unsupported", Value: nil})
+	case AMF0Reserved:
+		name := "movieClip"
+		if v.Marker == amf0RecordSet {
+			name = "recordSet"
+		}
+		return json.Marshal(jsonAMF0Typed{Type: name, Value: nil})
+	case AMF0XMLDocument:
+		return json.Marshal(jsonAMF0Typed{Type: "xmlDocument", Value: string(v)})
+	case AMF0TypedObject:
+		return json.Marshal(jsonAMF0Typed{Type: "typedObject", ClassName: v.ClassName, Value: jsonAMF0Object(v.Properties)})
+	case time.Time:
+		return json.Marshal(jsonAMF0Typed{Type: "date", Value: v.Format(time.RFC3339Nano)})
+	case []byte:
+		return json.Marshal(jsonAMF0Typed{Type: "byteArray", Value: hex.EncodeToString(v)})
+	default:
+		return json.Marshal(jsonAMF0Typed{Type: fmt.Sprintf("%T", v), Value: fmt.Sprint(v)})
+	}
+}
+
+// jsonConfigFields marshals codec configuration fields as a JSON object in
+// record order.
+type jsonConfigFields []ConfigField
+
+func (f jsonConfigFields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -1,20 +1,707 @@
 package flv
 
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// mergeInput tracks one side of a merge: the open file, its parsed header
+// and the next tag waiting to be written (nil once the input is exhausted).
+// The track ID and tracks are only populated for multitrack merges.
+type mergeInput struct {
+	path string
+	f    *os.File
+	r    *Reader
+	next *Tag
+
+	metadata []AMF0Property // first onMetaData of the input
+	hasVideo bool           // the input has video tags
+	hasAudio bool           // the input has audio tags
+	trackID  byte
+	video    mergeTrack
+	audio    mergeTrack
+}
+
+// mergeTrack summarizes the video or audio track of a multitrack merge input
+// for its onMetaData: its codec, configuration and frame counts.
+type mergeTrack struct {
+	fourCC string // codec of the first packet, "" if none
+	config []byte // body of the first SequenceStart packet
+	first  []byte // first coded frame, to probe what the config lacks
+	frames int
+	bytes  int64
+
+	firstDTS, lastDTS uint32
+}
+
+// add records a packet of the track, a tag of tagType with timestamp dts.
+func (t *mergeTrack) add(tagType TagType, pkt trackPacket, dts uint32) {
+	if t.fourCC == "" {
+		t.fourCC = pkt.fourCC
+	}
+	if pkt.fourCC != t.fourCC {
+		// Only the first codec of the track is described.
+		return
+	}
+
+	body := pkt.body
+	coded := pkt.packetType == byte(AudioPacketTypeCodedFrames)
+	if tagType == TagTypeVideo {
+		coded = pkt.packetType == byte(VideoPacketTypeCodedFrames) || pkt.packetType == byte(VideoPacketTypeCodedFramesX)
+		if pkt.packetType == byte(VideoPacketTypeCodedFrames) && hasCompositionTimeOffset(pkt.fourCC) {
+			body = body[3:]
+		}
+	}
+	switch {
+	case pkt.packetType == byte(AudioPacketTypeSequenceStart): // same value for video
+		if t.config == nil && len(body) > 0 {
+			t.config = body
+		}
+	case coded:
+		if t.frames == 0 {
+			t.first = body
+			t.firstDTS = dts
+		}
+		t.frames++
+		t.bytes += int64(len(body))
+		t.lastDTS = dts
+	}
+}
+
+// trackPacket is a single-track audio or video packet in enhanced form:
+// everything that follows the (Ex)TagHeader for one track. For CodedFrames
+// of avc1/hvc1 the body starts with the SI24 composition time offset.
+type trackPacket struct {
+	frameType  byte // VideoFrameType, video only
+	packetType byte
+	fourCC     string
+	modEx      []ModEx // packet modifiers, e.g. TimestampOffsetNano
+	body       []byte
+}
+
+// onMetaData properties that describe the video or audio stream. A plain
+// merge takes them from inputB when inputA has none.
+var (
+	videoMetadataKeys = []string{"videocodecid", "width", "height", "framerate", "videodatarate"}
+	audioMetadataKeys = []string{"audiocodecid", "audiodatarate", "audiosamplerate", "audiosamplesize", "audiochannels", "stereo"}
+)
 
 // MergeFLV merges two FLV/E-FLV files into a single output file.
+//
+// Tags from both inputs are interleaved by DTS. When two tags share a
+// timestamp, script tags are written before media tags and tags from
+// inputA are written before tags from inputB. PreviousTagSize values are
+// recomputed and the header HasAudio/HasVideo flags reflect the tags that
+// were actually written. The onMetaData tags of the inputs (AMF0 or AMF3
+// script tags) are replaced by a single onMetaData at the start: inputA's
+// properties with the longer of the two durations and without filesize, plus
+// inputB's video or audio properties if inputA has none. Inputs that both
+// have video, or both have audio, are rejected: their frames would be
+// interleaved into one track.
+//
+// When multitrack is set, every audio and video packet is rewritten as an
+// E-RTMP multitrack packet: inputA's tracks use trackId 0 and inputB's use
+// trackId 1. Packets of the same media type, packet type and timestamp from
+// both inputs are combined into a single ManyTracks (or ManyTracksManyCodecs)
+// tag. The merged onMetaData then has top-level fields that describe inputA
+// and a videoTrackIdInfoMap / audioTrackIdInfoMap that describe inputB.
 func MergeFLV(inputA, inputB, outputPath string, multitrack bool) error {
-	fmt.Printf("TODO: merge FLV files: %s + %s -> %s\n", inputA, inputB, outputPath)
+	a, err := openMergeInput(inputA)
+	if err != nil {
+		return err
+	}
+	defer a.f.Close()
+
+	b, err := openMergeInput(inputB)
+	if err != nil {
+		return err
+	}
+	defer b.f.Close()
+
+	if err := a.probe(multitrack); err != nil {
+		return err
+	}
+	if err := b.probe(multitrack); err != nil {
+		return err
+	}
+	if !multitrack {
+		for _, media := range []struct {
+			name string
+			a, b bool
+		}{{"video", a.hasVideo, b.hasVideo}, {"audio", a.hasAudio, b.hasAudio}} {
+			if media.a && media.b {
+				return fmt.Errorf("%s and %s both have %s, which would be interleaved into one track; use --multitrack to keep them as separate tracks",
+					inputA, inputB, media.name)
+			}
+		}
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("creating output: %w", err)
+	}
+	defer out.Close()
+
+	bw := bufio.NewWriterSize(out, 1<<20)
+	// Flags are patched once all tags have been written.
+	w, err := NewWriter(bw, false, false)
+	if err != nil {
+		return err
+	}
+
+	var audioTags, videoTags, scriptTags uint64
+	var metadata []byte
 	if multitrack {
-		fmt.Println("  (multitrack mode requested)")
+		a.trackID, b.trackID = 0, 1
+		metadata, err = multitrackMetadata(a, b)
+	} else {
+		metadata, err = mergedMetadata(a, b)
+	}
+	if err != nil {
+		return fmt.Errorf("encoding onMetaData: %w", err)
+	}
+	if metadata != nil {
+		if err := w.WriteScriptTag(0, metadata); err != nil {
+			return fmt.Errorf("writing tag: %w", err)
+		}
+		scriptTags++
+	}
+
+	for a.next != nil || b.next != nil {
+		src, other := a, b
+		if a.next == nil || (b.next != nil && mergeBefore(b.next, a.next)) {
+			src, other = b, a
+		}
+
+		tag := src.next
+		if isMetadataTag(tag) {
+			// Replaced by the merged onMetaData written above.
+			if err := src.advance(); err != nil {
+				return err
+			}
+			continue
+		}
+		if multitrack && (tag.Type == TagTypeAudio || tag.Type == TagTypeVideo) {
+			mt, combined, err := multitrackTag(src, other)
+			if err != nil {
+				return fmt.Errorf("%s: %w", src.path, err)
+			}
+			tag = mt
+			if combined {
+				if err := other.advance(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := w.WriteTag(tag); err != nil {
+			return fmt.Errorf("writing tag: %w", err)
+		}
+		switch tag.Type {
+		case TagTypeAudio:
+			audioTags++
+		case TagTypeVideo:
+			videoTags++
+		case TagTypeScript, TagTypeScriptAMF3:
+			scriptTags++
+		}
+
+		if err := src.advance(); err != nil {
+			return err
+		}
 	}
 
-	// TODO: Open and validate both input FLV files
-	// TODO: Parse headers and tags from both inputs
-	// TODO: If multitrack, assign separate track groups per input
-	// TODO: Interleave tags by timestamp
-	// TODO: Write valid FLV header and merged tags to output
-	// TODO: Ensure output is a valid .flv file
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if _, err := out.WriteAt([]byte{headerFlags(audioTags > 0, videoTags > 0)}, 4); err != nil {
+		return fmt.Errorf("updating header flags: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing output: %w", err)
+	}
 
+	fmt.Printf("Output: %s\n", outputPath)
+	fmt.Printf("  Audio:  %d\n", audioTags)
+	fmt.Printf("  Video:  %d\n", videoTags)
+	fmt.Printf("  Script: %d\n", scriptTags)
 	return nil
 }
+
+// mergeBefore reports whether x must be written before y when both inputs
+// have a tag pending. Ties keep the current order (inputA first), except that
+// script data is moved ahead of media so metadata precedes the frames it
+// describes.
+func mergeBefore(x, y *Tag) bool {
+	if x.Timestamp != y.Timestamp {
+		return x.Timestamp < y.Timestamp
+	}
+	return x.Type == TagTypeScript && y.Type != TagTypeScript
+}
+
+func openMergeInput(path string) (*mergeInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	in := &mergeInput{path: path, f: f, r: r}
+	if err := in.advance(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// advance reads the next tag into in.next, or sets it to nil at end of file.
+func (in *mergeInput) advance() error {
+	tag, err := in.r.Next()
+	if err == io.EOF {
+		in.next = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", in.path, err)
+	}
+	in.next = tag
+	return nil
+}
+
+// probe scans the input from the start and records its first onMetaData,
+// whether it has video and audio and, if codecs is set, its video and audio
+// tracks, which takes a pass over the whole input. It does not disturb the merge position.
+func (in *mergeInput) probe(codecs bool) error {
+	f, err := os.Open(in.path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", in.path, err)
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in.path, err)
+	}
+
+	for in.metadata == nil || !in.hasVideo || !in.hasAudio || codecs {
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+
+		switch tag.Type {
+		case TagTypeScript, TagTypeScriptAMF3:
+			if in.metadata == nil && isMetadataTag(tag) {
+				// Properties decoded before a malformed value are still merged.
+				payload, _ := scriptPayload(tag)
+				props, _ := parseScriptTag(bytes.NewReader(payload), len(payload))
+				in.metadata = props
+			}
+		case TagTypeVideo, TagTypeAudio:
+			if tag.Type == TagTypeVideo {
+				in.hasVideo = true
+			} else {
+				in.hasAudio = true
+			}
+			if !codecs {
+				continue
+			}
+			pkt, ok, err := enhancedTrackPacket(tag.Type, tag.Data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", in.path, err)
+			}
+			if !ok {
+				continue
+			}
+			if tag.Type == TagTypeVideo {
+				in.video.add(tag.Type, pkt, tag.Timestamp)
+			} else {
+				in.audio.add(tag.Type, pkt, tag.Timestamp)
+			}
+		}
+	}
+	return nil
+}
+
+// mergedMetadata encodes the onMetaData script tag payload for a plain merge
+// of a and b, or returns nil if neither input has an onMetaData.
+func mergedMetadata(a, b *mergeInput) ([]byte, error) {
+	if a.metadata == nil && b.metadata == nil {
+		return nil, nil
+	}
+	var props []AMF0Property
+	for _, p := range a.metadata {
+		switch p.Name {
+		case "filesize", "duration":
+			// filesize is stale after merging; duration is set below.
+		default:
+			props = append(props, p)
+		}
+	}
+	for _, keys := range [][]string{videoMetadataKeys, audioMetadataKeys} {
+		if len(metadataSubset(a.metadata, keys)) == 0 {
+			props = append(props, metadataSubset(b.metadata, keys)...)
+		}
+	}
+	if duration := mergedDuration(a, b); duration > 0 {
+		props = append([]AMF0Property{{Name: "duration", Value: duration}}, props...)
+	}
+
+	buf, err := EncodeAMF0("onMetaData")
+	if err != nil {
+		return nil, err
+	}
+	return AppendAMF0(buf, NewAMF0ECMAArray(props))
+}
+
+// mergedDuration returns the longer of the onMetaData durations of a and b,
+// or 0 if neither has one.
+func mergedDuration(a, b *mergeInput) float64 {
+	duration := 0.0
+	for _, in := range []*mergeInput{a, b} {
+		if v, ok := amf0Lookup(in.metadata, "duration"); ok {
+			if d, ok := v.(float64); ok && d > duration {
+				duration = d
+			}
+		}
+	}
+	return duration
+}
+
+// multitrackMetadata encodes the onMetaData script tag payload for a
+// multitrack merge of a (trackId 0) and b (trackId 1).
+func multitrackMetadata(a, b *mergeInput) ([]byte, error) {
+	var props []AMF0Property
+	for _, p := range a.metadata {
+		switch p.Name {
+		case "filesize", "duration", "videocodecid", "audiocodecid":
+			// filesize is stale after merging and duration is set below;
+			// codec IDs are rewritten since legacy codecs are converted to
+			// their FourCC.
+		default:
+			props = append(props, p)
+		}
+	}
+	if duration := mergedDuration(a, b); duration > 0 {
+		props = append([]AMF0Property{{Name: "duration", Value: duration}}, props...)
+	}
+
+	if a.video.fourCC != "" {
+		props = append(props, AMF0Property{Name: "videocodecid", Value: fourCCValue(a.video.fourCC)})
+	}
+	if a.audio.fourCC != "" {
+		props = append(props, AMF0Property{Name: "audiocodecid", Value: fourCCValue(a.audio.fourCC)})
+	}
+
+	trackKey := fmt.Sprint(b.trackID)
+	if b.video.fourCC != "" {
+		props = append(props, AMF0Property{
+			Name:  "videoTrackIdInfoMap",
+			Value: []AMF0Property{{Name: trackKey, Value: b.trackInfo("video")}},
+		})
+	}
+	if b.audio.fourCC != "" {
+		props = append(props, AMF0Property{
+			Name:  "audioTrackIdInfoMap",
+			Value: []AMF0Property{{Name: trackKey, Value: b.trackInfo("audio")}},
+		})
+	}
+
+	buf, err := EncodeAMF0("onMetaData")
+	if err != nil {
+		return nil, err
+	}
+	return AppendAMF0(buf, NewAMF0ECMAArray(props))
+}
+
+// trackInfo returns the videoTrackIdInfoMap or audioTrackIdInfoMap entry for
+// the input's track of trackType. Map entries inherit missing properties from
+// the top-level fields, which describe the other input, so every property is
+// written: frame size, sample rate, channels and sample size from the codec
+// configuration (or the first frame), falling back to the input's
+// onMetaData, and frame rate and data rate from the onMetaData or else
+// measured from the frames. Unknown properties are left out.
+func (in *mergeInput) trackInfo(trackType string) []AMF0Property {
+	t := &in.video
+	if trackType == "audio" {
+		t = &in.audio
+	}
+	format := probeTrackFormat(trackType, t.fourCC, t.config, t.first)
+	// Measured over the DTS span plus one average frame interval, as in the
+	// track table of eflv info.
+	var frameRate, dataRate float64
+	if t.frames > 1 && t.lastDTS > t.firstDTS {
+		span := float64(t.lastDTS - t.firstDTS)
+		frameRate = roundRate(float64(t.frames-1)*1000/span, 3)
+		dataRate = roundRate(float64(t.bytes)*8/(span+span/float64(t.frames-1)), 1)
+	}
+
+	var info []AMF0Property
+	add := func(name string, values ...float64) {
+		for _, v := range values {
+			if v > 0 {
+				info = append(info, AMF0Property{Name: name, Value: v})
+				return
+			}
+		}
+	}
+	meta := func(names ...string) float64 {
+		for _, name := range names {
+			if v, ok := amf0Lookup(in.metadata, name); ok {
+				if n, ok := v.(float64); ok {
+					return n
+				}
+			}
+		}
+		return 0
+	}
+
+	if trackType == "video" {
+		add("width", float64(format.width), meta("width"))
+		add("height", float64(format.height), meta("height"))
+		add("framerate", meta("framerate"), frameRate)
+		add("videodatarate", meta("videodatarate"), dataRate)
+		return append(info, AMF0Property{Name: "videocodecid", Value: fourCCValue(t.fourCC)})
+	}
+
+	channels := float64(format.channels)
+	if channels == 0 {
+		channels = meta("audiochannels", "channels")
+	}
+	if v, ok := amf0Lookup(in.metadata, "stereo"); ok && channels == 0 {
+		if stereo, _ := v.(bool); stereo {
+			channels = 2
+		} else {
+			channels = 1
+		}
+	}
+	add("audiodatarate", meta("audiodatarate"), dataRate)
+	add("samplerate", float64(format.sampleRate), meta("audiosamplerate", "samplerate"))
+	add("audiosamplesize", float64(format.bitsPerSample), meta("audiosamplesize"))
+	add("channels", channels)
+	if channels > 0 {
+		info = append(info, AMF0Property{Name: "stereo", Value: channels > 1})
+	}
+	return append(info, AMF0Property{Name: "audiocodecid", Value: fourCCValue(t.fourCC)})
+}
+
+// metadataSubset returns the properties of props whose names are in keys.
+func metadataSubset(props []AMF0Property, keys []string) []AMF0Property {
+	var subset []AMF0Property
+	for _, p := range props {
+		for _, k := range keys {
+			if p.Name == k {
+				subset = append(subset, p)
+				break
+			}
+		}
+	}
+	return subset
+}
+
+// roundRate rounds a measured frame or data rate to the given number of
+// decimal places before it is written to onMetaData.
+func roundRate(v float64, places int) float64 {
+	scale := math.Pow10(places)
+	return math.Round(v*scale) / scale
+}
+
+// fourCCValue returns the numeric onMetaData codec ID for a FourCC.
+func fourCCValue(fourCC string) float64 {
+	return float64(binary.BigEndian.Uint32([]byte(fourCC)))
+}
+
+// isMetadataTag reports whether tag is an onMetaData script tag, in AMF0 or
+// AMF3 (type 15) form, possibly wrapped in @setDataFrame.
+func isMetadataTag(tag *Tag) bool {
+	if tag.Type != TagTypeScript && tag.Type != TagTypeScriptAMF3 {
+		return false
+	}
+	payload, ok := scriptPayload(tag)
+	if !ok {
+		return false
+	}
+	sd, _ := parseScriptData(payload)
+	return sd.method == "onMetaData"
+}
+
+// multitrackTag rewrites src.next as an E-RTMP multitrack tag carrying
+// src.trackID. If other has a tag of the same media type, timestamp, packet
+// type and frame type pending, both are packed into one tag and combined is
+// true. Packets that do not belong to a track (video command frames, audio
+// silence) are returned unchanged.
+func multitrackTag(src, other *mergeInput) (tag *Tag, combined bool, err error) {
+	pkt, ok, err := enhancedTrackPacket(src.next.Type, src.next.Data)
+	if err != nil || !ok {
+		return src.next, false, err
+	}
+
+	pkts := []trackPacket{pkt}
+	trackIDs := []byte{src.trackID}
+	if o := other.next; o != nil && o.Type == src.next.Type && o.Timestamp == src.next.Timestamp {
+		opkt, ok, err := enhancedTrackPacket(o.Type, o.Data)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", other.path, err)
+		}
+		if ok && opkt.packetType == pkt.packetType && opkt.frameType == pkt.frameType &&
+			equalModEx(opkt.modEx, pkt.modEx) {
+			pkts = append(pkts, opkt)
+			trackIDs = append(trackIDs, other.trackID)
+			combined = true
+			if other.trackID < src.trackID {
+				pkts[0], pkts[1] = pkts[1], pkts[0]
+				trackIDs[0], trackIDs[1] = trackIDs[1], trackIDs[0]
+			}
+		}
+	}
+
+	data, err := buildMultitrackPayload(src.next.Type, pkts, trackIDs)
+	if err != nil {
+		return nil, false, err
+	}
+	return &Tag{
+		Type:      src.next.Type,
+		Timestamp: src.next.Timestamp,
+		StreamID:  src.next.StreamID,
+		Data:      data,
+	}, combined, nil
+}
+
+// enhancedTrackPacket converts a single-track audio or video tag payload into
+// its enhanced form. Legacy AVC, AAC and MP3 payloads are mapped to their
+// E-RTMP FourCC equivalents. ok is false for payloads that do not belong to a
+// track (video command frames, audio silence messages).
+func enhancedTrackPacket(tagType TagType, data []byte) (pkt trackPacket, ok bool, err error) {
+	if len(data) < 1 {
+		return trackPacket{}, false, nil
+	}
+
+	switch tagType {
+	case TagTypeVideo:
+		vp, err := ParseVideoPacket(data)
+		if err != nil {
+			return trackPacket{}, false, err
+		}
+		if vp.HasCommand {
+			return trackPacket{}, false, nil
+		}
+		if vp.Multitrack {
+			return trackPacket{}, false, fmt.Errorf("input is already multitrack")
+		}
+		track := vp.Tracks[0]
+		if track.FourCC == "" {
+			return trackPacket{}, false, fmt.Errorf("legacy video codec %d has no E-RTMP FourCC", vp.CodecID)
+		}
+		body := track.Data
+		if vp.PacketType == VideoPacketTypeCodedFrames && track.HasCTO {
+			// CodedFrames keeps the SI24 composition time offset.
+			cto := track.CompositionTimeOffset
+			body = append([]byte{byte(cto >> 16), byte(cto >> 8), byte(cto)}, track.Data...)
+		}
+		frameType := byte(vp.FrameType) & 0x07
+		if vp.PacketType == VideoPacketTypeMetadata {
+			// FrameType is ignored for Metadata, but the Command value
+			// commonly used for it would turn the Multitrack packet into a
+			// command frame.
+			frameType = 0
+		}
+		return trackPacket{
+			frameType:  frameType,
+			packetType: byte(vp.PacketType),
+			fourCC:     track.FourCC,
+			modEx:      vp.ModEx,
+			body:       body,
+		}, true, nil
+
+	case TagTypeAudio:
+		ap, err := ParseAudioPacket(data)
+		if err != nil {
+			return trackPacket{}, false, err
+		}
+		if ap.Multitrack {
+			return trackPacket{}, false, fmt.Errorf("input is already multitrack")
+		}
+		track := ap.Tracks[0]
+		if track.FourCC == "" {
+			return trackPacket{}, false, fmt.Errorf("legacy sound format %d has no E-RTMP FourCC", ap.SoundFormat)
+		}
+		return trackPacket{
+			packetType: byte(ap.PacketType),
+			fourCC:     track.FourCC,
+			modEx:      ap.ModEx,
+			body:       track.Data,
+		}, true, nil
+	}
+	return trackPacket{}, false, nil
+}
+
+// buildMultitrackPayload encodes pkts as a VideoPacketType.Multitrack or
+// AudioPacketType.Multitrack payload. A single packet uses OneTrack; several
+// packets use ManyTracks when they share a FourCC and ManyTracksManyCodecs
+// otherwise. All packets must share packet type, frame type and ModEx
+// modifiers.
+func buildMultitrackPayload(tagType TagType, pkts []trackPacket, trackIDs []byte) ([]byte, error) {
+	multitrackType := byte(avMultitrackOneTrack)
+	if len(pkts) > 1 {
+		multitrackType = avMultitrackManyTracks
+		for _, p := range pkts[1:] {
+			if p.fourCC != pkts[0].fourCC {
+				multitrackType = avMultitrackManyTracksManyCodecs
+				break
+			}
+		}
+	}
+
+	var buf []byte
+	multitrackPacketType := byte(audioPacketTypeMultitrack)
+	if tagType == TagTypeVideo {
+		multitrackPacketType = videoPacketTypeMultitrack
+	}
+	// ModEx packets wrap the Multitrack packet type.
+	packetType := multitrackPacketType
+	if len(pkts[0].modEx) > 0 {
+		packetType = videoPacketTypeModEx
+	}
+	if tagType == TagTypeVideo {
+		buf = append(buf, 0x80|pkts[0].frameType<<4|packetType)
+	} else {
+		buf = append(buf, soundFormatExAudio<<4|packetType)
+	}
+	if len(pkts[0].modEx) > 0 {
+		var err error
+		if buf, err = appendModEx(buf, pkts[0].modEx, multitrackPacketType); err != nil {
+			return nil, err
+		}
+	}
+	buf = append(buf, multitrackType<<4|pkts[0].packetType)
+	if multitrackType != avMultitrackManyTracksManyCodecs {
+		buf = append(buf, pkts[0].fourCC...)
+	}
+	for i, p := range pkts {
+		if multitrackType == avMultitrackManyTracksManyCodecs {
+			buf = append(buf, p.fourCC...)
+		}
+		buf = append(buf, trackIDs[i])
+		if multitrackType != avMultitrackOneTrack {
+			if len(p.body) > 0xFFFFFF {
+				return nil, fmt.Errorf("track payload too large: %d bytes", len(p.body))
+			}
+			buf = append(buf, byte(len(p.body)>>16), byte(len(p.body)>>8), byte(len(p.body)))
+		}
+		buf = append(buf, p.body...)
+	}
+	return buf, nil
+}
@@ -0,0 +1,104 @@
+package flv
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ModExType values (VideoPacketModExType / AudioPacketModExType).
+const (
+	ModExTypeTimestampOffsetNano = 0
+)
+
+// ModEx is one packet modifier extension read from an audio or video tag
+// with packet type ModEx (7).
+type ModEx struct {
+	Type byte   // ModExType
+	Data []byte // modExData, aliases the tag payload
+}
+
+// modExInfo is the result of reading the ModEx chain of a tag.
+type modExInfo struct {
+	entries    []ModEx
+	hasNano    bool
+	nanoOffset uint32
+}
+
+// readModEx reads the chain of ModEx packets starting at data[pos], which
+// follows a header whose packet type was ModEx:
+//
+//	[modExDataSize-1 (UI8)] ([modExDataSize-1 (UI16)] if the UI8 was 255)
+//	[modExData] [ModExType(4)|nextPacketType(4)]
+//
+// and repeats while nextPacketType is ModEx. It returns the first packet type
+// that is not ModEx and the position after the chain. ModEx types this
+// package does not know are kept in entries and otherwise skipped.
+func readModEx(data []byte, pos int) (info modExInfo, packetType byte, next int, err error) {
+	packetType = videoPacketTypeModEx
+	for packetType == videoPacketTypeModEx {
+		if len(data) < pos+1 {
+			return info, 0, 0, fmt.Errorf("truncated ModEx data size")
+		}
+		size := int(data[pos]) + 1
+		pos++
+		if size == 256 {
+			if len(data) < pos+2 {
+				return info, 0, 0, fmt.Errorf("truncated ModEx data size")
+			}
+			size = (int(data[pos])<<8 | int(data[pos+1])) + 1
+			pos += 2
+		}
+		if len(data) < pos+size+1 {
+			return info, 0, 0, fmt.Errorf("truncated ModEx data")
+		}
+		modEx := ModEx{Type: data[pos+size] >> 4, Data: data[pos : pos+size]}
+		packetType = data[pos+size] & 0x0F
+		pos += size + 1
+
+		if modEx.Type == ModExTypeTimestampOffsetNano {
+			if len(modEx.Data) < 3 {
+				return info, 0, 0, fmt.Errorf("TimestampOffsetNano needs 3 bytes, got %d", len(modEx.Data))
+			}
+			info.hasNano = true
+			info.nanoOffset = uint32(modEx.Data[0])<<16 | uint32(modEx.Data[1])<<8 | uint32(modEx.Data[2])
+		}
+		info.entries = append(info.entries, modEx)
+	}
+	return info, packetType, pos, nil
+}
+
+// appendModEx encodes entries as a ModEx chain whose last nextPacketType is
+// packetType. The caller writes the header with packet type ModEx first.
+func appendModEx(buf []byte, entries []ModEx, packetType byte) ([]byte, error) {
+	for i, m := range entries {
+		size := len(m.Data)
+		switch {
+		case size < 1 || size > 0x10000:
+			return nil, fmt.Errorf("invalid ModEx data size %d", size)
+		case size < 256:
+			buf = append(buf, byte(size-1))
+		default:
+			buf = append(buf, 255, byte((size-1)>>8), byte(size-1))
+		}
+		buf = append(buf, m.Data...)
+		next := packetType
+		if i < len(entries)-1 {
+			next = videoPacketTypeModEx
+		}
+		buf = append(buf, m.Type<<4|next)
+	}
+	return buf, nil
+}
+
+// equalModEx reports whether two ModEx chains are identical.
+func equalModEx(a, b []ModEx) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || !bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
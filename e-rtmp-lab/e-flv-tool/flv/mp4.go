@@ -0,0 +1,525 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ISO BMFF (ISO/IEC 14496-12) box writing shared by the progressive and
+// fragmented MP4 outputs.
+
+// mp4MovieTimescale is the timescale of mvhd, tkhd and the edit lists.
+const mp4MovieTimescale = 1000
+
+// mp4VideoTimescale is the media timescale of video tracks. It represents
+// millisecond timestamps exactly and keeps TimestampOffsetNano to about
+// 11 µs. Audio tracks use their sample rate.
+const mp4VideoTimescale = 90000
+
+// mp4Box returns a box of the given type whose payload is the concatenation
+// of payload.
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, boxType...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// mp4FullBox returns a FullBox: a box whose payload starts with a version
+// byte and 24 bits of flags.
+func mp4FullBox(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, payload...)...)
+}
+
+// mp4Track is a track of MP4 output: an input track with its track_ID and
+// the sample timing in its media timescale.
+type mp4Track struct {
+	*remuxTrack
+	id        uint32
+	timescale int64
+	dts       []int64
+	durations []int64
+	duration  int64 // sum of durations
+	adjusted  int   // samples whose DTS had to be moved forward
+
+	// Presentation of the first sample in the output, see editList.
+	mediaTime int64 // media time of the first presented sample, from the first DTS
+	delay     int64 // ns from time zero of the output to the track start
+
+	// The samples are described by movie fragments, not by the sample
+	// table, and the media timeline is the FLV timeline.
+	fragmented bool
+}
+
+// newMP4Track computes the sample timing of an input track. origin is time
+// zero of the output in nanoseconds (see remuxOrigin).
+func newMP4Track(t *remuxTrack, id uint32, origin int64) *mp4Track {
+	mt := &mp4Track{remuxTrack: t, id: id, timescale: mp4VideoTimescale}
+	if t.trackType == "audio" {
+		mt.timescale = mp4MovieTimescale
+		if t.sampleRate > 0 {
+			mt.timescale = int64(t.sampleRate)
+		}
+	}
+	mt.dts, mt.adjusted = t.decodeTimes(mt.timescale)
+	mt.durations = t.sampleDurations(mt.dts, mt.timescale)
+	for _, d := range mt.durations {
+		mt.duration += d
+	}
+
+	// The media timeline starts at the first DTS. The earliest composition
+	// time is mapped to the track start by the edit list.
+	first := mt.dts[0]
+	mt.mediaTime = -1
+	for i := range t.samples {
+		ct := mt.dts[i] - first + scaleNanos(int64(t.samples[i].cto)*1e6, mt.timescale)
+		if mt.mediaTime < 0 || ct < mt.mediaTime {
+			mt.mediaTime = ct
+		}
+	}
+	mt.mediaTime = max(mt.mediaTime, 0)
+	if t.fourCC == "Opus" {
+		// Skip the decoder delay, in 48 kHz samples.
+		mt.mediaTime += int64(opusPreSkip(t.config))
+	}
+	mt.delay = t.startPTS() - origin
+	return mt
+}
+
+// cto returns the composition time offset of sample i in the media timescale.
+func (mt *mp4Track) cto(i int) int64 {
+	return scaleNanos(int64(mt.samples[i].cto)*1e6, mt.timescale)
+}
+
+// hasCTO reports whether any sample has a composition time offset, and
+// whether any of them is negative.
+func (mt *mp4Track) hasCTO() (found, negative bool) {
+	for i := range mt.samples {
+		if c := mt.samples[i].cto; c != 0 {
+			found = true
+			negative = negative || c < 0
+		}
+	}
+	return found, negative
+}
+
+// bitrates returns the average bitrate and the highest bitrate over any
+// one-second interval, in bit/s.
+func (mt *mp4Track) bitrates() (avg, peak uint32) {
+	if mt.duration > 0 {
+		avg = uint32(mt.bytes * 8 * mt.timescale / mt.duration)
+	}
+	var second, bytes int64
+	for i := range mt.samples {
+		if s := mt.dts[i] / mt.timescale; s != second {
+			second, bytes = s, 0
+		}
+		bytes += int64(mt.samples[i].size)
+		peak = max(peak, uint32(bytes*8))
+	}
+	return avg, max(avg, peak)
+}
+
+// movieDuration returns the duration of the track in the movie timescale,
+// including the delay before its start.
+func (mt *mp4Track) movieDuration() int64 {
+	return scaleNanos(mt.delay, mp4MovieTimescale) + (mt.duration-mt.mediaTime)*mp4MovieTimescale/mt.timescale
+}
+
+// mp4FileType returns the ftyp box.
+func mp4FileType(major string, minor uint32, compatible ...string) []byte {
+	b := append([]byte(major), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[4:], minor)
+	for _, c := range compatible {
+		b = append(b, c...)
+	}
+	return mp4Box("ftyp", b)
+}
+
+// mp4CodecBrands returns the ftyp brands that signal the codecs of the
+// tracks.
+func mp4CodecBrands(tracks []*mp4Track) []string {
+	var brands []string
+	for _, mt := range tracks {
+		if (mt.fourCC == "avc1" || mt.fourCC == "av01") && !slices.Contains(brands, mt.fourCC) {
+			brands = append(brands, mt.fourCC)
+		}
+	}
+	return brands
+}
+
+// mp4MediaDataHeader returns the header of an mdat box with size bytes of
+// payload, using a 64-bit size when needed.
+func mp4MediaDataHeader(size int64) []byte {
+	if 8+size > math.MaxUint32 {
+		b := binary.BigEndian.AppendUint32(nil, 1)
+		b = append(b, "mdat"...)
+		return binary.BigEndian.AppendUint64(b, uint64(16+size))
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+size))
+	return append(b, "mdat"...)
+}
+
+// mp4MovieHeader returns the mvhd box.
+func mp4MovieHeader(duration int64, nextTrackID uint32) []byte {
+	var b []byte
+	version := byte(0)
+	if duration > 0xFFFFFFFF {
+		version = 1
+		b = make([]byte, 16) // creation_time, modification_time
+		b = binary.BigEndian.AppendUint32(b, mp4MovieTimescale)
+		b = binary.BigEndian.AppendUint64(b, uint64(duration))
+	} else {
+		b = make([]byte, 8)
+		b = binary.BigEndian.AppendUint32(b, mp4MovieTimescale)
+		b = binary.BigEndian.AppendUint32(b, uint32(duration))
+	}
+	b = binary.BigEndian.AppendUint32(b, 0x00010000) // rate 1.0
+	b = binary.BigEndian.AppendUint16(b, 0x0100)     // volume 1.0
+	b = append(b, make([]byte, 10)...)               // reserved
+	b = append(b, mp4UnityMatrix...)
+	b = append(b, make([]byte, 24)...) // pre_defined
+	b = binary.BigEndian.AppendUint32(b, nextTrackID)
+	return mp4FullBox("mvhd", version, 0, b)
+}
+
+var mp4UnityMatrix = []byte{
+	0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0x00, 0x01, 0x00, 0x00, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0x00, 0x00, 0x00,
+}
+
+// trak returns the trak box with the given sample table. Edit lists are
+// written when the track starts after time zero or its first presented
+// sample is not at media time 0.
+func (mt *mp4Track) trak(stbl []byte, duration int64) []byte {
+	children := [][]byte{mt.trackHeader(duration)}
+	if edts := mt.editList(); edts != nil {
+		children = append(children, edts)
+	}
+	children = append(children, mp4Box("mdia", mt.mediaHeader(), mt.handler(), mt.mediaInformation(stbl)))
+	return mp4Box("trak", children...)
+}
+
+func (mt *mp4Track) trackHeader(duration int64) []byte {
+	version := byte(0)
+	b := make([]byte, 8) // creation_time, modification_time
+	if duration > 0xFFFFFFFF {
+		version = 1
+		b = make([]byte, 16)
+	}
+	b = binary.BigEndian.AppendUint32(b, mt.id)
+	b = append(b, 0, 0, 0, 0) // reserved
+	if version == 1 {
+		b = binary.BigEndian.AppendUint64(b, uint64(duration))
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(duration))
+	}
+	b = append(b, make([]byte, 8)...) // reserved
+	b = append(b, 0, 0, 0, 0)         // layer, alternate_group
+	if mt.trackType == "audio" {
+		b = append(b, 0x01, 0x00, 0, 0) // volume 1.0, reserved
+	} else {
+		b = append(b, 0, 0, 0, 0)
+	}
+	b = append(b, mp4UnityMatrix...)
+	b = binary.BigEndian.AppendUint32(b, uint32(mt.width)<<16)
+	b = binary.BigEndian.AppendUint32(b, uint32(mt.height)<<16)
+	return mp4FullBox("tkhd", version, 0x000003, b) // track_enabled, track_in_movie
+}
+
+// editList returns the edts box, or nil if the track needs no edits.
+// Fragmented tracks get a single edit that only skips to the first presented
+// sample, since the tfdt decode times already carry the start of the track;
+// its duration of 0 extends it over all fragments.
+func (mt *mp4Track) editList() []byte {
+	var entries [][2]int64 // segment_duration, media_time
+	if mt.fragmented {
+		if mt.mediaTime == 0 {
+			return nil
+		}
+		entries = append(entries, [2]int64{0, mt.mediaTime})
+	} else {
+		delay := scaleNanos(mt.delay, mp4MovieTimescale)
+		if delay <= 0 && mt.mediaTime == 0 {
+			return nil
+		}
+		if delay > 0 {
+			entries = append(entries, [2]int64{delay, -1}) // empty edit
+		}
+		entries = append(entries, [2]int64{(mt.duration - mt.mediaTime) * mp4MovieTimescale / mt.timescale, mt.mediaTime})
+	}
+
+	version := byte(0)
+	for _, e := range entries {
+		if e[0] > 0xFFFFFFFF || e[1] > 0x7FFFFFFF {
+			version = 1
+		}
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(entries)))
+	for _, e := range entries {
+		if version == 1 {
+			b = binary.BigEndian.AppendUint64(b, uint64(e[0]))
+			b = binary.BigEndian.AppendUint64(b, uint64(e[1]))
+		} else {
+			b = binary.BigEndian.AppendUint32(b, uint32(e[0]))
+			b = binary.BigEndian.AppendUint32(b, uint32(int32(e[1])))
+		}
+		b = binary.BigEndian.AppendUint32(b, 0x00010000) // media_rate 1.0
+	}
+	return mp4Box("edts", mp4FullBox("elst", version, 0, b))
+}
+
+func (mt *mp4Track) mediaHeader() []byte {
+	duration := mt.duration
+	if mt.fragmented {
+		duration = 0 // given by the fragments
+	}
+	version := byte(0)
+	b := make([]byte, 8) // creation_time, modification_time
+	if duration > 0xFFFFFFFF {
+		version = 1
+		b = make([]byte, 16)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(mt.timescale))
+	if version == 1 {
+		b = binary.BigEndian.AppendUint64(b, uint64(duration))
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(duration))
+	}
+	b = append(b, 0x55, 0xC4, 0, 0) // language "und", pre_defined
+	return mp4FullBox("mdhd", version, 0, b)
+}
+
+func (mt *mp4Track) handler() []byte {
+	handlerType, name := "vide", "VideoHandler"
+	if mt.trackType == "audio" {
+		handlerType, name = "soun", "SoundHandler"
+	}
+	b := append([]byte{0, 0, 0, 0}, handlerType...) // pre_defined
+	b = append(b, make([]byte, 12)...)              // reserved
+	b = append(b, name...)
+	b = append(b, 0)
+	return mp4FullBox("hdlr", 0, 0, b)
+}
+
+func (mt *mp4Track) mediaInformation(stbl []byte) []byte {
+	var header []byte
+	if mt.trackType == "audio" {
+		header = mp4FullBox("smhd", 0, 0, []byte{0, 0, 0, 0}) // balance, reserved
+	} else {
+		header = mp4FullBox("vmhd", 0, 1, make([]byte, 8)) // graphicsmode, opcolor
+	}
+	dref := mp4FullBox("dref", 0, 0, []byte{0, 0, 0, 1}, mp4FullBox("url ", 0, 1)) // media in the same file
+	return mp4Box("minf", header, mp4Box("dinf", dref), stbl)
+}
+
+// sampleDescription returns the stsd box with the sample entry of the
+// track's codec.
+func (mt *mp4Track) sampleDescription() ([]byte, error) {
+	entry, err := mt.sampleEntry()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", mt.name(), err)
+	}
+	return mp4FullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, entry), nil
+}
+
+// mp4Codecs are the FourCCs that can be carried in MP4 output.
+var mp4Codecs = map[string]bool{
+	"avc1": true, "hvc1": true, "av01": true, "vp09": true,
+	"mp4a": true, ".mp3": true, "Opus": true, "fLaC": true,
+}
+
+func (mt *mp4Track) sampleEntry() ([]byte, error) {
+	switch mt.fourCC {
+	case "avc1":
+		return mp4VisualSampleEntry("avc1", mt.width, mt.height, mp4Box("avcC", mt.config)), nil
+	case "hvc1":
+		return mp4VisualSampleEntry("hvc1", mt.width, mt.height, mp4Box("hvcC", mt.config)), nil
+	case "av01":
+		return mp4VisualSampleEntry("av01", mt.width, mt.height, mp4Box("av1C", mt.config)), nil
+	case "vp09":
+		// The E-RTMP record is the vpcC payload, FullBox header included.
+		if len(mt.config) < 12 {
+			return nil, fmt.Errorf("truncated VPCodecConfigurationRecord")
+		}
+		return mp4VisualSampleEntry("vp09", mt.width, mt.height, mp4Box("vpcC", mt.config)), nil
+	case "mp4a":
+		avg, peak := mt.bitrates()
+		return mp4AudioSampleEntry("mp4a", mt.channels, 16, mt.sampleRate, mp4ESDescriptor(0x40, mt.config, avg, peak)), nil
+	case ".mp3":
+		// MPEG-1/2 audio in an MPEG-4 audio sample entry (ISO/IEC 14496-14),
+		// which players support more widely than the '.mp3' entry.
+		avg, peak := mt.bitrates()
+		oti := byte(0x6B) // MPEG-1 audio
+		if mt.sampleRate < 32000 {
+			oti = 0x69 // MPEG-2 audio
+		}
+		return mp4AudioSampleEntry("mp4a", mt.channels, 16, mt.sampleRate, mp4ESDescriptor(oti, nil, avg, peak)), nil
+	case "Opus":
+		dOps, err := mp4OpusSpecificBox(mt.config)
+		if err != nil {
+			return nil, err
+		}
+		return mp4AudioSampleEntry("Opus", mt.channels, 16, 48000, dOps), nil
+	case "fLaC":
+		dfLa, err := mp4FLACSpecificBox(mt.config)
+		if err != nil {
+			return nil, err
+		}
+		return mp4AudioSampleEntry("fLaC", mt.channels, mt.bitsPerSample, mt.sampleRate, dfLa), nil
+	}
+	return nil, fmt.Errorf("codec not supported in MP4 output")
+}
+
+// mp4VisualSampleEntry returns a VisualSampleEntry with the given child
+// boxes (the codec configuration box).
+func mp4VisualSampleEntry(format string, width, height int, children ...[]byte) []byte {
+	b := make([]byte, 6)                    // reserved
+	b = binary.BigEndian.AppendUint16(b, 1) // data_reference_index
+	b = append(b, make([]byte, 16)...)      // pre_defined, reserved
+	b = binary.BigEndian.AppendUint16(b, uint16(width))
+	b = binary.BigEndian.AppendUint16(b, uint16(height))
+	b = binary.BigEndian.AppendUint32(b, 0x00480000) // horizresolution 72 dpi
+	b = binary.BigEndian.AppendUint32(b, 0x00480000) // vertresolution 72 dpi
+	b = append(b, 0, 0, 0, 0)                        // reserved
+	b = binary.BigEndian.AppendUint16(b, 1)          // frame_count
+	b = append(b, make([]byte, 32)...)               // compressorname
+	b = binary.BigEndian.AppendUint16(b, 0x0018)     // depth
+	b = binary.BigEndian.AppendUint16(b, 0xFFFF)     // pre_defined = -1
+	return mp4Box(format, append([][]byte{b}, children...)...)
+}
+
+// mp4AudioSampleEntry returns an AudioSampleEntry with the given child boxes.
+// Sample rates above 65535 Hz do not fit the 16.16 field and are written as
+// 0; the codec configuration carries the actual rate.
+func mp4AudioSampleEntry(format string, channels, sampleSize, sampleRate int, children ...[]byte) []byte {
+	if sampleRate > 0xFFFF {
+		sampleRate = 0
+	}
+	b := make([]byte, 6)                    // reserved
+	b = binary.BigEndian.AppendUint16(b, 1) // data_reference_index
+	b = append(b, make([]byte, 8)...)       // reserved
+	b = binary.BigEndian.AppendUint16(b, uint16(channels))
+	b = binary.BigEndian.AppendUint16(b, uint16(sampleSize))
+	b = append(b, 0, 0, 0, 0) // pre_defined, reserved
+	b = binary.BigEndian.AppendUint32(b, uint32(sampleRate)<<16)
+	return mp4Box(format, append([][]byte{b}, children...)...)
+}
+
+// mp4ESDescriptor returns the esds box of an MPEG-4 audio sample entry
+// (ISO/IEC 14496-1 ES_Descriptor). dsi is the DecoderSpecificInfo, the
+// AudioSpecificConfig for AAC; nil omits it.
+func mp4ESDescriptor(objectType byte, dsi []byte, avgBitrate, maxBitrate uint32) []byte {
+	descriptor := func(tag byte, payload ...[]byte) []byte {
+		size := 0
+		for _, p := range payload {
+			size += len(p)
+		}
+		// Size in the four-byte form of the expandable length.
+		b := []byte{tag, 0x80 | byte(size>>21), 0x80 | byte(size>>14), 0x80 | byte(size>>7), byte(size) & 0x7F}
+		for _, p := range payload {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	config := []byte{objectType, 0x15, 0, 0, 0} // streamType audio, upStream 0, reserved 1; bufferSizeDB
+	config = binary.BigEndian.AppendUint32(config, maxBitrate)
+	config = binary.BigEndian.AppendUint32(config, avgBitrate)
+	decoderConfig := [][]byte{config}
+	if dsi != nil {
+		decoderConfig = append(decoderConfig, descriptor(0x05, dsi))
+	}
+
+	es := descriptor(0x03,
+		[]byte{0, 0, 0}, // ES_ID 0, no dependencies, URL or OCR stream
+		descriptor(0x04, decoderConfig...),
+		descriptor(0x06, []byte{0x02}), // SLConfigDescriptor, predefined MP4
+	)
+	return mp4FullBox("esds", 0, 0, es)
+}
+
+// opusPreSkip returns the pre-skip of an OpusHead, or 0 if it is invalid.
+func opusPreSkip(head []byte) int {
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(head[10:12]))
+}
+
+// mp4OpusSpecificBox converts an OpusHead (RFC 7845, little-endian) into the
+// dOps box of the Opus sample entry (big-endian, without the magic).
+func mp4OpusSpecificBox(head []byte) ([]byte, error) {
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return nil, fmt.Errorf("invalid OpusHead")
+	}
+	channels := int(head[9])
+	b := []byte{0, head[9]}                                                       // Version, OutputChannelCount
+	b = binary.BigEndian.AppendUint16(b, binary.LittleEndian.Uint16(head[10:12])) // PreSkip
+	b = binary.BigEndian.AppendUint32(b, binary.LittleEndian.Uint32(head[12:16])) // InputSampleRate
+	b = binary.BigEndian.AppendUint16(b, binary.LittleEndian.Uint16(head[16:18])) // OutputGain
+	b = append(b, head[18])                                                       // ChannelMappingFamily
+	if head[18] != 0 {
+		// StreamCount, CoupledCount, ChannelMapping
+		if len(head) < 21+channels {
+			return nil, fmt.Errorf("truncated OpusHead channel mapping table")
+		}
+		b = append(b, head[19:21+channels]...)
+	}
+	return mp4Box("dOps", b), nil
+}
+
+// flacMetadataBlocks normalizes a FLAC codec configuration into a sequence
+// of metadata blocks, STREAMINFO first, with the last-block flag set on the
+// last one. The record may start with the "fLaC" marker, and may be a bare
+// STREAMINFO without its block header.
+func flacMetadataBlocks(config []byte) ([]byte, error) {
+	if len(config) >= 4 && string(config[:4]) == "fLaC" {
+		config = config[4:]
+	}
+	if len(config) == 34 {
+		return append([]byte{0x80, 0, 0, 34}, config...), nil
+	}
+	blocks := append([]byte(nil), config...)
+	last := -1
+	for pos := 0; pos < len(blocks); {
+		if pos+4 > len(blocks) {
+			return nil, fmt.Errorf("truncated FLAC metadata block header")
+		}
+		size := int(blocks[pos+1])<<16 | int(blocks[pos+2])<<8 | int(blocks[pos+3])
+		if pos == 0 && (blocks[0]&0x7F != 0 || size != 34) {
+			return nil, fmt.Errorf("FLAC configuration does not start with STREAMINFO")
+		}
+		if pos+4+size > len(blocks) {
+			return nil, fmt.Errorf("truncated FLAC metadata block")
+		}
+		blocks[pos] &= 0x7F
+		last = pos
+		pos += 4 + size
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("empty FLAC configuration")
+	}
+	blocks[last] |= 0x80
+	return blocks, nil
+}
+
+// mp4FLACSpecificBox returns the dfLa box of the FLAC sample entry.
+func mp4FLACSpecificBox(config []byte) ([]byte, error) {
+	blocks, err := flacMetadataBlocks(config)
+	if err != nil {
+		return nil, err
+	}
+	return mp4FullBox("dfLa", 0, 0, blocks), nil
+}
@@ -0,0 +1,986 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mp4InputSample is a sample of an MP4 track: where its data is in the file
+// and its timing in the media timescale.
+type mp4InputSample struct {
+	offset int64
+	size   int
+	dts    int64
+	cto    int64
+	key    bool
+	entry  int // index of the sample description
+}
+
+// mp4SampleEntry is a sample description of an MP4 track, mapped to E-RTMP.
+type mp4SampleEntry struct {
+	format string // sample entry type
+	fourCC string // E-RTMP FourCC, "" if the codec cannot be carried
+	config []byte // SequenceStart body, nil for codecs that have none
+	reason string // why fourCC is "", for messages
+
+	width, height int
+	sampleRate    int
+	channels      int
+	preSkip       int // Opus decoder delay, in 48 kHz samples
+}
+
+// mp4TrackDefaults are the sample defaults of the trex box of a track.
+type mp4TrackDefaults struct {
+	entry    uint32 // sample_description_index, from 1
+	duration uint32
+	size     uint32
+	flags    uint32
+}
+
+// mp4InputTrack is a track of an MP4 file to convert, with the samples of its
+// sample table followed by those of the movie fragments.
+type mp4InputTrack struct {
+	id        uint32
+	handler   string // handler_type of the hdlr box
+	trackType string // "audio", "video" or "" for other handlers
+	timescale int64
+	entries   []*mp4SampleEntry
+	samples   []mp4InputSample
+	delay     int64 // empty edit at the start of the edit list, in ns
+	mediaTime int64 // media time presented first, in the media timescale
+	defaults  mp4TrackDefaults
+	next      int64 // DTS after the last sample, for fragments without tfdt
+}
+
+// name returns how the track is referred to in messages.
+func (t *mp4InputTrack) name() string {
+	codec := ""
+	if len(t.entries) > 0 {
+		codec = t.entries[0].format
+	}
+	trackType := t.trackType
+	if trackType == "" {
+		trackType = fmt.Sprintf("'%s'", t.handler)
+	}
+	return fmt.Sprintf("%s track %d (%s)", trackType, t.id, orDash(codec))
+}
+
+// mp4Input is an MP4 or fragmented MP4 file read for conversion: the tracks
+// of its moov box and the samples of its moof boxes. The sample data stays
+// in the file.
+type mp4Input struct {
+	f         *os.File
+	size      int64 // file size, which bounds the sample data
+	timescale int64 // movie timescale
+	tracks    []*mp4InputTrack
+	fragments int
+	warnings  []string
+}
+
+// mp4RawBox is a box of a parsed box sequence.
+type mp4RawBox struct {
+	typ     string
+	payload []byte
+}
+
+// readMP4Input opens an MP4 file and reads its tracks. The moov box is read
+// into memory, then every moof box adds the samples of its track fragments.
+// The caller closes in.f.
+func readMP4Input(inputPath string) (*mp4Input, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening input: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading input: %w", err)
+	}
+
+	in := &mp4Input{f: f, size: info.Size()}
+	var moov []byte
+	var moofs [][2]int64 // offset and size
+	for pos := int64(0); pos < info.Size(); {
+		typ, header, size, err := readMP4BoxHeader(f, pos)
+		if err != nil {
+			f.Close()
+			if pos == 0 {
+				return nil, fmt.Errorf("not an MP4 file: %w", err)
+			}
+			return nil, err
+		}
+		if size == 0 {
+			size = info.Size() - pos // extends to the end of the file
+		}
+		if pos+size > info.Size() {
+			// A recording that was cut off: keep what is complete.
+			in.warnings = append(in.warnings, fmt.Sprintf("file is truncated in the '%s' box at %d", typ, pos))
+			if typ == "mdat" {
+				size = info.Size() - pos
+			} else {
+				break
+			}
+		}
+		switch typ {
+		case "moov":
+			if moov != nil {
+				f.Close()
+				return nil, fmt.Errorf("more than one moov box")
+			}
+			if moov, err = readMP4Payload(f, pos+header, size-header); err != nil {
+				f.Close()
+				return nil, err
+			}
+		case "moof":
+			moofs = append(moofs, [2]int64{pos, size})
+		}
+		pos += size
+	}
+	if moov == nil {
+		f.Close()
+		return nil, fmt.Errorf("no moov box")
+	}
+	if err := in.parseMovie(moov); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("moov: %w", err)
+	}
+
+	for _, m := range moofs {
+		data, err := readMP4Payload(f, m[0], m[1])
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := in.parseMovieFragment(data, m[0]); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("moof at %d: %w", m[0], err)
+		}
+	}
+	in.fragments = len(moofs)
+
+	for _, t := range in.tracks {
+		complete := t.samples[:0]
+		for _, s := range t.samples {
+			if s.offset >= 0 && s.offset+int64(s.size) <= info.Size() {
+				complete = append(complete, s)
+			} else {
+				t.next = min(t.next, s.dts)
+			}
+		}
+		if n := len(t.samples) - len(complete); n > 0 {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %d samples past the end of the file dropped", t.name(), n))
+			t.samples = complete
+		}
+	}
+	return in, nil
+}
+
+// readMP4BoxHeader reads the header of the top-level box at pos and returns
+// its type, header size and total size. A size of 0 means that the box
+// extends to the end of the file.
+func readMP4BoxHeader(f *os.File, pos int64) (typ string, header, size int64, err error) {
+	var b [16]byte
+	if _, err := f.ReadAt(b[:8], pos); err != nil {
+		return "", 0, 0, fmt.Errorf("truncated box header at %d", pos)
+	}
+	typ, header, size = string(b[4:8]), 8, int64(binary.BigEndian.Uint32(b[:4]))
+	if size == 1 {
+		if _, err := f.ReadAt(b[8:16], pos+8); err != nil {
+			return "", 0, 0, fmt.Errorf("truncated box header at %d", pos)
+		}
+		header, size = 16, int64(binary.BigEndian.Uint64(b[8:16]))
+	}
+	if size != 0 && size < header {
+		return "", 0, 0, fmt.Errorf("invalid size of %q box at %d", typ, pos)
+	}
+	return typ, header, size, nil
+}
+
+// readMP4Payload reads size bytes of the file at pos.
+func readMP4Payload(f *os.File, pos, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, pos); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading input: %w", err)
+	}
+	return data, nil
+}
+
+// parseMP4Boxes splits data into the boxes it contains.
+func parseMP4Boxes(data []byte) ([]mp4RawBox, error) {
+	var boxes []mp4RawBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box header")
+		}
+		typ, header, size := string(data[4:8]), 8, uint64(binary.BigEndian.Uint32(data[:4]))
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated box header")
+			}
+			header, size = 16, binary.BigEndian.Uint64(data[8:16])
+		}
+		if size < uint64(header) || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size of '%s' box", typ)
+		}
+		boxes = append(boxes, mp4RawBox{typ: typ, payload: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// mp4Child returns the payload of the first box found by following path
+// from the boxes in data.
+func mp4Child(data []byte, path ...string) ([]byte, bool) {
+	for _, typ := range path {
+		boxes, err := parseMP4Boxes(data)
+		if err != nil {
+			return nil, false
+		}
+		found := false
+		for _, b := range boxes {
+			if b.typ == typ {
+				data, found = b.payload, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// mp4FieldReader reads big-endian fields from a box payload. Reading past the
+// end yields zeros and sets truncated.
+type mp4FieldReader struct {
+	data      []byte
+	pos       int
+	truncated bool
+}
+
+func (r *mp4FieldReader) bytes(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		r.truncated = true
+		r.pos = len(r.data)
+		return make([]byte, max(n, 0))
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *mp4FieldReader) u8() uint8   { return r.bytes(1)[0] }
+func (r *mp4FieldReader) u16() uint16 { return binary.BigEndian.Uint16(r.bytes(2)) }
+func (r *mp4FieldReader) u32() uint32 { return binary.BigEndian.Uint32(r.bytes(4)) }
+func (r *mp4FieldReader) u64() uint64 { return binary.BigEndian.Uint64(r.bytes(8)) }
+
+// fullBox reads the version and flags of a FullBox.
+func (r *mp4FieldReader) fullBox() (version byte, flags uint32) {
+	v := r.u32()
+	return byte(v >> 24), v & 0xFFFFFF
+}
+
+// uintV reads a field that is 64 bits in version 1 of the box and 32 bits
+// otherwise.
+func (r *mp4FieldReader) uintV(version byte) uint64 {
+	if version == 1 {
+		return r.u64()
+	}
+	return uint64(r.u32())
+}
+
+// parseMovie reads the movie header, the trex defaults and the tracks.
+func (in *mp4Input) parseMovie(moov []byte) error {
+	mvhd, ok := mp4Child(moov, "mvhd")
+	if !ok {
+		return fmt.Errorf("no mvhd box")
+	}
+	r := &mp4FieldReader{data: mvhd}
+	version, _ := r.fullBox()
+	r.uintV(version) // creation_time
+	r.uintV(version) // modification_time
+	in.timescale = int64(r.u32())
+	if r.truncated || in.timescale == 0 {
+		return fmt.Errorf("invalid mvhd box")
+	}
+
+	defaults := map[uint32]mp4TrackDefaults{}
+	if mvex, ok := mp4Child(moov, "mvex"); ok {
+		boxes, err := parseMP4Boxes(mvex)
+		if err != nil {
+			return fmt.Errorf("mvex: %w", err)
+		}
+		for _, b := range boxes {
+			if b.typ != "trex" {
+				continue
+			}
+			r := &mp4FieldReader{data: b.payload}
+			r.fullBox()
+			id := r.u32()
+			defaults[id] = mp4TrackDefaults{entry: r.u32(), duration: r.u32(), size: r.u32(), flags: r.u32()}
+			if r.truncated {
+				return fmt.Errorf("truncated trex box")
+			}
+		}
+	}
+
+	boxes, err := parseMP4Boxes(moov)
+	if err != nil {
+		return err
+	}
+	for _, b := range boxes {
+		if b.typ != "trak" {
+			continue
+		}
+		t, err := in.parseTrack(b.payload)
+		if err != nil {
+			return err
+		}
+		t.defaults = defaults[t.id]
+		in.tracks = append(in.tracks, t)
+	}
+	return nil
+}
+
+// parseTrack reads a trak box: its header, media header, handler, edit list,
+// sample descriptions and sample table.
+func (in *mp4Input) parseTrack(trak []byte) (*mp4InputTrack, error) {
+	tkhd, ok := mp4Child(trak, "tkhd")
+	if !ok {
+		return nil, fmt.Errorf("trak: no tkhd box")
+	}
+	r := &mp4FieldReader{data: tkhd}
+	version, _ := r.fullBox()
+	r.uintV(version) // creation_time
+	r.uintV(version) // modification_time
+	t := &mp4InputTrack{id: r.u32()}
+	if r.truncated {
+		return nil, fmt.Errorf("trak: truncated tkhd box")
+	}
+
+	mdhd, ok := mp4Child(trak, "mdia", "mdhd")
+	if !ok {
+		return nil, fmt.Errorf("track %d: no mdhd box", t.id)
+	}
+	r = &mp4FieldReader{data: mdhd}
+	version, _ = r.fullBox()
+	r.uintV(version)
+	r.uintV(version)
+	t.timescale = int64(r.u32())
+	if r.truncated || t.timescale == 0 {
+		return nil, fmt.Errorf("track %d: invalid mdhd box", t.id)
+	}
+
+	if hdlr, ok := mp4Child(trak, "mdia", "hdlr"); ok && len(hdlr) >= 12 {
+		t.handler = string(hdlr[8:12])
+	}
+	switch t.handler {
+	case "vide":
+		t.trackType = "video"
+	case "soun":
+		t.trackType = "audio"
+	default:
+		return t, nil
+	}
+
+	if elst, ok := mp4Child(trak, "edts", "elst"); ok {
+		if err := t.parseEditList(elst, in.timescale); err != nil {
+			return nil, fmt.Errorf("track %d: %w", t.id, err)
+		}
+	}
+
+	stbl, ok := mp4Child(trak, "mdia", "minf", "stbl")
+	if !ok {
+		return nil, fmt.Errorf("track %d: no stbl box", t.id)
+	}
+	stsd, ok := mp4Child(stbl, "stsd")
+	if !ok || len(stsd) < 8 {
+		return nil, fmt.Errorf("track %d: no stsd box", t.id)
+	}
+	entries, err := parseMP4Boxes(stsd[8:])
+	if err != nil {
+		return nil, fmt.Errorf("track %d: stsd: %w", t.id, err)
+	}
+	for _, e := range entries {
+		t.entries = append(t.entries, parseMP4SampleEntry(e.typ, e.payload, t.trackType))
+	}
+	if err := t.parseSampleTable(stbl, in.size); err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.id, err)
+	}
+	return t, nil
+}
+
+// parseEditList reads the empty edit the track may start with and the media
+// time of the first edit that presents media. Later edits are not applied.
+func (t *mp4InputTrack) parseEditList(elst []byte, movieTimescale int64) error {
+	r := &mp4FieldReader{data: elst}
+	version, _ := r.fullBox()
+	count := r.u32()
+	for i := uint32(0); i < count; i++ {
+		duration := int64(r.uintV(version))
+		var mediaTime int64
+		if version == 1 {
+			mediaTime = int64(r.u64())
+		} else {
+			mediaTime = int64(int32(r.u32()))
+		}
+		r.u32() // media_rate
+		if r.truncated {
+			return fmt.Errorf("truncated elst box")
+		}
+		if mediaTime == -1 {
+			t.delay += duration * 1e9 / movieTimescale
+			continue
+		}
+		t.mediaTime = mediaTime
+		break
+	}
+	return nil
+}
+
+// parseMP4SampleEntry maps a sample entry to its E-RTMP FourCC and builds the
+// SequenceStart body from its codec configuration box.
+func parseMP4SampleEntry(format string, payload []byte, trackType string) *mp4SampleEntry {
+	e := &mp4SampleEntry{format: format}
+	var children []byte
+	switch trackType {
+	case "video":
+		// SampleEntry (8 bytes), then the VisualSampleEntry fields.
+		if len(payload) < 78 {
+			e.reason = "truncated sample entry"
+			return e
+		}
+		e.width = int(binary.BigEndian.Uint16(payload[24:26]))
+		e.height = int(binary.BigEndian.Uint16(payload[26:28]))
+		children = payload[78:]
+	case "audio":
+		// SampleEntry (8 bytes), then the AudioSampleEntry fields, which the
+		// QuickTime sound description versions 1 and 2 extend.
+		if len(payload) < 28 {
+			e.reason = "truncated sample entry"
+			return e
+		}
+		e.channels = int(binary.BigEndian.Uint16(payload[16:18]))
+		e.sampleRate = int(binary.BigEndian.Uint32(payload[24:28]) >> 16)
+		children = payload[28:]
+		switch binary.BigEndian.Uint16(payload[8:10]) {
+		case 1:
+			children = payload[min(44, len(payload)):]
+		case 2:
+			children = payload[min(64, len(payload)):]
+		}
+	}
+
+	config := func(typ string) []byte {
+		b, ok := mp4Child(children, typ)
+		if !ok {
+			e.reason = fmt.Sprintf("no %s box", typ)
+			return nil
+		}
+		return b
+	}
+	switch format {
+	case "avc1", "avc3":
+		e.config, e.fourCC = config("avcC"), "avc1"
+	case "hvc1", "hev1":
+		e.config, e.fourCC = config("hvcC"), "hvc1"
+	case "av01":
+		e.config, e.fourCC = config("av1C"), "av01"
+	case "vp09":
+		// The E-RTMP record is the vpcC payload, FullBox header included.
+		e.config, e.fourCC = config("vpcC"), "vp09"
+	case "mp4a":
+		esds, ok := mp4Child(children, "esds")
+		if !ok {
+			esds, ok = mp4Child(children, "wave", "esds")
+		}
+		if !ok {
+			e.reason = "no esds box"
+			return e
+		}
+		oti, dsi, err := parseMP4ESDescriptor(esds)
+		if err != nil {
+			e.reason = err.Error()
+			return e
+		}
+		switch oti {
+		case 0x40, 0x66, 0x67, 0x68: // MPEG-4 AAC, MPEG-2 AAC profiles
+			if dsi == nil {
+				e.reason = "no AudioSpecificConfig"
+				return e
+			}
+			e.config, e.fourCC = dsi, "mp4a"
+		case 0x69, 0x6B: // MPEG-2, MPEG-1 audio
+			e.fourCC = ".mp3"
+		default:
+			e.reason = fmt.Sprintf("objectTypeIndication 0x%02X", oti)
+		}
+		return e
+	case ".mp3":
+		e.fourCC = ".mp3"
+		return e
+	case "Opus":
+		dOps := config("dOps")
+		if dOps == nil {
+			return e
+		}
+		head, err := opusHeadFromMP4(dOps)
+		if err != nil {
+			e.reason = err.Error()
+			return e
+		}
+		e.config, e.fourCC = head, "Opus"
+		e.sampleRate, e.preSkip = 48000, opusPreSkip(head)
+		return e
+	case "fLaC":
+		dfLa := config("dfLa")
+		if dfLa == nil {
+			return e
+		}
+		// The E-RTMP record is the "fLaC" signature followed by the
+		// metadata blocks of the dfLa box (after its version and flags).
+		if len(dfLa) < 4 {
+			e.reason = "truncated dfLa box"
+			return e
+		}
+		blocks, err := flacMetadataBlocks(dfLa[4:])
+		if err != nil {
+			e.reason = err.Error()
+			return e
+		}
+		e.config, e.fourCC = append([]byte("fLaC"), blocks...), "fLaC"
+		return e
+	case "ac-3", "ec-3":
+		// The frames carry everything the decoder needs.
+		e.fourCC = format
+		return e
+	default:
+		e.reason = "codec not supported in E-FLV output"
+		return e
+	}
+	if e.config == nil {
+		e.fourCC = ""
+	}
+	return e
+}
+
+// parseMP4ESDescriptor returns the objectTypeIndication and the
+// DecoderSpecificInfo (nil if absent) of an esds box.
+func parseMP4ESDescriptor(esds []byte) (oti byte, dsi []byte, err error) {
+	if len(esds) < 4 {
+		return 0, nil, fmt.Errorf("truncated esds box")
+	}
+	// descriptor returns the tag and payload of the descriptor at data[0].
+	descriptor := func(data []byte) (tag byte, payload, rest []byte, ok bool) {
+		if len(data) < 2 {
+			return 0, nil, nil, false
+		}
+		tag = data[0]
+		size, i := 0, 1
+		for ; i < len(data) && i <= 4; i++ {
+			size = size<<7 | int(data[i]&0x7F)
+			if data[i]&0x80 == 0 {
+				break
+			}
+		}
+		i++
+		if i > len(data) || i+size > len(data) {
+			return 0, nil, nil, false
+		}
+		return tag, data[i : i+size], data[i+size:], true
+	}
+
+	tag, es, _, ok := descriptor(esds[4:])
+	if !ok || tag != 0x03 || len(es) < 3 {
+		return 0, nil, fmt.Errorf("invalid ES_Descriptor")
+	}
+	flags, pos := es[2], 3
+	if flags&0x80 != 0 { // streamDependenceFlag
+		pos += 2
+	}
+	if flags&0x40 != 0 && pos < len(es) { // URL_Flag
+		pos += 1 + int(es[pos])
+	}
+	if flags&0x20 != 0 { // OCRstreamFlag
+		pos += 2
+	}
+	for rest := es[min(pos, len(es)):]; len(rest) > 0; {
+		var payload []byte
+		if tag, payload, rest, ok = descriptor(rest); !ok {
+			break
+		}
+		if tag != 0x04 || len(payload) < 13 {
+			continue
+		}
+		oti = payload[0]
+		if tag, payload, _, ok = descriptor(payload[13:]); ok && tag == 0x05 {
+			dsi = payload
+		}
+		return oti, dsi, nil
+	}
+	return 0, nil, fmt.Errorf("no DecoderConfigDescriptor")
+}
+
+// opusHeadFromMP4 converts the payload of a dOps box (big-endian, without
+// the magic) into an OpusHead (RFC 7845, little-endian).
+func opusHeadFromMP4(dOps []byte) ([]byte, error) {
+	if len(dOps) < 11 {
+		return nil, fmt.Errorf("truncated dOps box")
+	}
+	channels := int(dOps[1])
+	head := append([]byte("OpusHead"), 1, dOps[1])                                     // Version, OutputChannelCount
+	head = binary.LittleEndian.AppendUint16(head, binary.BigEndian.Uint16(dOps[2:4]))  // PreSkip
+	head = binary.LittleEndian.AppendUint32(head, binary.BigEndian.Uint32(dOps[4:8]))  // InputSampleRate
+	head = binary.LittleEndian.AppendUint16(head, binary.BigEndian.Uint16(dOps[8:10])) // OutputGain
+	head = append(head, dOps[10])                                                      // ChannelMappingFamily
+	if dOps[10] != 0 {
+		// StreamCount, CoupledCount, ChannelMapping
+		if len(dOps) < 13+channels {
+			return nil, fmt.Errorf("truncated dOps channel mapping table")
+		}
+		head = append(head, dOps[11:13+channels]...)
+	}
+	return head, nil
+}
+
+// parseSampleTable reads the samples of the sample table: their timing from
+// stts and ctts, sizes from stsz (or stz2), positions from stsc and stco (or
+// co64), and sync samples from stss. fileSize bounds the sample count.
+func (t *mp4InputTrack) parseSampleTable(stbl []byte, fileSize int64) error {
+	sizes, err := mp4SampleSizes(stbl, fileSize)
+	if err != nil {
+		return err
+	}
+	n := len(sizes)
+	if n == 0 {
+		return nil
+	}
+	t.samples = make([]mp4InputSample, n)
+
+	stts, ok := mp4Child(stbl, "stts")
+	if !ok {
+		return fmt.Errorf("no stts box")
+	}
+	r := &mp4FieldReader{data: stts}
+	r.fullBox()
+	i, dts := 0, int64(0)
+	for entries := r.u32(); entries > 0 && i < n; entries-- {
+		count, delta := r.u32(), r.u32()
+		if r.truncated {
+			return fmt.Errorf("truncated stts box")
+		}
+		for ; count > 0 && i < n; count-- {
+			t.samples[i].dts = dts
+			dts += int64(delta)
+			i++
+		}
+	}
+	if i < n {
+		return fmt.Errorf("stts box covers %d of %d samples", i, n)
+	}
+	t.next = dts
+
+	if ctts, ok := mp4Child(stbl, "ctts"); ok {
+		r := &mp4FieldReader{data: ctts}
+		r.fullBox()
+		i := 0
+		for entries := r.u32(); entries > 0 && i < n; entries-- {
+			// Version 0 offsets are unsigned, but negative values written
+			// as version 0 are common; both read the same as int32.
+			count, offset := r.u32(), int64(int32(r.u32()))
+			if r.truncated {
+				return fmt.Errorf("truncated ctts box")
+			}
+			for ; count > 0 && i < n; count-- {
+				t.samples[i].cto = offset
+				i++
+			}
+		}
+	}
+
+	stss, hasSyncTable := mp4Child(stbl, "stss")
+	for i := range t.samples {
+		t.samples[i].size = sizes[i]
+		t.samples[i].key = !hasSyncTable
+	}
+	if hasSyncTable {
+		r := &mp4FieldReader{data: stss}
+		r.fullBox()
+		for entries := r.u32(); entries > 0; entries-- {
+			if s := r.u32(); !r.truncated && s >= 1 && int(s) <= n {
+				t.samples[s-1].key = true
+			}
+		}
+	}
+
+	return t.sampleOffsets(stbl)
+}
+
+// mp4SampleSizes returns the size of every sample from the stsz or stz2 box.
+// Samples of a constant size must fit in fileSize.
+func mp4SampleSizes(stbl []byte, fileSize int64) ([]int, error) {
+	if stsz, ok := mp4Child(stbl, "stsz"); ok {
+		r := &mp4FieldReader{data: stsz}
+		r.fullBox()
+		size, count := r.u32(), r.u32()
+		if r.truncated || (size == 0 && uint64(len(stsz)) < 12+4*uint64(count)) {
+			return nil, fmt.Errorf("truncated stsz box")
+		}
+		if size != 0 && uint64(count)*uint64(size) > uint64(fileSize) {
+			return nil, fmt.Errorf("stsz box has %d samples of %d bytes, more than the file holds", count, size)
+		}
+		sizes := make([]int, count)
+		for i := range sizes {
+			sizes[i] = int(size)
+			if size == 0 {
+				sizes[i] = int(r.u32())
+			}
+		}
+		return sizes, nil
+	}
+	if stz2, ok := mp4Child(stbl, "stz2"); ok {
+		r := &mp4FieldReader{data: stz2}
+		r.fullBox()
+		fieldSize := r.u32() & 0xFF
+		count := r.u32()
+		if r.truncated || (fieldSize != 4 && fieldSize != 8 && fieldSize != 16) ||
+			uint64(len(stz2)) < 12+(uint64(count)*uint64(fieldSize)+7)/8 {
+			return nil, fmt.Errorf("invalid stz2 box")
+		}
+		sizes := make([]int, count)
+		for i := range sizes {
+			switch fieldSize {
+			case 4:
+				b := stz2[12+i/2]
+				if i%2 == 0 {
+					sizes[i] = int(b >> 4)
+				} else {
+					sizes[i] = int(b & 0x0F)
+				}
+			case 8:
+				sizes[i] = int(r.u8())
+			case 16:
+				sizes[i] = int(r.u16())
+			}
+		}
+		return sizes, nil
+	}
+	return nil, fmt.Errorf("no stsz box")
+}
+
+// sampleOffsets sets the file offset and sample description of every sample
+// from the chunks described by stsc and stco (or co64).
+func (t *mp4InputTrack) sampleOffsets(stbl []byte) error {
+	var chunks []int64
+	if stco, ok := mp4Child(stbl, "stco"); ok {
+		r := &mp4FieldReader{data: stco}
+		r.fullBox()
+		for count := r.u32(); count > 0 && !r.truncated; count-- {
+			chunks = append(chunks, int64(r.u32()))
+		}
+		if r.truncated {
+			return fmt.Errorf("truncated stco box")
+		}
+	} else if co64, ok := mp4Child(stbl, "co64"); ok {
+		r := &mp4FieldReader{data: co64}
+		r.fullBox()
+		for count := r.u32(); count > 0 && !r.truncated; count-- {
+			chunks = append(chunks, int64(r.u64()))
+		}
+		if r.truncated {
+			return fmt.Errorf("truncated co64 box")
+		}
+	} else {
+		return fmt.Errorf("no stco box")
+	}
+
+	stsc, ok := mp4Child(stbl, "stsc")
+	if !ok {
+		return fmt.Errorf("no stsc box")
+	}
+	r := &mp4FieldReader{data: stsc}
+	r.fullBox()
+	type run struct{ firstChunk, samples, entry uint32 }
+	count := r.u32()
+	if uint64(len(stsc)) < 8+12*uint64(count) {
+		return fmt.Errorf("truncated stsc box")
+	}
+	runs := make([]run, count)
+	for i := range runs {
+		runs[i] = run{r.u32(), r.u32(), r.u32()}
+	}
+
+	i := 0
+	for k, ru := range runs {
+		last := uint32(len(chunks))
+		if k+1 < len(runs) {
+			last = min(last, runs[k+1].firstChunk-1)
+		}
+		for c := ru.firstChunk; c >= 1 && c <= last && i < len(t.samples); c++ {
+			offset := chunks[c-1]
+			for s := uint32(0); s < ru.samples && i < len(t.samples); s++ {
+				t.samples[i].offset = offset
+				t.samples[i].entry = int(ru.entry) - 1
+				offset += int64(t.samples[i].size)
+				i++
+			}
+		}
+	}
+	if i < len(t.samples) {
+		return fmt.Errorf("chunks cover %d of %d samples", i, len(t.samples))
+	}
+	return nil
+}
+
+// Flags of the tfhd and trun boxes (ISO/IEC 14496-12 8.8.7, 8.8.8).
+const (
+	tfhdBaseDataOffset         = 0x000001
+	tfhdSampleDescriptionIndex = 0x000002
+	tfhdDefaultSampleDuration  = 0x000008
+	tfhdDefaultSampleSize      = 0x000010
+	tfhdDefaultSampleFlags     = 0x000020
+	tfhdDefaultBaseIsMoof      = 0x020000
+
+	trunDataOffset       = 0x000001
+	trunFirstSampleFlags = 0x000004
+	trunSampleDuration   = 0x000100
+	trunSampleSize       = 0x000200
+	trunSampleFlags      = 0x000400
+	trunSampleCTO        = 0x000800
+
+	mp4SampleIsNonSync = 0x00010000
+)
+
+// parseMovieFragment adds the samples of the track fragments of a moof box.
+// offset is the file offset of the moof box, which data starts with.
+func (in *mp4Input) parseMovieFragment(data []byte, offset int64) error {
+	moof, ok := mp4Child(data, "moof")
+	if !ok {
+		return fmt.Errorf("invalid moof box")
+	}
+	boxes, err := parseMP4Boxes(moof)
+	if err != nil {
+		return err
+	}
+	end := offset // end of the data of the previous track fragment
+	for _, b := range boxes {
+		if b.typ != "traf" {
+			continue
+		}
+		if end, err = in.parseTrackFragment(b.payload, offset, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTrackFragment adds the samples of a traf box to its track and returns
+// the end of their data. moofOffset is the file offset of the enclosing moof
+// and prevEnd the end of the data of the previous traf.
+func (in *mp4Input) parseTrackFragment(traf []byte, moofOffset, prevEnd int64) (int64, error) {
+	tfhd, ok := mp4Child(traf, "tfhd")
+	if !ok {
+		return 0, fmt.Errorf("traf: no tfhd box")
+	}
+	r := &mp4FieldReader{data: tfhd}
+	_, flags := r.fullBox()
+	id := r.u32()
+	var t *mp4InputTrack
+	for _, tr := range in.tracks {
+		if tr.id == id {
+			t = tr
+		}
+	}
+	if t == nil {
+		return 0, fmt.Errorf("traf: unknown track %d", id)
+	}
+	d := t.defaults
+	base := prevEnd
+	if flags&tfhdBaseDataOffset != 0 {
+		base = int64(r.u64())
+	} else if flags&tfhdDefaultBaseIsMoof != 0 {
+		base = moofOffset
+	}
+	if flags&tfhdSampleDescriptionIndex != 0 {
+		d.entry = r.u32()
+	}
+	if flags&tfhdDefaultSampleDuration != 0 {
+		d.duration = r.u32()
+	}
+	if flags&tfhdDefaultSampleSize != 0 {
+		d.size = r.u32()
+	}
+	if flags&tfhdDefaultSampleFlags != 0 {
+		d.flags = r.u32()
+	}
+	if r.truncated {
+		return 0, fmt.Errorf("track %d: truncated tfhd box", id)
+	}
+	if tfdt, ok := mp4Child(traf, "tfdt"); ok {
+		r := &mp4FieldReader{data: tfdt}
+		version, _ := r.fullBox()
+		t.next = int64(r.uintV(version))
+		if r.truncated {
+			return 0, fmt.Errorf("track %d: truncated tfdt box", id)
+		}
+	}
+
+	boxes, err := parseMP4Boxes(traf)
+	if err != nil {
+		return 0, fmt.Errorf("track %d: %w", id, err)
+	}
+	pos := base
+	for _, b := range boxes {
+		if b.typ != "trun" {
+			continue
+		}
+		r := &mp4FieldReader{data: b.payload}
+		_, flags := r.fullBox()
+		count := r.u32()
+		if flags&trunDataOffset != 0 {
+			pos = base + int64(int32(r.u32()))
+		}
+		firstFlags, hasFirstFlags := d.flags, flags&trunFirstSampleFlags != 0
+		if hasFirstFlags {
+			firstFlags = r.u32()
+		}
+		if flags&(trunSampleDuration|trunSampleSize|trunSampleFlags|trunSampleCTO) == 0 &&
+			uint64(count)*uint64(max(d.size, 1)) > uint64(in.size) {
+			// Without per-sample fields the box size does not bound count.
+			return 0, fmt.Errorf("track %d: trun box has %d samples of %d bytes, more than the file holds", id, count, d.size)
+		}
+		for i := uint32(0); i < count && !r.truncated; i++ {
+			s := mp4InputSample{offset: pos, dts: t.next, entry: int(d.entry) - 1}
+			duration, size, sampleFlags := d.duration, d.size, d.flags
+			if flags&trunSampleDuration != 0 {
+				duration = r.u32()
+			}
+			if flags&trunSampleSize != 0 {
+				size = r.u32()
+			}
+			if flags&trunSampleFlags != 0 {
+				sampleFlags = r.u32()
+			} else if i == 0 && hasFirstFlags {
+				sampleFlags = firstFlags
+			}
+			if flags&trunSampleCTO != 0 {
+				// Signed in version 1; see the ctts box.
+				s.cto = int64(int32(r.u32()))
+			}
+			s.size = int(size)
+			s.key = sampleFlags&mp4SampleIsNonSync == 0
+			t.samples = append(t.samples, s)
+			t.next += int64(duration)
+			pos += int64(size)
+		}
+		if r.truncated {
+			return 0, fmt.Errorf("track %d: truncated trun box", id)
+		}
+	}
+	return pos, nil
+}
@@ -0,0 +1,130 @@
+package flv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testMP4Movie returns a moov box with an audio track (track_ID 1) that has
+// the given sample table boxes, plus the extra moov children.
+func testMP4Movie(stbl [][]byte, extra ...[]byte) []byte {
+	stsd := mp4FullBox("stsd", 0, 0, u32(0))
+	trak := mp4Box("trak",
+		mp4FullBox("tkhd", 0, 3, u32(0), u32(0), u32(1)),
+		mp4Box("mdia",
+			mp4FullBox("mdhd", 0, 0, u32(0), u32(0), u32(48000)),
+			mp4FullBox("hdlr", 0, 0, u32(0), []byte("soun")),
+			mp4Box("minf", mp4Box("stbl", append([][]byte{stsd}, stbl...)...))))
+	return mp4Box("moov", append([][]byte{mp4FullBox("mvhd", 0, 0, u32(0), u32(0), u32(1000)), trak}, extra...)...)
+}
+
+// readTestMP4 writes the boxes to a file and reads it with readMP4Input.
+func readTestMP4(t *testing.T, boxes ...[]byte) (*mp4Input, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.mp4")
+	if err := os.WriteFile(path, bytes.Join(boxes, nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := readMP4Input(path)
+	if err == nil {
+		in.f.Close()
+	}
+	return in, err
+}
+
+func TestReadMP4InputSampleCountBounds(t *testing.T) {
+	ftyp := mp4FileType("isom", 0, "isom")
+	tests := []struct {
+		name  string
+		boxes [][]byte
+		want  string
+	}{
+		{"stsz constant size", [][]byte{ftyp, testMP4Movie([][]byte{
+			mp4FullBox("stts", 0, 0, u32(1), u32(0xFFFFFFFF), u32(1)),
+			mp4FullBox("stsz", 0, 0, u32(1), u32(0xFFFFFFFF)),
+		})}, "stsz box has 4294967295 samples"},
+		{"trun without per-sample fields", [][]byte{ftyp,
+			testMP4Movie([][]byte{mp4FullBox("stsz", 0, 0, u32(0), u32(0))},
+				mp4Box("mvex", mp4FullBox("trex", 0, 0, u32(1), u32(1), u32(1024), u32(1), u32(0)))),
+			mp4Box("moof", mp4Box("traf",
+				mp4FullBox("tfhd", 0, tfhdDefaultBaseIsMoof, u32(1)),
+				mp4FullBox("trun", 0, 0, u32(0xFFFFFFFF)))),
+		}, "trun box has 4294967295 samples"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readTestMP4(t, tt.boxes...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readMP4Input error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMP4InputCorrupt(t *testing.T) {
+	ftyp := mp4FileType("isom", 0, "isom")
+	// Two samples of 10 bytes in one chunk at the start of the mdat data.
+	movie := func(chunkOffset int) []byte {
+		return testMP4Movie([][]byte{
+			mp4FullBox("stts", 0, 0, u32(1), u32(2), u32(1024)),
+			mp4FullBox("stsz", 0, 0, u32(10), u32(2)),
+			mp4FullBox("stsc", 0, 0, u32(1), u32(1), u32(2), u32(1)),
+			mp4FullBox("stco", 0, 0, u32(1), u32(uint32(chunkOffset))),
+		})
+	}
+	moov := movie(len(ftyp) + len(movie(0)) + 8)
+	mdat := mp4Box("mdat", make([]byte, 20))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+		warning string
+	}{
+		{"short file", []byte{0, 0, 0}, "not an MP4 file", ""},
+		{"box smaller than its header", append(u32(4), "ftyp"...), "not an MP4 file", ""},
+		{"truncated box header", bytes.Join([][]byte{ftyp, moov, {0, 0, 0}}, nil), "truncated box header", ""},
+		{"no moov", bytes.Join([][]byte{ftyp, mdat}, nil), "no moov box", ""},
+		{"truncated moov", bytes.Join([][]byte{ftyp, moov[:len(moov)-4]}, nil), "no moov box", ""},
+		{"complete", bytes.Join([][]byte{ftyp, moov, mdat}, nil), "", ""},
+		{"truncated mdat", bytes.Join([][]byte{ftyp, moov, mdat[:len(mdat)-5]}, nil), "", "1 samples past the end of the file dropped"},
+		{"truncated stsz", bytes.Join([][]byte{ftyp, testMP4Movie([][]byte{
+			mp4FullBox("stsz", 0, 0, u32(0), u32(5), u32(10)),
+		})}, nil), "truncated stsz box", ""},
+		{"stts shorter than stsz", bytes.Join([][]byte{ftyp, testMP4Movie([][]byte{
+			mp4FullBox("stts", 0, 0, u32(1), u32(1), u32(1024)),
+			mp4FullBox("stsz", 0, 0, u32(10), u32(2)),
+		})}, nil), "stts box covers 1 of 2 samples", ""},
+		{"truncated trun", bytes.Join([][]byte{ftyp,
+			testMP4Movie([][]byte{mp4FullBox("stsz", 0, 0, u32(0), u32(0))}, mp4Box("mvex", mp4FullBox("trex", 0, 0, u32(1), u32(1), u32(0), u32(0), u32(0)))),
+			mp4Box("moof", mp4Box("traf",
+				mp4FullBox("tfhd", 0, tfhdDefaultBaseIsMoof, u32(1)),
+				mp4FullBox("trun", 0, trunSampleSize, u32(3), u32(10), u32(10)))),
+		}, nil), "truncated trun box", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := readTestMP4(t, tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readMP4Input error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMP4Input: %v", err)
+			}
+			if tt.warning == "" {
+				if len(in.warnings) > 0 || len(in.tracks[0].samples) != 2 {
+					t.Errorf("%d samples and warnings %q, want 2 samples and no warnings", len(in.tracks[0].samples), in.warnings)
+				}
+			} else if !slices.ContainsFunc(in.warnings, func(w string) bool { return strings.Contains(w, tt.warning) }) {
+				t.Errorf("warnings %q, want %q", in.warnings, tt.warning)
+			}
+		})
+	}
+}
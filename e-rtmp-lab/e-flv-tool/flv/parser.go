@@ -1,7 +1,7 @@
 package flv
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -12,9 +12,10 @@ import (
 type TagType byte
 
 const (
-	TagTypeAudio  TagType = 8
-	TagTypeVideo  TagType = 9
-	TagTypeScript TagType = 18
+	TagTypeAudio      TagType = 8
+	TagTypeVideo      TagType = 9
+	TagTypeScriptAMF3 TagType = 15 // format selector byte, then AMF0 with 0x11-prefixed AMF3 values
+	TagTypeScript     TagType = 18
 )
 
 // FLVHeader represents the 9-byte FLV file header.
@@ -26,9 +27,9 @@ type FLVHeader struct {
 	DataOffset uint32
 }
 
-func parseHeader(f *os.File) (FLVHeader, error) {
+func parseHeader(r io.Reader) (FLVHeader, error) {
 	var buf [9]byte
-	if _, err := f.Read(buf[:]); err != nil {
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
 		return FLVHeader{}, fmt.Errorf("reading header: %w", err)
 	}
 
@@ -46,137 +47,166 @@ func parseHeader(f *os.File) (FLVHeader, error) {
 	}, nil
 }
 
-// InfoFLV reads an FLV/E-FLV file and prints structural information.
+// fileInfo collects everything InfoFLV reports about a file.
+type fileInfo struct {
+	size           int64
+	header         FLVHeader
+	totalTags      uint64
+	audioTags      uint64
+	videoTags      uint64
+	scriptTags     uint64
+	otherTags      uint64
+	warnings       []string
+	metadataBlocks [][]AMF0Property
+	scripts        []*scriptData
+	metadataTracks []metadataTrack
+	tracks         []*trackStats
+	codecConfigs   []CodecConfig
+	vp9Resolutions []videoResolution
+	colorInfos     []colorInfoChange
+	tagList        []tagEntry // only collected in verbose mode
+}
+
+// InfoFLV reads an FLV/E-FLV file and prints structural information, either
+// as text or, when jsonOutput is set, as a JSON document (see infoReport).
+// When verbose is set the output also lists every tag (see tagEntry).
 func InfoFLV(inputPath string, jsonOutput bool, verbose bool) error {
-	f, err := os.Open(inputPath)
+	info, err := readFileInfo(inputPath, verbose)
 	if err != nil {
-		return fmt.Errorf("opening file: %w", err)
+		return err
 	}
-	defer f.Close()
+	if jsonOutput {
+		return printInfoJSON(inputPath, info)
+	}
+	printInfoText(inputPath, info)
+	return nil
+}
 
-	info, err := f.Stat()
+// readFileInfo parses the header and every tag of the file at inputPath.
+// Per-tag entries are only collected when verbose is set.
+func readFileInfo(inputPath string, verbose bool) (*fileInfo, error) {
+	f, err := os.Open(inputPath)
 	if err != nil {
-		return fmt.Errorf("stat file: %w", err)
+		return nil, fmt.Errorf("opening file: %w", err)
 	}
+	defer f.Close()
 
-	header, err := parseHeader(f)
+	stat, err := f.Stat()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("stat file: %w", err)
 	}
 
-	fmt.Printf("File: %s\n", inputPath)
-	fmt.Printf("Size: %d bytes\n", info.Size())
-	fmt.Printf("Format: FLV/E-FLV\n")
-	fmt.Println()
-	fmt.Printf("Header\n")
-	fmt.Printf("  Version:     %d\n", header.Version)
-	fmt.Printf("  Has Audio:   %t\n", header.HasAudio)
-	fmt.Printf("  Has Video:   %t\n", header.HasVideo)
-	fmt.Printf("  Data Offset: %d\n", header.DataOffset)
-
-	if _, err := f.Seek(int64(header.DataOffset), io.SeekStart); err != nil {
-		return fmt.Errorf("seek to data offset: %w", err)
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
 	}
+	info := &fileInfo{size: stat.Size(), header: r.Header()}
 
-	r := bufio.NewReaderSize(f, 1<<20)
-
-	var previousTagSize [4]byte
-	if _, err := io.ReadFull(r, previousTagSize[:]); err != nil {
-		if err == io.EOF {
-			fmt.Println()
-			fmt.Printf("Tags\n")
-			fmt.Printf("  Total:  0\n")
-			fmt.Printf("  Audio:  0\n")
-			fmt.Printf("  Video:  0\n")
-			fmt.Printf("  Script: 0\n")
-			fmt.Printf("  Other:  0\n")
-			return nil
-		}
-		return fmt.Errorf("reading first previous tag size: %w", err)
-	}
-
-	var totalTags uint64
-	var audioTags uint64
-	var videoTags uint64
-	var scriptTags uint64
-	var otherTags uint64
-	var metadataBlocks [][]amf0Property
-	var codecConfigs []codecConfig
-	var vp9Resolutions []videoResolution
-	var tagHeader [11]byte
 	for {
-		_, err := io.ReadFull(r, tagHeader[:])
+		tag, err := r.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				return fmt.Errorf("reading tag header: truncated file")
-			}
-			return fmt.Errorf("reading tag header: %w", err)
+			return nil, err
 		}
 
-		totalTags++
-		tagType := TagType(tagHeader[0] & 0x1f)
-		dataSize := int64(tagHeader[1])<<16 | int64(tagHeader[2])<<8 | int64(tagHeader[3])
+		info.totalTags++
+		tagType := tag.Type
+		payload := tag.Data
+		dataSize := len(payload)
+
+		if verbose {
+			entry := tagEntry{
+				index:     info.totalTags,
+				offset:    tag.Offset,
+				tagType:   tagType,
+				dataSize:  dataSize,
+				timestamp: tag.Timestamp,
+				streamID:  tag.StreamID,
+			}
+			describeTag(&entry, payload)
+			info.tagList = append(info.tagList, entry)
+		}
 
 		switch tagType {
 		case TagTypeVideo:
-			videoTags++
-			cfgs, res, err := parseVideoConfig(r, int(dataSize))
+			info.videoTags++
+			cfgs, res, err := parseVideoConfig(bytes.NewReader(payload), dataSize)
 			if err != nil {
-				return fmt.Errorf("reading video tag payload: %w", err)
+				return nil, fmt.Errorf("reading video tag payload: %w", err)
+			}
+			info.codecConfigs = append(info.codecConfigs, cfgs...)
+			if pkt, err := ParseVideoPacket(payload); err == nil {
+				info.collectVideoStats(tag.Timestamp, pkt)
+				if pkt.PacketType == VideoPacketTypeMetadata {
+					info.collectColorInfo(tag.Timestamp, pkt)
+				}
 			}
-			codecConfigs = append(codecConfigs, cfgs...)
 			if res != nil {
-				last := len(vp9Resolutions) - 1
-				if last < 0 || vp9Resolutions[last] != *res {
-					vp9Resolutions = append(vp9Resolutions, *res)
+				last := len(info.vp9Resolutions) - 1
+				if last < 0 || info.vp9Resolutions[last] != *res {
+					info.vp9Resolutions = append(info.vp9Resolutions, *res)
 				}
 			}
 		case TagTypeAudio:
-			audioTags++
-			cfgs, err := parseAudioConfig(r, int(dataSize))
+			info.audioTags++
+			cfgs, err := parseAudioConfig(bytes.NewReader(payload), dataSize)
 			if err != nil {
-				return fmt.Errorf("reading audio tag payload: %w", err)
+				return nil, fmt.Errorf("reading audio tag payload: %w", err)
 			}
-			codecConfigs = append(codecConfigs, cfgs...)
-		case TagTypeScript:
-			scriptTags++
-			props, err := parseScriptTag(r, int(dataSize))
-			if err != nil {
-				return fmt.Errorf("reading script tag payload: %w", err)
+			info.codecConfigs = append(info.codecConfigs, cfgs...)
+			if pkt, err := ParseAudioPacket(payload); err == nil {
+				info.collectAudioStats(tag.Timestamp, pkt)
 			}
-			metadataBlocks = append(metadataBlocks, props)
+		case TagTypeScript, TagTypeScriptAMF3:
+			info.scriptTags++
+			info.collectScriptData(tag)
 		default:
-			otherTags++
-			fmt.Printf("warning: unknown tag type %d at tag #%d, skipping\n", tagType, totalTags)
-			if _, err := io.CopyN(io.Discard, r, dataSize); err != nil {
-				if err == io.EOF || err == io.ErrUnexpectedEOF {
-					return fmt.Errorf("reading tag payload: truncated file")
-				}
-				return fmt.Errorf("reading tag payload: %w", err)
-			}
-		}
-		if _, err := io.ReadFull(r, previousTagSize[:]); err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return fmt.Errorf("reading previous tag size: truncated file")
-			}
-			return fmt.Errorf("reading previous tag size: %w", err)
+			info.otherTags++
+			info.warnings = append(info.warnings,
+				fmt.Sprintf("unknown tag type %d at tag #%d, skipping", tagType, info.totalTags))
 		}
 	}
 
+	info.collectMetadataTracks()
+	info.checkFrameRates()
+	return info, nil
+}
+
+func printInfoText(inputPath string, info *fileInfo) {
+	fmt.Printf("File: %s\n", inputPath)
+	fmt.Printf("Size: %d bytes\n", info.size)
+	fmt.Printf("Format: FLV/E-FLV\n")
+	fmt.Println()
+	fmt.Printf("Header\n")
+	fmt.Printf("  Version:     %d\n", info.header.Version)
+	fmt.Printf("  Has Audio:   %t\n", info.header.HasAudio)
+	fmt.Printf("  Has Video:   %t\n", info.header.HasVideo)
+	fmt.Printf("  Data Offset: %d\n", info.header.DataOffset)
+
+	for _, w := range info.warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+
 	fmt.Println()
 	fmt.Printf("Tags\n")
-	fmt.Printf("  Total:  %d\n", totalTags)
-	fmt.Printf("  Audio:  %d\n", audioTags)
-	fmt.Printf("  Video:  %d\n", videoTags)
-	fmt.Printf("  Script: %d\n", scriptTags)
-	fmt.Printf("  Other:  %d\n", otherTags)
+	fmt.Printf("  Total:  %d\n", info.totalTags)
+	fmt.Printf("  Audio:  %d\n", info.audioTags)
+	fmt.Printf("  Video:  %d\n", info.videoTags)
+	fmt.Printf("  Script: %d\n", info.scriptTags)
+	fmt.Printf("  Other:  %d\n", info.otherTags)
 
-	for i, props := range metadataBlocks {
+	if len(info.tracks) > 0 {
 		fmt.Println()
-		if len(metadataBlocks) == 1 {
+		printTrackStats(info.tracks)
+		fmt.Println()
+		printFrameTiming(info.tracks)
+	}
+
+	for i, props := range info.metadataBlocks {
+		fmt.Println()
+		if len(info.metadataBlocks) == 1 {
 			fmt.Printf("onMetaData\n")
 		} else {
 			fmt.Printf("onMetaData #%d\n", i+1)
@@ -186,14 +216,24 @@ func InfoFLV(inputPath string, jsonOutput bool, verbose bool) error {
 		}
 	}
 
-	for _, cfg := range codecConfigs {
+	if len(info.metadataTracks) > 0 {
+		fmt.Println()
+		printMetadataTracks(info.metadataTracks)
+	}
+
+	if len(info.scripts) > 0 {
+		fmt.Println()
+		printScriptData(info.scripts)
+	}
+
+	for _, cfg := range info.codecConfigs {
 		fmt.Println()
 		printCodecConfig(cfg)
 	}
 
-	for i, res := range vp9Resolutions {
+	for i, res := range info.vp9Resolutions {
 		fmt.Println()
-		if len(vp9Resolutions) == 1 {
+		if len(info.vp9Resolutions) == 1 {
 			fmt.Printf("VP9 Keyframe Resolution\n")
 		} else {
 			fmt.Printf("VP9 Keyframe Resolution #%d\n", i+1)
@@ -203,43 +243,38 @@ func InfoFLV(inputPath string, jsonOutput bool, verbose bool) error {
 		fmt.Printf("  Height: %d\n", res.height)
 	}
 
-	// TODO: Parse tag headers for timestamps, stream IDs, data sizes
-	// TODO: Detect and report E-FLV track information
-	// TODO: Output as text or JSON based on jsonOutput flag
-	// TODO: Include offsets, timestamps, tag counts when verbose is true
+	for _, c := range info.colorInfos {
+		fmt.Println()
+		printColorInfo(c)
+	}
 
-	return nil
+	if info.tagList != nil {
+		fmt.Println()
+		printTagList(info.tagList)
+	}
 }
 
-// parseScriptTag reads dataSize bytes from r and, if the first AMF0 value is
-// the string "onMetaData", returns the properties of the second AMF0 value.
-// Returns an empty slice (no error) if this is not an onMetaData tag.
-func parseScriptTag(r io.Reader, dataSize int) ([]amf0Property, error) {
+// parseScriptTag reads dataSize bytes from r and, if the tag is an
+// onMetaData call (possibly wrapped in @setDataFrame), returns the properties
+// of its argument. Returns an empty slice (no error) if this is not an
+// onMetaData tag. If the onMetaData value cannot be decoded completely, the
+// properties decoded before the problem are returned together with the error.
+func parseScriptTag(r io.Reader, dataSize int) ([]AMF0Property, error) {
 	payload := make([]byte, dataSize)
 	if _, err := io.ReadFull(r, payload); err != nil {
 		return nil, err
 	}
 
-	// First AMF0 value should be a string.
-	name, offset, err := parseAMF0Value(payload, 0)
-	if err != nil {
-		return []amf0Property{}, nil // not parseable, skip
+	sd, err := parseScriptData(payload)
+	if sd.method != "onMetaData" {
+		return []AMF0Property{}, nil
 	}
-
-	nameStr, ok := name.(string)
-	if !ok || nameStr != "onMetaData" {
-		return []amf0Property{}, nil
+	props := sd.onMetaData()
+	if props == nil {
+		props = []AMF0Property{}
 	}
-
-	// Second AMF0 value should be an object or ECMA array.
-	value, _, err := parseAMF0Value(payload, offset)
 	if err != nil {
-		return []amf0Property{}, nil
-	}
-
-	props, ok := value.([]amf0Property)
-	if !ok {
-		return []amf0Property{}, nil
+		return props, fmt.Errorf("onMetaData: %w", err)
 	}
 	return props, nil
 }
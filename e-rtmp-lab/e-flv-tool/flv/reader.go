@@ -0,0 +1,132 @@
+package flv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Tag is a single FLV tag: the fields of the 11-byte tag header plus the
+// raw tag payload.
+type Tag struct {
+	Type      TagType
+	Timestamp uint32 // DTS in milliseconds, TimestampExtended in the upper 8 bits
+	StreamID  uint32 // always 0 in well-formed files
+	Offset    int64  // byte offset of the tag header from the start of the file
+	Data      []byte // tag payload (AudioTagHeader/VideoTagHeader included)
+}
+
+// Reader reads an FLV/E-FLV stream tag by tag.
+//
+//	r, err := flv.NewReader(f)
+//	...
+//	for {
+//		tag, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+type Reader struct {
+	r      *bufio.Reader
+	header FLVHeader
+	offset int64 // offset of the next tag header
+	done   bool
+}
+
+// NewReader reads and validates the FLV header from r and positions the
+// reader at the first tag.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+
+	header, err := parseHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if header.DataOffset < 9 {
+		return nil, fmt.Errorf("invalid data offset %d", header.DataOffset)
+	}
+	if _, err := io.CopyN(io.Discard, br, int64(header.DataOffset)-9); err != nil {
+		return nil, fmt.Errorf("seek to data offset: %w", err)
+	}
+
+	fr := &Reader{r: br, header: header, offset: int64(header.DataOffset) + 4}
+
+	var previousTagSize [4]byte
+	if _, err := io.ReadFull(br, previousTagSize[:]); err != nil {
+		if err != io.EOF {
+			return nil, fmt.Errorf("reading first previous tag size: %w", err)
+		}
+		fr.done = true // header only, no tags
+	}
+	return fr, nil
+}
+
+// Header returns the FLV header read by NewReader.
+func (r *Reader) Header() FLVHeader {
+	return r.header
+}
+
+// Next reads the next tag and the PreviousTagSize that follows it. It returns
+// io.EOF when the stream ends at a tag boundary. The returned tag owns its
+// Data slice.
+func (r *Reader) Next() (*Tag, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	var tagHeader [11]byte
+	if _, err := io.ReadFull(r.r, tagHeader[:]); err != nil {
+		if err == io.EOF {
+			r.done = true
+			return nil, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("reading tag header: truncated file")
+		}
+		return nil, fmt.Errorf("reading tag header: %w", err)
+	}
+
+	dataSize := int(tagHeader[1])<<16 | int(tagHeader[2])<<8 | int(tagHeader[3])
+	tag := &Tag{
+		Type: TagType(tagHeader[0] & 0x1f),
+		Timestamp: uint32(tagHeader[7])<<24 | uint32(tagHeader[4])<<16 |
+			uint32(tagHeader[5])<<8 | uint32(tagHeader[6]),
+		StreamID: uint32(tagHeader[8])<<16 | uint32(tagHeader[9])<<8 | uint32(tagHeader[10]),
+		Offset:   r.offset,
+		Data:     make([]byte, dataSize),
+	}
+	if _, err := io.ReadFull(r.r, tag.Data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("reading tag payload: truncated file")
+		}
+		return nil, fmt.Errorf("reading tag payload: %w", err)
+	}
+
+	var previousTagSize [4]byte
+	if _, err := io.ReadFull(r.r, previousTagSize[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("reading previous tag size: truncated file")
+		}
+		return nil, fmt.Errorf("reading previous tag size: %w", err)
+	}
+
+	r.offset += int64(len(tagHeader)+dataSize) + 4
+	return tag, nil
+}
+
+// CodecConfigs decodes the codec configuration records carried by an audio
+// or video sequence-start tag (legacy AVC/AAC sequence headers included).
+// Tags that carry no configuration record return nil.
+func (t *Tag) CodecConfigs() ([]CodecConfig, error) {
+	switch t.Type {
+	case TagTypeVideo:
+		cfgs, _, err := parseVideoConfig(bytes.NewReader(t.Data), len(t.Data))
+		return cfgs, err
+	case TagTypeAudio:
+		return parseAudioConfig(bytes.NewReader(t.Data), len(t.Data))
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,509 @@
+package flv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// remuxSample is one coded frame of a track. Timestamps are kept as read from
+// the tags; every output format converts them to its own timescale.
+type remuxSample struct {
+	dts     uint32 // ms
+	nano    uint32 // TimestampOffsetNano, 0-999999 ns on top of dts
+	cto     int32  // composition time offset, ms
+	key     bool   // sync sample; every audio frame is one
+	size    int
+	samples int // audio samples of the frame at the codec's output rate, 0 if unknown
+}
+
+// pts returns the presentation time of the sample in nanoseconds.
+func (s *remuxSample) pts() int64 {
+	return int64(s.dts)*1e6 + int64(s.nano) + int64(s.cto)*1e6
+}
+
+// remuxTrack is an audio or video track of the input, keyed by media type
+// and trackId. Only the first codec configuration record is kept.
+type remuxTrack struct {
+	trackType string // "audio" or "video"
+	trackID   byte
+	fourCC    string // "" for legacy codecs without a FourCC
+	codec     string // FourCC or legacy codec name, for messages
+	config    []byte // body of the first SequenceStart packet
+	first     []byte // first coded frame, to probe what the config lacks
+	samples   []remuxSample
+	bytes     int64
+	removed   bool // not supported by the output format
+
+	trackFormat
+}
+
+// trackFormat holds the media properties of a track from its codec
+// configuration (or its first frame), 0 if unknown.
+type trackFormat struct {
+	width, height int
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+}
+
+// name returns how the track is referred to in messages.
+func (t *remuxTrack) name() string {
+	return fmt.Sprintf("%s track %d (%s)", t.trackType, t.trackID, orDash(t.codec))
+}
+
+// remuxInput is the result of the first pass over an FLV file to remux: its
+// tracks with the timing and size of every frame. The frame data is read
+// again by walk when the output is written.
+type remuxInput struct {
+	path     string
+	tracks   []*remuxTrack
+	order    []*remuxTrack // track of every sample, in stream order
+	warnings []string
+}
+
+// readRemuxInput reads the tracks and frames of an FLV/E-FLV file. Frames
+// that precede the codec configuration of their track cannot be decoded and
+// are dropped, with a warning.
+func readRemuxInput(inputPath string) (*remuxInput, error) {
+	in := &remuxInput{path: inputPath}
+	dropped := map[*remuxTrack]int{}
+	err := in.walk(true, func(t *remuxTrack, s remuxSample, data []byte, configured bool) error {
+		if !configured {
+			dropped[t]++
+			return nil
+		}
+		if len(t.samples) == 0 {
+			t.first = bytes.Clone(data)
+		}
+		t.samples = append(t.samples, s)
+		t.bytes += int64(s.size)
+		in.order = append(in.order, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range in.tracks {
+		if n := dropped[t]; n > 0 {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %d frames before the codec configuration dropped", t.name(), n))
+		}
+		t.trackFormat = probeTrackFormat(t.trackType, t.fourCC, t.config, t.first)
+	}
+	return in, nil
+}
+
+// needsConfig reports whether frames of a codec cannot be decoded without a
+// codec configuration record.
+func needsConfig(fourCC string) bool {
+	return fourCC != ".mp3" && fourCC != "ac-3" && fourCC != "ec-3" && fourCC != "vp08"
+}
+
+// walk reads the input from the start and calls fn for every coded frame in
+// stream order, with configured set once the track has had its codec
+// configuration. With collect set tracks are created and configurations
+// recorded as they appear; otherwise frames of unknown or removed tracks are
+// skipped and fn is only called for configured frames.
+func (in *remuxInput) walk(collect bool, fn func(t *remuxTrack, s remuxSample, data []byte, configured bool) error) error {
+	f, err := os.Open(in.path)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	configured := map[*remuxTrack]bool{}
+	var tagIndex uint64
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tagIndex++
+
+		for _, fr := range remuxFrames(tag) {
+			t := in.track(fr.trackType, fr.trackID)
+			if t == nil {
+				if !collect {
+					continue
+				}
+				t = &remuxTrack{trackType: fr.trackType, trackID: fr.trackID, fourCC: fr.fourCC, codec: fr.codec}
+				in.tracks = append(in.tracks, t)
+			}
+			if t.removed {
+				continue
+			}
+			if fr.config {
+				if len(fr.data) == 0 {
+					// Some muxers send an empty record ahead of the real one.
+					continue
+				}
+				if collect {
+					switch {
+					case t.config == nil:
+						t.config = bytes.Clone(fr.data)
+					case !bytes.Equal(t.config, fr.data):
+						in.warnings = append(in.warnings, fmt.Sprintf("%s: codec configuration changes at tag #%d; the first one is used", t.name(), tagIndex))
+					}
+				}
+				configured[t] = true
+				continue
+			}
+			if fr.fourCC != t.fourCC || fr.codec != t.codec {
+				if collect {
+					in.warnings = append(in.warnings, fmt.Sprintf("%s: frame of codec %s at tag #%d skipped", t.name(), orDash(fr.codec), tagIndex))
+				}
+				continue
+			}
+			ok := configured[t] || !needsConfig(t.fourCC)
+			if !ok && !collect {
+				continue
+			}
+			if err := fn(t, fr.sample, fr.data, ok); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (in *remuxInput) track(trackType string, trackID byte) *remuxTrack {
+	for _, t := range in.tracks {
+		if t.trackType == trackType && t.trackID == trackID {
+			return t
+		}
+	}
+	return nil
+}
+
+// selectTracks removes the tracks whose codec the output format does not
+// support, and the tracks without frames, with a warning. It returns the
+// remaining tracks.
+func (in *remuxInput) selectTracks(format string, supported func(fourCC string) bool) []*remuxTrack {
+	var tracks []*remuxTrack
+	for _, t := range in.tracks {
+		switch {
+		case !supported(t.fourCC):
+			t.removed = true
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: codec not supported in %s output, skipping", t.name(), format))
+		case len(t.samples) == 0:
+			t.removed = true
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: no frames, skipping", t.name()))
+		default:
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// startPTS returns the earliest presentation time of the track's samples in
+// nanoseconds.
+func (t *remuxTrack) startPTS() int64 {
+	start := t.samples[0].pts()
+	for i := range t.samples {
+		start = min(start, t.samples[i].pts())
+	}
+	return start
+}
+
+// remuxOrigin returns the earliest presentation time of all tracks, which
+// becomes time zero of the output.
+func remuxOrigin(tracks []*remuxTrack) int64 {
+	origin := tracks[0].startPTS()
+	for _, t := range tracks[1:] {
+		origin = min(origin, t.startPTS())
+	}
+	return origin
+}
+
+// maxAudioJitter is how far, in ms, the DTS of an audio frame may be from the
+// end of the previous frame before it is taken as a gap in the audio.
+const maxAudioJitter = 10
+
+// decodeTimes returns the DTS of every sample in units of timescale. The tag
+// timestamps only have millisecond precision, so audio frames with a known
+// number of samples are placed right after each other as long as the tag
+// timestamps stay within maxAudioJitter ms. DTS that do not increase are
+// moved one unit after the previous sample; the number of such samples is
+// returned.
+func (t *remuxTrack) decodeTimes(timescale int64) (dts []int64, adjusted int) {
+	dts = make([]int64, len(t.samples))
+	for i := range t.samples {
+		s := &t.samples[i]
+		d := scaleNanos(int64(s.dts)*1e6+int64(s.nano), timescale)
+		if i > 0 {
+			prev := &t.samples[i-1]
+			if t.trackType == "audio" && prev.samples > 0 && t.sampleRate > 0 {
+				next := dts[i-1] + int64(prev.samples)*timescale/int64(t.sampleRate)
+				if abs64(next-d) <= maxAudioJitter*timescale/1000 {
+					d = next
+				}
+			}
+			if d <= dts[i-1] {
+				d = dts[i-1] + 1
+				adjusted++
+			}
+		}
+		dts[i] = d
+	}
+	return dts, adjusted
+}
+
+// sampleDurations returns the duration of every sample in units of
+// timescale, given the DTS from decodeTimes. The last sample lasts as long as
+// its audio samples, or as long as the one before it.
+func (t *remuxTrack) sampleDurations(dts []int64, timescale int64) []int64 {
+	durations := make([]int64, len(dts))
+	for i := 0; i+1 < len(dts); i++ {
+		durations[i] = dts[i+1] - dts[i]
+	}
+	last := len(dts) - 1
+	switch s := &t.samples[last]; {
+	case t.trackType == "audio" && s.samples > 0 && t.sampleRate > 0:
+		durations[last] = int64(s.samples) * timescale / int64(t.sampleRate)
+	case last > 0:
+		durations[last] = durations[last-1]
+	default:
+		durations[last] = timescale / 30
+	}
+	return durations
+}
+
+// scaleNanos converts nanoseconds to units of timescale, rounding to the
+// nearest unit.
+func scaleNanos(ns, timescale int64) int64 {
+	v := ns * timescale
+	if v < 0 {
+		return (v - 5e8) / 1e9
+	}
+	return (v + 5e8) / 1e9
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// remuxFrame is a coded frame or codec configuration record of one track
+// found in a tag.
+type remuxFrame struct {
+	trackType string
+	trackID   byte
+	fourCC    string
+	codec     string
+	config    bool // SequenceStart
+	sample    remuxSample
+	data      []byte
+}
+
+// remuxFrames returns the coded frames and codec configurations of an audio
+// or video tag. Other packets (metadata, commands, sequence ends) and tags
+// that cannot be parsed return nil.
+func remuxFrames(tag *Tag) []remuxFrame {
+	var frames []remuxFrame
+	switch tag.Type {
+	case TagTypeVideo:
+		pkt, err := ParseVideoPacket(tag.Data)
+		if err != nil || pkt.HasCommand {
+			return nil
+		}
+		isConfig := pkt.PacketType == VideoPacketTypeSequenceStart
+		if !isConfig && pkt.PacketType != VideoPacketTypeCodedFrames && pkt.PacketType != VideoPacketTypeCodedFramesX {
+			return nil
+		}
+		for _, vt := range pkt.Tracks {
+			codec := vt.FourCC
+			if codec == "" {
+				codec = enumName(videoCodecNames, pkt.CodecID)
+			}
+			if vt.FourCC == "av01" && !isConfig {
+				vt.Data = stripTemporalDelimiter(vt.Data)
+			}
+			frames = append(frames, remuxFrame{
+				trackType: "video",
+				trackID:   vt.TrackID,
+				fourCC:    vt.FourCC,
+				codec:     codec,
+				config:    isConfig,
+				sample: remuxSample{
+					dts:  tag.Timestamp,
+					nano: pkt.TimestampOffsetNano,
+					cto:  vt.CompositionTimeOffset,
+					key:  pkt.FrameType.IsKeyFrame(),
+					size: len(vt.Data),
+				},
+				data: vt.Data,
+			})
+		}
+	case TagTypeAudio:
+		pkt, err := ParseAudioPacket(tag.Data)
+		if err != nil {
+			return nil
+		}
+		isConfig := pkt.PacketType == AudioPacketTypeSequenceStart
+		if !isConfig && pkt.PacketType != AudioPacketTypeCodedFrames {
+			return nil
+		}
+		for _, at := range pkt.Tracks {
+			codec := at.FourCC
+			if codec == "" {
+				codec = enumName(soundFormatNames, pkt.SoundFormat)
+			}
+			frames = append(frames, remuxFrame{
+				trackType: "audio",
+				trackID:   at.TrackID,
+				fourCC:    at.FourCC,
+				codec:     codec,
+				config:    isConfig,
+				sample: remuxSample{
+					dts:     tag.Timestamp,
+					nano:    pkt.TimestampOffsetNano,
+					key:     true,
+					size:    len(at.Data),
+					samples: audioFrameSamples(at.FourCC, at.Data),
+				},
+				data: at.Data,
+			})
+		}
+	}
+	return frames
+}
+
+// stripTemporalDelimiter removes the temporal delimiter OBU an AV1 frame may
+// start with. The MP4 and Matroska bindings of AV1 keep them out of the
+// samples; the container marks the temporal units instead.
+func stripTemporalDelimiter(data []byte) []byte {
+	if len(data) >= 2 && data[0] == 0x12 && data[1] == 0x00 { // OBU_TEMPORAL_DELIMITER with obu_size 0
+		return data[2:]
+	}
+	return data
+}
+
+// probeTrackFormat returns the media properties of a track from its codec
+// configuration record, or from its first coded frame for what the record
+// does not carry.
+func probeTrackFormat(trackType, fourCC string, config, first []byte) trackFormat {
+	var fields []ConfigField
+	if config != nil {
+		if trackType == "video" {
+			fields = parseVideoConfigByFourCC(fourCC, config)
+		} else {
+			fields = parseAudioConfigByFourCC(fourCC, config)
+		}
+	}
+	field := func(names ...string) int {
+		for _, name := range names {
+			for _, f := range fields {
+				if v, ok := f.Value.(int); ok && f.Name == name {
+					return v
+				}
+			}
+		}
+		return 0
+	}
+
+	var t trackFormat
+	switch fourCC {
+	case "avc1", "hvc1":
+		t.width, t.height = field("width"), field("height")
+	case "av01":
+		t.width, t.height = field("max_frame_width"), field("max_frame_height")
+	case "vp08":
+		t.width, t.height, _ = parseVP8KeyframeResolution(first)
+	case "vp09":
+		t.width, t.height, _ = parseVP9KeyframeResolution(first)
+	case "mp4a":
+		t.sampleRate, t.channels = field("samplingFrequency"), field("channelConfiguration")
+		t.bitsPerSample = 16
+	case "Opus":
+		t.sampleRate, t.channels = 48000, field("channels")
+		t.bitsPerSample = 16
+	case "fLaC":
+		t.sampleRate, t.channels, t.bitsPerSample = field("sampleRate"), field("channels"), field("bitsPerSample")
+	case ".mp3":
+		t.sampleRate, t.channels = mp3FrameFormat(first)
+		t.bitsPerSample = 16
+	}
+	return t
+}
+
+// MPEG audio sampling rates by version (MPEG-1, MPEG-2, MPEG-2.5) and index.
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000},
+	2: {22050, 24000, 16000},
+	0: {11025, 12000, 8000},
+}
+
+// mp3FrameFormat returns the sampling rate and channel count of an MPEG audio
+// frame header.
+func mp3FrameFormat(data []byte) (sampleRate, channels int) {
+	if mp3FrameSamples(data) == 0 {
+		return 0, 0
+	}
+	rates, ok := mp3SampleRates[(data[1]>>3)&0x03]
+	index := (data[2] >> 2) & 0x03
+	if !ok || index == 3 {
+		return 0, 0
+	}
+	channels = 2
+	if data[3]>>6 == 3 { // single channel
+		channels = 1
+	}
+	return rates[index], channels
+}
+
+// RemuxFLV converts an FLV/E-FLV file to another container. format selects
+// the output: "mp4" writes a progressive MP4 with the moov box ahead of the
+// media data, "fmp4" a fragmented MP4 whose fragments start at keyframes at
+// least fragmentDuration ms apart, "ts" an MPEG-2 transport stream and
+// "webm" a WebM file. Tracks whose codec the format cannot carry
+// are skipped with a warning.
+func RemuxFLV(inputPath, outputPath, format string, fragmentDuration uint32) error {
+	switch format {
+	case "mp4", "fmp4", "ts", "webm":
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+
+	in, err := readRemuxInput(inputPath)
+	if err != nil {
+		return err
+	}
+	var tracks []*remuxTrack
+	var fragments int
+	switch format {
+	case "mp4":
+		tracks, err = writeMP4(in, outputPath)
+	case "fmp4":
+		tracks, fragments, err = writeFragmentedMP4(in, outputPath, fragmentDuration)
+	case "ts":
+		tracks, err = writeTS(in, outputPath)
+	case "webm":
+		tracks, err = writeWebM(in, outputPath)
+	}
+	// Printed before any error, which may be caused by the skipped tracks.
+	for _, w := range in.warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Output: %s\n", outputPath)
+	for i, t := range tracks {
+		fmt.Printf("  Track %d: %s, %d samples\n", i+1, t.name(), len(t.samples))
+	}
+	if fragments > 0 {
+		fmt.Printf("  Fragments: %d\n", fragments)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// mp4Fragment is one fragment of fragmented MP4 output: for every track, a
+// run of samples written as its own moof/mdat pair, as CMAF requires one
+// track per fragment.
+type mp4Fragment struct {
+	first     []int    // first sample of each track
+	count     []int    // number of samples of each track
+	data      [][]byte // sample data of each track, filled by the second pass
+	remaining int      // samples not read yet
+}
+
+// writeFragmentedMP4 writes the supported tracks of in to outputPath as a
+// fragmented MP4: an initialization segment (ftyp and a moov without
+// samples), then moof/mdat fragments. Fragments start at the keyframes of the
+// first video track, at least fragmentDuration ms apart. It returns the
+// tracks written, in track_ID order, and the number of fragments.
+func writeFragmentedMP4(in *remuxInput, outputPath string, fragmentDuration uint32) ([]*remuxTrack, int, error) {
+	selected := in.selectTracks("fragmented MP4", func(fourCC string) bool { return mp4Codecs[fourCC] })
+	if len(selected) == 0 {
+		return nil, 0, fmt.Errorf("no tracks to remux")
+	}
+
+	tracks, byInput := newMP4Tracks(in, selected)
+	for _, mt := range tracks {
+		mt.fragmented = true
+	}
+	fragments := planFragments(tracks, fragmentDuration)
+
+	ftyp := mp4FileType("iso6", 0, append([]string{"iso6", "cmfc", "mp41"}, mp4CodecBrands(tracks)...)...)
+	moov, err := mp4FragmentedMovie(tracks)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating output: %w", err)
+	}
+	defer out.Close()
+
+	bw := bufio.NewWriterSize(out, 1<<20)
+	for _, b := range [][]byte{ftyp, moov} {
+		if _, err := bw.Write(b); err != nil {
+			return nil, 0, fmt.Errorf("writing output: %w", err)
+		}
+	}
+
+	// Frames arrive in stream order, which may interleave the tracks a little
+	// differently from the cut points, so a fragment is written once all of
+	// its samples have been read.
+	next := make(map[*mp4Track]int, len(tracks))
+	current := make(map[*mp4Track]int, len(tracks))
+	written, sequence := 0, uint32(0)
+	err = in.walk(false, func(t *remuxTrack, s remuxSample, data []byte, configured bool) error {
+		mt := byInput[t]
+		i, ti := next[mt], int(mt.id-1)
+		if i >= len(mt.samples) || mt.samples[i].size != len(data) {
+			return fmt.Errorf("%s: input changed while remuxing", t.name())
+		}
+		next[mt]++
+
+		k := current[mt]
+		for i >= fragments[k].first[ti]+fragments[k].count[ti] {
+			k++
+		}
+		current[mt] = k
+		f := fragments[k]
+		f.data[ti] = append(f.data[ti], data...)
+		f.remaining--
+
+		for ; written < len(fragments) && fragments[written].remaining == 0; written++ {
+			if err := writeFragment(bw, tracks, fragments[written], &sequence); err != nil {
+				return err
+			}
+			fragments[written].data = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if written != len(fragments) {
+		return nil, 0, fmt.Errorf("input changed while remuxing")
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, 0, fmt.Errorf("writing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, 0, fmt.Errorf("closing output: %w", err)
+	}
+	return selected, len(fragments), nil
+}
+
+// writeFragment writes a moof/mdat pair for every track with samples in the
+// fragment. sequence is the sequence number of the last moof written.
+func writeFragment(w *bufio.Writer, tracks []*mp4Track, f *mp4Fragment, sequence *uint32) error {
+	for ti, mt := range tracks {
+		if f.count[ti] == 0 {
+			continue
+		}
+		*sequence++
+		moof := mt.movieFragment(*sequence, f.first[ti], f.count[ti])
+		for _, b := range [][]byte{moof, mp4MediaDataHeader(int64(len(f.data[ti]))), f.data[ti]} {
+			if _, err := w.Write(b); err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// planFragments splits the samples of the tracks into fragments. The cut
+// points are the keyframes of the first video track (or every sample of the
+// first track if there is no video) that are at least fragmentDuration ms
+// after the start of the current fragment. The samples of every track go to
+// the fragment their DTS falls in.
+func planFragments(tracks []*mp4Track, fragmentDuration uint32) []*mp4Fragment {
+	ref := tracks[0]
+	for _, mt := range tracks {
+		if mt.trackType == "video" {
+			ref = mt
+			break
+		}
+	}
+
+	var cuts []int64 // DTS in ns
+	start := ref.decodeNanos(0)
+	for i := 1; i < len(ref.samples); i++ {
+		if dts := ref.decodeNanos(i); ref.samples[i].key && dts-start >= int64(fragmentDuration)*1e6 {
+			cuts = append(cuts, dts)
+			start = dts
+		}
+	}
+
+	fragments := make([]*mp4Fragment, len(cuts)+1)
+	for k := range fragments {
+		fragments[k] = &mp4Fragment{
+			first: make([]int, len(tracks)),
+			count: make([]int, len(tracks)),
+			data:  make([][]byte, len(tracks)),
+		}
+	}
+	for ti, mt := range tracks {
+		for i := range mt.samples {
+			dts := mt.decodeNanos(i)
+			k := sort.Search(len(cuts), func(k int) bool { return cuts[k] > dts })
+			f := fragments[k]
+			if f.count[ti] == 0 {
+				f.first[ti] = i
+			}
+			f.count[ti]++
+			f.remaining++
+		}
+	}
+	return fragments
+}
+
+// decodeNanos returns the DTS of sample i in nanoseconds.
+func (mt *mp4Track) decodeNanos(i int) int64 {
+	return mt.dts[i] * 1e9 / mt.timescale
+}
+
+// mp4FragmentedMovie returns the moov box of the initialization segment:
+// tracks with empty sample tables and an mvex box announcing the fragments.
+func mp4FragmentedMovie(tracks []*mp4Track) ([]byte, error) {
+	children := [][]byte{mp4MovieHeader(0, uint32(len(tracks)+1))}
+	var trex [][]byte
+	for _, mt := range tracks {
+		stsd, err := mt.sampleDescription()
+		if err != nil {
+			return nil, err
+		}
+		stbl := mp4Box("stbl",
+			stsd,
+			mp4FullBox("stts", 0, 0, []byte{0, 0, 0, 0}),
+			mp4FullBox("stsc", 0, 0, []byte{0, 0, 0, 0}),
+			mp4FullBox("stsz", 0, 0, make([]byte, 8)),
+			mp4FullBox("stco", 0, 0, []byte{0, 0, 0, 0}),
+		)
+		children = append(children, mt.trak(stbl, 0))
+
+		b := binary.BigEndian.AppendUint32(nil, mt.id)
+		b = binary.BigEndian.AppendUint32(b, 1) // default_sample_description_index
+		b = append(b, make([]byte, 12)...)      // default duration, size and flags
+		trex = append(trex, mp4FullBox("trex", 0, 0, b))
+	}
+	children = append(children, mp4Box("mvex", trex...))
+	return mp4Box("moov", children...), nil
+}
+
+// Sample flags of trun (ISO/IEC 14496-12 8.8.3.1).
+const (
+	mp4SyncSampleFlags    = 0x02000000 // sample_depends_on 2: an I picture
+	mp4NonSyncSampleFlags = 0x01010000 // sample_depends_on 1, sample_is_non_sync_sample
+)
+
+// movieFragment returns the moof box for count samples of the track starting
+// at sample first. The sample data follows in the mdat right after it.
+func (mt *mp4Track) movieFragment(sequence uint32, first, count int) []byte {
+	withCTO, negative := false, false
+	for i := first; i < first+count; i++ {
+		if c := mt.samples[i].cto; c != 0 {
+			withCTO = true
+			negative = negative || c < 0
+		}
+	}
+
+	mfhd := mp4FullBox("mfhd", 0, 0, binary.BigEndian.AppendUint32(nil, sequence))
+	tfhd := mp4FullBox("tfhd", 0, 0x020000, binary.BigEndian.AppendUint32(nil, mt.id)) // default-base-is-moof
+	tfdt := mp4FullBox("tfdt", 1, 0, binary.BigEndian.AppendUint64(nil, uint64(mt.dts[first])))
+
+	// data-offset, sample-duration, sample-size, sample-flags present
+	flags, version := uint32(0x000701), byte(0)
+	if withCTO {
+		flags |= 0x000800 // sample-composition-time-offsets-present
+		if negative {
+			version = 1
+		}
+	}
+	run := func(dataOffset uint32) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(count))
+		b = binary.BigEndian.AppendUint32(b, dataOffset)
+		for i := first; i < first+count; i++ {
+			b = binary.BigEndian.AppendUint32(b, uint32(mt.durations[i]))
+			b = binary.BigEndian.AppendUint32(b, uint32(mt.samples[i].size))
+			if mt.samples[i].key {
+				b = binary.BigEndian.AppendUint32(b, mp4SyncSampleFlags)
+			} else {
+				b = binary.BigEndian.AppendUint32(b, mp4NonSyncSampleFlags)
+			}
+			if withCTO {
+				b = binary.BigEndian.AppendUint32(b, uint32(int32(mt.cto(i))))
+			}
+		}
+		return mp4FullBox("trun", version, flags, b)
+	}
+
+	// The data offset is relative to the start of the moof and points past
+	// the mdat header.
+	size := len(mp4Box("moof", mfhd, mp4Box("traf", tfhd, tfdt, run(0))))
+	var dataSize int64
+	for i := first; i < first+count; i++ {
+		dataSize += int64(mt.samples[i].size)
+	}
+	offset := size + len(mp4MediaDataHeader(dataSize))
+	return mp4Box("moof", mfhd, mp4Box("traf", tfhd, tfdt, run(uint32(offset))))
+}
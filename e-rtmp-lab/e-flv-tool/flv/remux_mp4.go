@@ -0,0 +1,274 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// mp4Chunk is a run of consecutive samples of one track in the mdat box.
+type mp4Chunk struct {
+	offset  int64 // from the start of the mdat payload
+	samples int
+}
+
+// writeMP4 writes the supported tracks of in to outputPath as a progressive
+// MP4: ftyp, then moov, then a single mdat with the frames in their FLV order,
+// so playback can start before the whole file is downloaded. It returns the
+// tracks written, in track_ID order.
+func writeMP4(in *remuxInput, outputPath string) ([]*remuxTrack, error) {
+	selected := in.selectTracks("MP4", func(fourCC string) bool { return mp4Codecs[fourCC] })
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no tracks to remux")
+	}
+
+	tracks, byInput := newMP4Tracks(in, selected)
+
+	// Chunks follow the interleaving of the input.
+	chunks := make(map[*mp4Track][]mp4Chunk, len(tracks))
+	next := make(map[*mp4Track]int, len(tracks))
+	var dataSize int64
+	var last *mp4Track
+	for _, t := range in.order {
+		mt := byInput[t]
+		if mt == nil {
+			continue
+		}
+		if mt != last {
+			chunks[mt] = append(chunks[mt], mp4Chunk{offset: dataSize})
+			last = mt
+		}
+		chunks[mt][len(chunks[mt])-1].samples++
+		dataSize += int64(mt.samples[next[mt]].size)
+		next[mt]++
+	}
+
+	ftyp := mp4FileType("isom", 0x200, append([]string{"isom", "iso2", "mp41"}, mp4CodecBrands(tracks)...)...)
+	mdatHeader := mp4MediaDataHeader(dataSize)
+
+	// The chunk offsets depend on the size of moov, which only depends on
+	// whether 32-bit offsets are enough.
+	moov, err := mp4Movie(tracks, chunks, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	base := int64(len(ftyp) + len(moov) + len(mdatHeader))
+	co64 := base+dataSize > math.MaxUint32
+	if co64 {
+		if moov, err = mp4Movie(tracks, chunks, 0, true); err != nil {
+			return nil, err
+		}
+		base = int64(len(ftyp) + len(moov) + len(mdatHeader))
+	}
+	if moov, err = mp4Movie(tracks, chunks, base, co64); err != nil {
+		return nil, err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating output: %w", err)
+	}
+	defer out.Close()
+
+	bw := bufio.NewWriterSize(out, 1<<20)
+	for _, b := range [][]byte{ftyp, moov, mdatHeader} {
+		if _, err := bw.Write(b); err != nil {
+			return nil, fmt.Errorf("writing output: %w", err)
+		}
+	}
+	if err := writeMP4Samples(in, bw); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("writing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("closing output: %w", err)
+	}
+	return selected, nil
+}
+
+// newMP4Tracks returns the output tracks for the selected input tracks,
+// numbered from 1, and a map from input to output track.
+func newMP4Tracks(in *remuxInput, selected []*remuxTrack) ([]*mp4Track, map[*remuxTrack]*mp4Track) {
+	origin := remuxOrigin(selected)
+	tracks := make([]*mp4Track, len(selected))
+	byInput := make(map[*remuxTrack]*mp4Track, len(selected))
+	for i, t := range selected {
+		mt := newMP4Track(t, uint32(i+1), origin)
+		if mt.adjusted > 0 {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %d samples with non-increasing DTS moved forward", t.name(), mt.adjusted))
+		}
+		tracks[i] = mt
+		byInput[t] = mt
+	}
+	return tracks, byInput
+}
+
+// writeMP4Samples reads the input a second time and writes the frames of the
+// selected tracks in stream order, checking them against the first pass.
+func writeMP4Samples(in *remuxInput, w *bufio.Writer) error {
+	written := map[*remuxTrack]int{}
+	err := in.walk(false, func(t *remuxTrack, s remuxSample, data []byte, configured bool) error {
+		n := written[t]
+		if n >= len(t.samples) || t.samples[n].size != len(data) {
+			return fmt.Errorf("%s: input changed while remuxing", t.name())
+		}
+		written[t] = n + 1
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range in.tracks {
+		if !t.removed && written[t] != len(t.samples) {
+			return fmt.Errorf("%s: input changed while remuxing", t.name())
+		}
+	}
+	return nil
+}
+
+// mp4Movie returns the moov box. base is the file offset of the mdat payload.
+func mp4Movie(tracks []*mp4Track, chunks map[*mp4Track][]mp4Chunk, base int64, co64 bool) ([]byte, error) {
+	var duration int64
+	for _, mt := range tracks {
+		duration = max(duration, mt.movieDuration())
+	}
+	children := [][]byte{mp4MovieHeader(duration, uint32(len(tracks)+1))}
+	for _, mt := range tracks {
+		stbl, err := mt.sampleTable(chunks[mt], base, co64)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, mt.trak(stbl, mt.movieDuration()))
+	}
+	return mp4Box("moov", children...), nil
+}
+
+// sampleTable returns the stbl box of the track.
+func (mt *mp4Track) sampleTable(chunks []mp4Chunk, base int64, co64 bool) ([]byte, error) {
+	stsd, err := mt.sampleDescription()
+	if err != nil {
+		return nil, err
+	}
+	children := [][]byte{stsd, mt.timeToSample()}
+	if ctts := mt.compositionOffsets(); ctts != nil {
+		children = append(children, ctts)
+	}
+	if stss := mt.syncSamples(); stss != nil {
+		children = append(children, stss)
+	}
+	children = append(children, mt.sampleSizes(), mp4SampleToChunk(chunks), mp4ChunkOffsets(chunks, base, co64))
+	return mp4Box("stbl", children...), nil
+}
+
+// timeToSample returns the stts box: the sample durations, run-length coded.
+func (mt *mp4Track) timeToSample() []byte {
+	var entries []byte
+	count := 0
+	for i, d := range mt.durations {
+		count++
+		if i+1 < len(mt.durations) && mt.durations[i+1] == d {
+			continue
+		}
+		entries = binary.BigEndian.AppendUint32(entries, uint32(count))
+		entries = binary.BigEndian.AppendUint32(entries, uint32(d))
+		count = 0
+	}
+	return mp4FullBox("stts", 0, 0, binary.BigEndian.AppendUint32(nil, uint32(len(entries)/8)), entries)
+}
+
+// compositionOffsets returns the ctts box, or nil if every sample is
+// presented at its decode time. Version 1 is used for negative offsets.
+func (mt *mp4Track) compositionOffsets() []byte {
+	found, negative := mt.hasCTO()
+	if !found {
+		return nil
+	}
+	var entries []byte
+	count := 0
+	for i := range mt.samples {
+		count++
+		if i+1 < len(mt.samples) && mt.samples[i+1].cto == mt.samples[i].cto {
+			continue
+		}
+		entries = binary.BigEndian.AppendUint32(entries, uint32(count))
+		entries = binary.BigEndian.AppendUint32(entries, uint32(int32(mt.cto(i))))
+		count = 0
+	}
+	version := byte(0)
+	if negative {
+		version = 1
+	}
+	return mp4FullBox("ctts", version, 0, binary.BigEndian.AppendUint32(nil, uint32(len(entries)/8)), entries)
+}
+
+// syncSamples returns the stss box, or nil if every sample is a sync sample.
+func (mt *mp4Track) syncSamples() []byte {
+	var entries []byte
+	for i := range mt.samples {
+		if mt.samples[i].key {
+			entries = binary.BigEndian.AppendUint32(entries, uint32(i+1))
+		}
+	}
+	if len(entries)/4 == len(mt.samples) {
+		return nil
+	}
+	return mp4FullBox("stss", 0, 0, binary.BigEndian.AppendUint32(nil, uint32(len(entries)/4)), entries)
+}
+
+// sampleSizes returns the stsz box, with a single size if all samples have
+// the same one.
+func (mt *mp4Track) sampleSizes() []byte {
+	b := binary.BigEndian.AppendUint32(nil, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(mt.samples)))
+	same := true
+	for i := range mt.samples {
+		same = same && mt.samples[i].size == mt.samples[0].size
+	}
+	if same {
+		binary.BigEndian.PutUint32(b, uint32(mt.samples[0].size))
+		return mp4FullBox("stsz", 0, 0, b)
+	}
+	for i := range mt.samples {
+		b = binary.BigEndian.AppendUint32(b, uint32(mt.samples[i].size))
+	}
+	return mp4FullBox("stsz", 0, 0, b)
+}
+
+// mp4SampleToChunk returns the stsc box: the number of samples per chunk,
+// with an entry for every chunk where it changes.
+func mp4SampleToChunk(chunks []mp4Chunk) []byte {
+	var entries []byte
+	for i, c := range chunks {
+		if i > 0 && chunks[i-1].samples == c.samples {
+			continue
+		}
+		entries = binary.BigEndian.AppendUint32(entries, uint32(i+1))
+		entries = binary.BigEndian.AppendUint32(entries, uint32(c.samples))
+		entries = binary.BigEndian.AppendUint32(entries, 1) // sample_description_index
+	}
+	return mp4FullBox("stsc", 0, 0, binary.BigEndian.AppendUint32(nil, uint32(len(entries)/12)), entries)
+}
+
+// mp4ChunkOffsets returns the stco box, or co64 when offsets may not fit in
+// 32 bits.
+func mp4ChunkOffsets(chunks []mp4Chunk, base int64, co64 bool) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(chunks)))
+	for _, c := range chunks {
+		if co64 {
+			b = binary.BigEndian.AppendUint64(b, uint64(base+c.offset))
+		} else {
+			b = binary.BigEndian.AppendUint32(b, uint32(base+c.offset))
+		}
+	}
+	if co64 {
+		return mp4FullBox("co64", 0, 0, b)
+	}
+	return mp4FullBox("stco", 0, 0, b)
+}
@@ -0,0 +1,132 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFrame is a coded frame of an FLV file.
+type testFrame struct {
+	trackType string
+	dts, pts  int64 // ms
+	data      []byte
+}
+
+// readTestFrames returns the coded frames of an FLV file in tag order.
+func readTestFrames(t *testing.T, path string) []testFrame {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []testFrame
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		dts := int64(tag.Timestamp)
+		switch tag.Type {
+		case TagTypeVideo:
+			vp, err := tag.VideoPacket()
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			if vp.PacketType != VideoPacketTypeCodedFrames && vp.PacketType != VideoPacketTypeCodedFramesX {
+				continue
+			}
+			for _, track := range vp.Tracks {
+				frames = append(frames, testFrame{"video", dts, track.PTS(tag.Timestamp), bytes.Clone(track.Data)})
+			}
+		case TagTypeAudio:
+			ap, err := tag.AudioPacket()
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			if ap.PacketType != AudioPacketTypeCodedFrames {
+				continue
+			}
+			for _, track := range ap.Tracks {
+				frames = append(frames, testFrame{"audio", dts, dts, bytes.Clone(track.Data)})
+			}
+		}
+	}
+}
+
+func TestRemuxConvertRoundTrip(t *testing.T) {
+	const input = "../../assets/testsrc.flv" // AV1 and Opus
+	want := readTestFrames(t, input)
+	for i := range want {
+		if want[i].trackType == "video" {
+			// Temporal delimiters are dropped when remuxing.
+			want[i].data = stripTemporalDelimiter(want[i].data)
+		}
+	}
+
+	for _, format := range []string{"mp4", "fmp4"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			mp4Path := filepath.Join(dir, "out.mp4")
+			flvPath := filepath.Join(dir, "out.flv")
+			if err := RemuxFLV(input, mp4Path, format, 2000); err != nil {
+				t.Fatalf("RemuxFLV: %v", err)
+			}
+			if err := ConvertMP4(mp4Path, flvPath); err != nil {
+				t.Fatalf("ConvertMP4: %v", err)
+			}
+
+			got := readTestFrames(t, flvPath)
+			for _, trackType := range []string{"video", "audio"} {
+				// Audio frames are placed by their sample counts, which
+				// removes the millisecond rounding of the input timestamps.
+				tolerance := int64(0)
+				if trackType == "audio" {
+					tolerance = 1
+				}
+				g, w := framesOf(got, trackType), framesOf(want, trackType)
+				if len(g) != len(w) {
+					t.Fatalf("%s: %d frames, want %d", trackType, len(g), len(w))
+				}
+				for i := range g {
+					if !bytes.Equal(g[i].data, w[i].data) {
+						t.Fatalf("%s frame %d: data differs", trackType, i)
+					}
+					if abs(g[i].dts-w[i].dts) > tolerance || abs(g[i].pts-w[i].pts) > tolerance {
+						t.Fatalf("%s frame %d: DTS/PTS %d/%d, want %d/%d",
+							trackType, i, g[i].dts, g[i].pts, w[i].dts, w[i].pts)
+					}
+				}
+			}
+		})
+	}
+}
+
+// framesOf returns the frames of trackType.
+func framesOf(frames []testFrame, trackType string) []testFrame {
+	var of []testFrame
+	for _, f := range frames {
+		if f.trackType == trackType {
+			of = append(of, f)
+		}
+	}
+	return of
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,498 @@
+package flv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// MPEG-2 Transport Stream (ISO/IEC 13818-1) output.
+
+const (
+	tsPacketSize = 188
+	tsPATPID     = 0x0000
+	tsPMTPID     = 0x1000
+	tsFirstPID   = 0x0100 // PID of the first elementary stream
+
+	// tsMuxDelay is added to the FLV timestamps to get the PTS and DTS, so
+	// that the PCR, which follows the FLV DTS, runs ahead of the frames
+	// (700 ms, as most muxers use).
+	tsMuxDelay = 63000
+
+	// tsTablePeriod is the longest interval between two PAT/PMT, in 90 kHz
+	// units.
+	tsTablePeriod = 9000
+)
+
+// tsCodecs are the FourCCs that can be carried in MPEG-TS output, with their
+// stream_type.
+var tsCodecs = map[string]byte{
+	"avc1": 0x1B, // H.264
+	"hvc1": 0x24, // H.265
+	"mp4a": 0x0F, // AAC with ADTS framing
+	".mp3": 0x03, // MPEG-1 audio; 0x04 for MPEG-2 sampling rates
+}
+
+// tsStream is an elementary stream of the output.
+type tsStream struct {
+	*remuxTrack
+	pid        uint16
+	streamType byte
+	streamID   byte
+	cc         byte    // continuity_counter
+	dts        []int64 // 90 kHz, FLV timeline
+	next       int     // next sample
+
+	// AVC/HEVC: NAL unit length size and the parameter sets of the codec
+	// configuration record in Annex B form.
+	lengthSize    int
+	parameterSets []byte
+
+	// AAC: the ADTS header fields from the AudioSpecificConfig.
+	profile, samplingIndex, channels byte
+}
+
+// newTSStream prepares the conversion of an input track to an elementary
+// stream.
+func newTSStream(t *remuxTrack, pid uint16) (*tsStream, error) {
+	s := &tsStream{remuxTrack: t, pid: pid, streamType: tsCodecs[t.fourCC], streamID: 0xC0}
+	switch t.fourCC {
+	case "avc1", "hvc1":
+		s.streamID = 0xE0
+		var sets [][]byte
+		var ok bool
+		if t.fourCC == "avc1" {
+			s.lengthSize, sets, ok = avcParameterSets(t.config)
+		} else {
+			s.lengthSize, sets, ok = hevcParameterSets(t.config)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid codec configuration record", t.name())
+		}
+		for _, nalu := range sets {
+			s.parameterSets = append(append(s.parameterSets, 0, 0, 0, 1), nalu...)
+		}
+	case "mp4a":
+		var objectType, frequency, channels int
+		for _, f := range parseAACConfig(t.config) {
+			v, _ := f.Value.(int)
+			switch f.Name {
+			case "audioObjectType":
+				objectType = v
+			case "samplingFrequency":
+				frequency = v
+			case "channelConfiguration":
+				channels = v
+			}
+		}
+		index := slices.Index(aacSamplingFrequencies[:], frequency)
+		if objectType == 0 || index < 0 {
+			return nil, fmt.Errorf("%s: unsupported AudioSpecificConfig", t.name())
+		}
+		// ADTS can only signal the first four object types; SBR and PS
+		// streams are signalled implicitly by their AAC LC core.
+		s.profile = 1
+		if objectType <= 4 {
+			s.profile = byte(objectType - 1)
+		}
+		s.samplingIndex, s.channels = byte(index), byte(channels)
+	case ".mp3":
+		if t.sampleRate != 0 && t.sampleRate < 32000 {
+			s.streamType = 0x04
+		}
+	}
+	return s, nil
+}
+
+// avcParameterSets returns the NAL unit length size and the SPS and PPS of an
+// AVCDecoderConfigurationRecord.
+func avcParameterSets(config []byte) (lengthSize int, sets [][]byte, ok bool) {
+	if len(config) < 6 {
+		return 0, nil, false
+	}
+	pos := 5
+	for _, mask := range []byte{0x1F, 0xFF} { // numOfSequenceParameterSets, numOfPictureParameterSets
+		if pos >= len(config) {
+			return 0, nil, false
+		}
+		n := int(config[pos] & mask)
+		pos++
+		for range n {
+			if pos+2 > len(config) {
+				return 0, nil, false
+			}
+			size := int(binary.BigEndian.Uint16(config[pos:]))
+			if pos+2+size > len(config) {
+				return 0, nil, false
+			}
+			sets = append(sets, config[pos+2:pos+2+size])
+			pos += 2 + size
+		}
+	}
+	return int(config[4]&0x03) + 1, sets, true
+}
+
+// hevcParameterSets returns the NAL unit length size and the NAL units of
+// the arrays of an HEVCDecoderConfigurationRecord (VPS, SPS, PPS and SEI).
+func hevcParameterSets(config []byte) (lengthSize int, sets [][]byte, ok bool) {
+	if len(config) < 23 {
+		return 0, nil, false
+	}
+	pos := 23
+	for range int(config[22]) {
+		if pos+3 > len(config) {
+			return 0, nil, false
+		}
+		n := int(binary.BigEndian.Uint16(config[pos+1:]))
+		pos += 3
+		for range n {
+			if pos+2 > len(config) {
+				return 0, nil, false
+			}
+			size := int(binary.BigEndian.Uint16(config[pos:]))
+			if pos+2+size > len(config) {
+				return 0, nil, false
+			}
+			sets = append(sets, config[pos+2:pos+2+size])
+			pos += 2 + size
+		}
+	}
+	return int(config[21]&0x03) + 1, sets, true
+}
+
+// Access unit delimiters in Annex B form, for any picture type.
+var (
+	avcAccessUnitDelimiter  = []byte{0, 0, 0, 1, 0x09, 0xF0}
+	hevcAccessUnitDelimiter = []byte{0, 0, 0, 1, 0x46, 0x01, 0x50}
+)
+
+// annexB converts a frame of length-prefixed NAL units to Annex B byte
+// stream format, starting with an access unit delimiter. Keyframes that do
+// not carry their own parameter sets get the ones of the codec configuration
+// record.
+func (s *tsStream) annexB(data []byte, key bool) ([]byte, error) {
+	hevc := s.fourCC == "hvc1"
+	nalType := func(nalu []byte) byte {
+		if hevc {
+			return (nalu[0] >> 1) & 0x3F
+		}
+		return nalu[0] & 0x1F
+	}
+
+	var nalus [][]byte
+	hasParameterSets := false
+	for pos := 0; pos < len(data); {
+		if pos+s.lengthSize > len(data) {
+			return nil, fmt.Errorf("%s: truncated NAL unit length", s.name())
+		}
+		var size int
+		for _, b := range data[pos : pos+s.lengthSize] {
+			size = size<<8 | int(b)
+		}
+		pos += s.lengthSize
+		if size == 0 {
+			continue
+		}
+		if pos+size > len(data) {
+			return nil, fmt.Errorf("%s: truncated NAL unit", s.name())
+		}
+		nalu := data[pos : pos+size]
+		pos += size
+		switch t := nalType(nalu); {
+		case !hevc && t == 9, hevc && t == 35:
+			continue // replaced by our own delimiter
+		case !hevc && (t == 7 || t == 8), hevc && t >= 32 && t <= 34:
+			hasParameterSets = true
+		}
+		nalus = append(nalus, nalu)
+	}
+
+	out := avcAccessUnitDelimiter
+	if hevc {
+		out = hevcAccessUnitDelimiter
+	}
+	out = slices.Clone(out)
+	if key && !hasParameterSets {
+		out = append(out, s.parameterSets...)
+	}
+	for _, nalu := range nalus {
+		out = append(append(out, 0, 0, 0, 1), nalu...)
+	}
+	return out, nil
+}
+
+// adts prepends an ADTS header (ISO/IEC 13818-7, without CRC) to a raw AAC
+// frame.
+func (s *tsStream) adts(data []byte) ([]byte, error) {
+	size := 7 + len(data)
+	if size > 0x1FFF {
+		return nil, fmt.Errorf("%s: AAC frame of %d bytes too large for ADTS", s.name(), len(data))
+	}
+	header := []byte{
+		0xFF, 0xF1, // syncword, MPEG-4, layer 0, protection_absent
+		s.profile<<6 | s.samplingIndex<<2 | s.channels>>2,
+		(s.channels&0x03)<<6 | byte(size>>11),
+		byte(size >> 3),
+		byte(size&0x07)<<5 | 0x1F, // buffer fullness 0x7FF: variable bitrate
+		0xFC,                      // one raw data block
+	}
+	return append(header, data...), nil
+}
+
+// tsMuxer writes transport stream packets.
+type tsMuxer struct {
+	w          *bufio.Writer
+	streams    []*tsStream
+	pcr        *tsStream // stream whose packets carry the PCR
+	patCC      byte
+	pmtCC      byte
+	lastTables int64 // DTS when PAT and PMT were last written, -1 before
+}
+
+// writeTS writes the supported tracks of in to outputPath as an MPEG-2
+// transport stream with a single program. It returns the tracks written, in
+// PID order.
+func writeTS(in *remuxInput, outputPath string) ([]*remuxTrack, error) {
+	selected := in.selectTracks("MPEG-TS", func(fourCC string) bool { return tsCodecs[fourCC] != 0 })
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no tracks to remux")
+	}
+
+	m := &tsMuxer{lastTables: -1}
+	byInput := make(map[*remuxTrack]*tsStream, len(selected))
+	for i, t := range selected {
+		s, err := newTSStream(t, uint16(tsFirstPID+i))
+		if err != nil {
+			return nil, err
+		}
+		var adjusted int
+		s.dts, adjusted = t.decodeTimes(90000)
+		if adjusted > 0 {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %d samples with non-increasing DTS moved forward", t.name(), adjusted))
+		}
+		if m.pcr == nil && t.trackType == "video" {
+			m.pcr = s
+		}
+		m.streams = append(m.streams, s)
+		byInput[t] = s
+	}
+	if m.pcr == nil {
+		m.pcr = m.streams[0]
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating output: %w", err)
+	}
+	defer out.Close()
+
+	m.w = bufio.NewWriterSize(out, 1<<20)
+	err = in.walk(false, func(t *remuxTrack, sample remuxSample, data []byte, configured bool) error {
+		s := byInput[t]
+		if s.next >= len(s.samples) || s.samples[s.next].size != len(data) {
+			return fmt.Errorf("%s: input changed while remuxing", t.name())
+		}
+		i := s.next
+		s.next++
+
+		var payload []byte
+		var err error
+		switch s.fourCC {
+		case "avc1", "hvc1":
+			payload, err = s.annexB(data, s.samples[i].key)
+		case "mp4a":
+			payload, err = s.adts(data)
+		default:
+			payload = data
+		}
+		if err != nil {
+			return err
+		}
+
+		dts := s.dts[i]
+		key := s.trackType == "video" && s.samples[i].key
+		if m.lastTables < 0 || dts-m.lastTables >= tsTablePeriod || (key && s == m.pcr) {
+			if err := m.writeTables(); err != nil {
+				return err
+			}
+			m.lastTables = dts
+		}
+		pts := dts + int64(s.samples[i].cto)*90
+		return m.writePES(s, payload, pts+tsMuxDelay, dts+tsMuxDelay, dts, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.w.Flush(); err != nil {
+		return nil, fmt.Errorf("writing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("closing output: %w", err)
+	}
+	return selected, nil
+}
+
+// writeTables writes the PAT and the PMT.
+func (m *tsMuxer) writeTables() error {
+	pat := []byte{
+		0x00, 0x01, // transport_stream_id
+		0xC1, 0x00, 0x00, // version 0, current_next_indicator, section numbers
+		0x00, 0x01, // program_number
+		0xE0 | tsPMTPID>>8, tsPMTPID & 0xFF,
+	}
+	if err := m.writeSection(tsPATPID, &m.patCC, 0x00, pat); err != nil {
+		return err
+	}
+
+	pmt := []byte{
+		0x00, 0x01, // program_number
+		0xC1, 0x00, 0x00,
+		0xE0 | byte(m.pcr.pid>>8), byte(m.pcr.pid),
+		0xF0, 0x00, // program_info_length
+	}
+	for _, s := range m.streams {
+		pmt = append(pmt, s.streamType, 0xE0|byte(s.pid>>8), byte(s.pid), 0xF0, 0x00)
+	}
+	return m.writeSection(tsPMTPID, &m.pmtCC, 0x02, pmt)
+}
+
+// writeSection writes a PSI section that fits in a single packet. body is
+// the section after section_length, without the CRC.
+func (m *tsMuxer) writeSection(pid uint16, cc *byte, tableID byte, body []byte) error {
+	length := len(body) + 4                                          // CRC_32
+	section := []byte{tableID, 0xB0 | byte(length>>8), byte(length)} // section_syntax_indicator
+	section = append(section, body...)
+	section = binary.BigEndian.AppendUint32(section, mpegCRC32(section))
+
+	pkt := []byte{0x47, 0x40 | byte(pid>>8), byte(pid), 0x10 | *cc&0x0F, 0x00} // pointer_field
+	pkt = append(pkt, section...)
+	pkt = append(pkt, bytes.Repeat([]byte{0xFF}, tsPacketSize-len(pkt))...)
+	*cc++
+	return m.writePacket(pkt)
+}
+
+// writePES writes a frame as a PES packet. pts and dts are in 90 kHz units,
+// pcr is the program clock reference for the first packet when s carries
+// the PCR.
+func (m *tsMuxer) writePES(s *tsStream, payload []byte, pts, dts, pcr int64, key bool) error {
+	header := []byte{0x00, 0x00, 0x01, s.streamID, 0, 0, 0x80} // length filled in below; marker bits
+	if pts == dts {
+		header = append(header, 0x80, 5)
+		header = appendTimestamp(header, 0x2, pts)
+	} else {
+		header = append(header, 0xC0, 10)
+		header = appendTimestamp(header, 0x3, pts)
+		header = appendTimestamp(header, 0x1, dts)
+	}
+	// PES_packet_length 0 (unbounded) is only allowed for video.
+	if length := len(header) - 6 + len(payload); length <= 0xFFFF {
+		binary.BigEndian.PutUint16(header[4:], uint16(length))
+	}
+
+	if s != m.pcr {
+		pcr = -1
+	}
+	return m.writePackets(s, append(header, payload...), pcr, key)
+}
+
+// appendTimestamp appends a 33-bit PTS or DTS with its 4-bit prefix and
+// marker bits.
+func appendTimestamp(b []byte, prefix byte, ts int64) []byte {
+	ts &= 0x1FFFFFFFF
+	return append(b,
+		prefix<<4|byte(ts>>29)&0x0E|1,
+		byte(ts>>22),
+		byte(ts>>14)|1,
+		byte(ts>>7),
+		byte(ts<<1)|1,
+	)
+}
+
+// writePackets splits a PES packet into transport stream packets. The first
+// one carries the PCR (unless pcr is negative) and the random access
+// indicator; the last one is padded with adaptation field stuffing.
+func (m *tsMuxer) writePackets(s *tsStream, data []byte, pcr int64, randomAccess bool) error {
+	for first := true; len(data) > 0; first = false {
+		var af []byte // adaptation field after adaptation_field_length
+		if first && (pcr >= 0 || randomAccess) {
+			flags := byte(0)
+			if randomAccess {
+				flags |= 0x40
+			}
+			af = []byte{flags}
+			if pcr >= 0 {
+				af[0] |= 0x10
+				base := uint64(pcr) & 0x1FFFFFFFF
+				af = append(af, byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1), byte(base<<7)|0x7E, 0)
+			}
+		}
+		space := tsPacketSize - 4
+		if af != nil {
+			space -= 1 + len(af)
+		}
+		if len(data) < space {
+			stuffing := space - len(data)
+			if af == nil {
+				// The adaptation field length byte takes one of the bytes.
+				if stuffing--; stuffing > 0 {
+					af = []byte{0x00}
+					stuffing--
+				} else {
+					af = []byte{}
+				}
+			}
+			af = append(af, bytes.Repeat([]byte{0xFF}, stuffing)...)
+			space = len(data)
+		}
+
+		pkt := make([]byte, 0, tsPacketSize)
+		control := byte(0x10) // payload only
+		if af != nil {
+			control = 0x30 // adaptation field and payload
+		}
+		start := byte(0)
+		if first {
+			start = 0x40 // payload_unit_start_indicator
+		}
+		pkt = append(pkt, 0x47, start|byte(s.pid>>8), byte(s.pid), control|s.cc&0x0F)
+		if af != nil {
+			pkt = append(pkt, byte(len(af)))
+			pkt = append(pkt, af...)
+		}
+		pkt = append(pkt, data[:space]...)
+		data = data[space:]
+		s.cc++
+		if err := m.writePacket(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *tsMuxer) writePacket(pkt []byte) error {
+	if _, err := m.w.Write(pkt); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
+
+// mpegCRC32 computes the CRC-32 of PSI sections (polynomial 0x04C11DB7, not
+// reflected, initial value 0xFFFFFFFF).
+func mpegCRC32(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
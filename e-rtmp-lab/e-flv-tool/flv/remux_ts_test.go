@@ -0,0 +1,137 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+)
+
+// lengthPrefixed joins NAL units into a frame with 4-byte lengths.
+func lengthPrefixed(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = append(append(b, u32(uint32(len(nalu)))...), nalu...)
+	}
+	return b
+}
+
+// startCodes joins NAL units into an Annex B byte stream.
+func startCodes(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = append(append(b, 0, 0, 0, 1), nalu...)
+	}
+	return b
+}
+
+func TestTSAnnexB(t *testing.T) {
+	var (
+		sps   = []byte{0x67, 0x42, 0xC0, 0x1E, 0xAA}
+		pps   = []byte{0x68, 0xCE, 0x3C, 0x80}
+		idr   = []byte{0x65, 0x88, 0x84, 0x00}
+		slice = []byte{0x41, 0x9A, 0x02}
+		aud   = []byte{0x09, 0x10}
+		sei   = []byte{0x06, 0x05, 0x01, 0xFF}
+
+		vps       = []byte{0x40, 0x01, 0x0C}
+		hevcSPS   = []byte{0x42, 0x01, 0x01}
+		hevcPPS   = []byte{0x44, 0x01, 0xC1}
+		hevcIDR   = []byte{0x26, 0x01, 0xAF}
+		hevcTrail = []byte{0x02, 0x01, 0xD0}
+	)
+	avcC := amf0Bytes(0x01, 0x42, 0xC0, 0x1E, 0xFF, // version, profile, compatibility, level, lengthSizeMinusOne 3
+		0xE1, u16(uint16(len(sps))), sps, 0x01, u16(uint16(len(pps))), pps)
+	hvcC := make([]byte, 21)
+	hvcC[0] = 0x01
+	hvcC = append(hvcC, 0x0F, 3) // lengthSizeMinusOne 3, numOfArrays
+	for _, nalu := range [][]byte{vps, hevcSPS, hevcPPS} {
+		hvcC = append(hvcC, amf0Bytes(0x80|(nalu[0]>>1), u16(1), u16(uint16(len(nalu))), nalu)...)
+	}
+
+	tests := []struct {
+		name   string
+		fourCC string
+		frame  []byte
+		key    bool
+		want   []byte
+	}{
+		{"AVC keyframe gets the parameter sets", "avc1", lengthPrefixed(idr), true,
+			append(bytes.Clone(avcAccessUnitDelimiter), startCodes(sps, pps, idr)...)},
+		{"AVC keyframe keeps its own parameter sets", "avc1", lengthPrefixed(sei, sps, pps, idr), true,
+			append(bytes.Clone(avcAccessUnitDelimiter), startCodes(sei, sps, pps, idr)...)},
+		{"AVC delimiter replaced", "avc1", lengthPrefixed(aud, slice), false,
+			append(bytes.Clone(avcAccessUnitDelimiter), startCodes(slice)...)},
+		{"AVC empty NAL unit skipped", "avc1", append(u32(0), lengthPrefixed(slice)...), false,
+			append(bytes.Clone(avcAccessUnitDelimiter), startCodes(slice)...)},
+		{"HEVC keyframe gets the parameter sets", "hvc1", lengthPrefixed(hevcIDR), true,
+			append(bytes.Clone(hevcAccessUnitDelimiter), startCodes(vps, hevcSPS, hevcPPS, hevcIDR)...)},
+		{"HEVC non-keyframe", "hvc1", lengthPrefixed(hevcTrail), false,
+			append(bytes.Clone(hevcAccessUnitDelimiter), startCodes(hevcTrail)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := avcC
+			if tt.fourCC == "hvc1" {
+				config = hvcC
+			}
+			s, err := newTSStream(&remuxTrack{trackType: "video", fourCC: tt.fourCC, config: config}, 0x100)
+			if err != nil {
+				t.Fatalf("newTSStream: %v", err)
+			}
+			got, err := s.annexB(tt.frame, tt.key)
+			if err != nil {
+				t.Fatalf("annexB: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("annexB = % X, want % X", got, tt.want)
+			}
+		})
+	}
+
+	s, err := newTSStream(&remuxTrack{trackType: "video", fourCC: "avc1", config: avcC}, 0x100)
+	if err != nil {
+		t.Fatalf("newTSStream: %v", err)
+	}
+	for _, frame := range [][]byte{{0, 0, 1}, amf0Bytes(u32(9), slice)} {
+		if _, err := s.annexB(frame, false); err == nil {
+			t.Errorf("annexB(% X) succeeded, want error", frame)
+		}
+	}
+}
+
+func TestTSADTS(t *testing.T) {
+	tests := []struct {
+		name   string
+		config []byte // AudioSpecificConfig
+		want   []byte // ADTS header of a 10-byte frame
+	}{
+		// AAC LC, 44100 Hz (index 4), 2 channels; frame_length 17.
+		{"AAC LC", []byte{0x12, 0x10}, []byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x3F, 0xFC}},
+		// HE-AAC (SBR, object type 5) at 24000 Hz core (index 6), 1 channel,
+		// signalled as AAC LC.
+		{"HE-AAC", []byte{0x2B, 0x08}, []byte{0xFF, 0xF1, 0x58, 0x40, 0x02, 0x3F, 0xFC}},
+	}
+	frame := bytes.Repeat([]byte{0xAB}, 10)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newTSStream(&remuxTrack{trackType: "audio", fourCC: "mp4a", config: tt.config}, 0x101)
+			if err != nil {
+				t.Fatalf("newTSStream: %v", err)
+			}
+			got, err := s.adts(frame)
+			if err != nil {
+				t.Fatalf("adts: %v", err)
+			}
+			if want := append(bytes.Clone(tt.want), frame...); !bytes.Equal(got, want) {
+				t.Errorf("adts = % X, want % X", got, want)
+			}
+		})
+	}
+
+	s, err := newTSStream(&remuxTrack{trackType: "audio", fourCC: "mp4a", config: []byte{0x12, 0x10}}, 0x101)
+	if err != nil {
+		t.Fatalf("newTSStream: %v", err)
+	}
+	if _, err := s.adts(make([]byte, 0x1FFF)); err == nil {
+		t.Errorf("adts of an 8191-byte frame succeeded, want error")
+	}
+}
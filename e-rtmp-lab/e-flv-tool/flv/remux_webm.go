@@ -0,0 +1,502 @@
+package flv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// WebM (Matroska) output.
+
+// Matroska element IDs, with their marker bits.
+const (
+	ebmlHeaderID         = 0x1A45DFA3
+	ebmlVersionID        = 0x4286
+	ebmlReadVersionID    = 0x42F7
+	ebmlMaxIDLengthID    = 0x42F2
+	ebmlMaxSizeLengthID  = 0x42F3
+	ebmlDocTypeID        = 0x4282
+	ebmlDocTypeVersionID = 0x4287
+	ebmlDocTypeReadID    = 0x4285
+
+	mkvSegmentID        = 0x18538067
+	mkvSeekHeadID       = 0x114D9B74
+	mkvSeekID           = 0x4DBB
+	mkvSeekIDID         = 0x53AB
+	mkvSeekPositionID   = 0x53AC
+	mkvInfoID           = 0x1549A966
+	mkvTimestampScaleID = 0x2AD7B1
+	mkvDurationID       = 0x4489
+	mkvMuxingAppID      = 0x4D80
+	mkvWritingAppID     = 0x5741
+	mkvTracksID         = 0x1654AE6B
+	mkvTrackEntryID     = 0xAE
+	mkvTrackNumberID    = 0xD7
+	mkvTrackUIDID       = 0x73C5
+	mkvTrackTypeID      = 0x83
+	mkvFlagLacingID     = 0x9C
+	mkvCodecIDID        = 0x86
+	mkvCodecPrivateID   = 0x63A2
+	mkvCodecDelayID     = 0x56AA
+	mkvSeekPreRollID    = 0x56BB
+	mkvVideoID          = 0xE0
+	mkvPixelWidthID     = 0xB0
+	mkvPixelHeightID    = 0xBA
+	mkvAudioID          = 0xE1
+	mkvSamplingFreqID   = 0xB5
+	mkvChannelsID       = 0x9F
+	mkvClusterID        = 0x1F43B675
+	mkvTimestampID      = 0xE7
+	mkvSimpleBlockID    = 0xA3
+	mkvCuesID           = 0x1C53BB6B
+	mkvCuePointID       = 0xBB
+	mkvCueTimeID        = 0xB3
+	mkvCueTrackPosID    = 0xB7
+	mkvCueTrackID       = 0xF7
+	mkvCueClusterPosID  = 0xF1
+)
+
+// webmCodecs are the FourCCs that can be carried in WebM output, with their
+// Matroska codec ID.
+var webmCodecs = map[string]string{
+	"vp08": "V_VP8",
+	"vp09": "V_VP9",
+	"av01": "V_AV1",
+	"Opus": "A_OPUS",
+}
+
+const (
+	// webmClusterDuration is how long, in ms, a cluster of an audio-only file
+	// lasts. With video, clusters start at the keyframes of the first video
+	// track.
+	webmClusterDuration = 5000
+
+	// webmMaxClusterSpan is the largest timestamp of a block relative to its
+	// cluster (a signed 16-bit value in ms).
+	webmMaxClusterSpan = math.MaxInt16
+)
+
+// ebmlID returns the bytes of an element ID, which carries its own length
+// marker.
+func ebmlID(id uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	switch {
+	case id < 1<<8:
+		return b[3:]
+	case id < 1<<16:
+		return b[2:]
+	case id < 1<<24:
+		return b[1:]
+	}
+	return b
+}
+
+// ebmlSize returns the shortest variable-length encoding of an element data
+// size. All ones is reserved for unknown sizes, so 127 takes two bytes.
+func ebmlSize(n int64) []byte {
+	length := 1
+	for n >= 1<<(7*length)-1 {
+		length++
+	}
+	b := make([]byte, length)
+	v := uint64(n) | 1<<(7*length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+// ebmlHeaderSize returns the size of the ID and data size of an element with
+// size bytes of data.
+func ebmlHeaderSize(id uint32, size int64) int64 {
+	return int64(len(ebmlID(id)) + len(ebmlSize(size)))
+}
+
+// ebmlElement returns an element whose data is the concatenation of payload.
+func ebmlElement(id uint32, payload ...[]byte) []byte {
+	size := 0
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := append(ebmlID(id), ebmlSize(int64(size))...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// ebmlUint returns an unsigned integer element in as few bytes as possible.
+func ebmlUint(id uint32, v uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return ebmlElement(id, b)
+}
+
+func ebmlFloat(id uint32, v float64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func ebmlString(id uint32, s string) []byte {
+	return ebmlElement(id, []byte(s))
+}
+
+// webmTrack is a track of WebM output.
+type webmTrack struct {
+	*remuxTrack
+	number     uint64
+	timestamps []int64 // presentation time of every sample, ms from the start of the output
+	end        int64   // end of the last sample, ms
+}
+
+// webmBlock is a SimpleBlock: a sample of a track.
+type webmBlock struct {
+	track  *webmTrack
+	sample int
+}
+
+// webmCluster is a Cluster and the blocks it holds, in stream order.
+type webmCluster struct {
+	timestamp int64 // lowest block timestamp, ms
+	position  int64 // from the start of the Segment data
+	size      int64 // data size
+	blocks    []webmBlock
+	cues      []webmBlock // blocks indexed by the Cues
+}
+
+// newWebMTrack computes the block timestamps of an input track. origin is
+// time zero of the output in nanoseconds (see remuxOrigin).
+func newWebMTrack(t *remuxTrack, number uint64, origin int64) (*webmTrack, int) {
+	wt := &webmTrack{remuxTrack: t, number: number}
+	dts, adjusted := t.decodeTimes(1000)
+	durations := t.sampleDurations(dts, 1000)
+	start := scaleNanos(origin, 1000)
+	wt.timestamps = make([]int64, len(dts))
+	for i := range dts {
+		wt.timestamps[i] = max(dts[i]+int64(t.samples[i].cto)-start, 0)
+		wt.end = max(wt.end, wt.timestamps[i]+durations[i])
+	}
+	return wt, adjusted
+}
+
+// trackEntry returns the TrackEntry element of the track.
+func (wt *webmTrack) trackEntry() ([]byte, error) {
+	children := [][]byte{
+		ebmlUint(mkvTrackNumberID, wt.number),
+		ebmlUint(mkvTrackUIDID, wt.number),
+		ebmlUint(mkvFlagLacingID, 0),
+		ebmlString(mkvCodecIDID, webmCodecs[wt.fourCC]),
+	}
+	switch wt.fourCC {
+	case "av01":
+		// The whole AV1CodecConfigurationRecord, configOBUs included.
+		if len(wt.config) < 4 {
+			return nil, fmt.Errorf("%s: truncated AV1CodecConfigurationRecord", wt.name())
+		}
+		children = append(children, ebmlElement(mkvCodecPrivateID, wt.config))
+	case "vp09":
+		private, err := webmVP9CodecPrivate(wt.config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", wt.name(), err)
+		}
+		children = append(children, ebmlElement(mkvCodecPrivateID, private))
+	case "Opus":
+		if len(wt.config) < 19 || string(wt.config[:8]) != "OpusHead" {
+			return nil, fmt.Errorf("%s: invalid OpusHead", wt.name())
+		}
+		children = append(children,
+			ebmlElement(mkvCodecPrivateID, wt.config),
+			ebmlUint(mkvCodecDelayID, uint64(opusPreSkip(wt.config))*1e9/48000),
+			ebmlUint(mkvSeekPreRollID, 80e6), // 80 ms, RFC 7845 section 4.6
+		)
+	}
+
+	if wt.trackType == "video" {
+		children = append(children,
+			ebmlUint(mkvTrackTypeID, 1),
+			ebmlElement(mkvVideoID,
+				ebmlUint(mkvPixelWidthID, uint64(wt.width)),
+				ebmlUint(mkvPixelHeightID, uint64(wt.height)),
+			),
+		)
+	} else {
+		children = append(children,
+			ebmlUint(mkvTrackTypeID, 2),
+			ebmlElement(mkvAudioID,
+				ebmlFloat(mkvSamplingFreqID, float64(wt.sampleRate)),
+				ebmlUint(mkvChannelsID, uint64(max(wt.channels, 1))),
+			),
+		)
+	}
+	return ebmlElement(mkvTrackEntryID, children...), nil
+}
+
+// webmVP9CodecPrivate converts a VP9 codec configuration record (the vpcC
+// payload) into the VP9 CodecPrivate of WebM: profile, level, bit depth and
+// chroma subsampling as ID, length, value features.
+func webmVP9CodecPrivate(config []byte) ([]byte, error) {
+	if len(config) < 12 {
+		return nil, fmt.Errorf("truncated VPCodecConfigurationRecord")
+	}
+	return []byte{
+		1, 1, config[4], // profile
+		2, 1, config[5], // level
+		3, 1, config[6] >> 4, // bit depth
+		4, 1, (config[6] >> 1) & 0x07, // chroma subsampling
+	}, nil
+}
+
+// blockSize returns the data size of the SimpleBlock of a sample.
+func (b webmBlock) blockSize() int64 {
+	return int64(len(ebmlSize(int64(b.track.number)))+3) + int64(b.track.samples[b.sample].size)
+}
+
+// blockHeader returns the SimpleBlock element header and block header of a
+// sample; the frame follows.
+func (b webmBlock) blockHeader(cluster int64) []byte {
+	size := b.blockSize()
+	h := append(ebmlID(mkvSimpleBlockID), ebmlSize(size)...)
+	h = append(h, ebmlSize(int64(b.track.number))...)
+	h = binary.BigEndian.AppendUint16(h, uint16(int16(b.track.timestamps[b.sample]-cluster)))
+	flags := byte(0)
+	if b.track.samples[b.sample].key {
+		flags |= 0x80
+	}
+	return append(h, flags)
+}
+
+// writeWebM writes the supported tracks of in to outputPath as a WebM file:
+// an EBML header and a Segment with a SeekHead, Info (with the duration),
+// Tracks, the Clusters and Cues for the keyframes. It returns the tracks
+// written, in track number order.
+func writeWebM(in *remuxInput, outputPath string) ([]*remuxTrack, error) {
+	selected := in.selectTracks("WebM", func(fourCC string) bool { return webmCodecs[fourCC] != "" })
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no tracks to remux")
+	}
+
+	origin := remuxOrigin(selected)
+	tracks := make([]*webmTrack, len(selected))
+	byInput := make(map[*remuxTrack]*webmTrack, len(selected))
+	var ref *webmTrack // clusters start at its keyframes
+	var duration int64
+	for i, t := range selected {
+		wt, adjusted := newWebMTrack(t, uint64(i+1), origin)
+		if adjusted > 0 {
+			in.warnings = append(in.warnings, fmt.Sprintf("%s: %d samples with non-increasing DTS moved forward", t.name(), adjusted))
+		}
+		if ref == nil && t.trackType == "video" {
+			ref = wt
+		}
+		duration = max(duration, wt.end)
+		tracks[i] = wt
+		byInput[t] = wt
+	}
+
+	clusters := webmClusters(in, byInput, ref)
+
+	var entries [][]byte
+	for _, wt := range tracks {
+		entry, err := wt.trackEntry()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	info := ebmlElement(mkvInfoID,
+		ebmlUint(mkvTimestampScaleID, 1e6), // ms
+		ebmlFloat(mkvDurationID, float64(duration)),
+		ebmlString(mkvMuxingAppID, "eflv"),
+		ebmlString(mkvWritingAppID, "eflv"),
+	)
+	tracksElement := ebmlElement(mkvTracksID, entries...)
+
+	// The SeekHead has a fixed size (8-byte positions), so every position
+	// can be computed before anything is written.
+	cuesPosition := int64(-1) // no Cues
+	for _, c := range clusters {
+		if len(c.cues) > 0 {
+			cuesPosition = 0
+		}
+	}
+	seekHead := webmSeekHead(0, 0, cuesPosition)
+	pos := int64(len(seekHead) + len(info) + len(tracksElement))
+	for _, c := range clusters {
+		c.position = pos
+		pos += ebmlHeaderSize(mkvClusterID, c.size) + c.size
+	}
+	var cues []byte
+	if cuesPosition == 0 {
+		cuesPosition = pos
+		cues = webmCues(clusters)
+	}
+	seekHead = webmSeekHead(int64(len(seekHead)), int64(len(seekHead)+len(info)), cuesPosition)
+	segmentSize := pos + int64(len(cues))
+
+	header := ebmlElement(ebmlHeaderID,
+		ebmlUint(ebmlVersionID, 1),
+		ebmlUint(ebmlReadVersionID, 1),
+		ebmlUint(ebmlMaxIDLengthID, 4),
+		ebmlUint(ebmlMaxSizeLengthID, 8),
+		ebmlString(ebmlDocTypeID, "webm"),
+		ebmlUint(ebmlDocTypeVersionID, 4),
+		ebmlUint(ebmlDocTypeReadID, 2),
+	)
+	segment := append(ebmlID(mkvSegmentID), 0x01, 0, 0, 0, 0, 0, 0, 0) // 8-byte size
+	binary.BigEndian.PutUint64(segment[4:], uint64(segmentSize)|1<<56)
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating output: %w", err)
+	}
+	defer out.Close()
+
+	bw := bufio.NewWriterSize(out, 1<<20)
+	write := func(b []byte) error {
+		if _, err := bw.Write(b); err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		return nil
+	}
+	for _, b := range [][]byte{header, segment, seekHead, info, tracksElement} {
+		if err := write(b); err != nil {
+			return nil, err
+		}
+	}
+
+	next := make(map[*webmTrack]int, len(tracks))
+	ci, bi := 0, 0
+	err = in.walk(false, func(t *remuxTrack, s remuxSample, data []byte, configured bool) error {
+		wt := byInput[t]
+		i := next[wt]
+		next[wt]++
+		if ci >= len(clusters) {
+			return fmt.Errorf("%s: input changed while remuxing", t.name())
+		}
+		c := clusters[ci]
+		if b := c.blocks[bi]; b.track != wt || b.sample != i || wt.samples[i].size != len(data) {
+			return fmt.Errorf("%s: input changed while remuxing", t.name())
+		}
+		if bi == 0 {
+			h := append(ebmlID(mkvClusterID), ebmlSize(c.size)...)
+			if err := write(append(h, ebmlUint(mkvTimestampID, uint64(c.timestamp))...)); err != nil {
+				return err
+			}
+		}
+		if err := write(c.blocks[bi].blockHeader(c.timestamp)); err != nil {
+			return err
+		}
+		if err := write(data); err != nil {
+			return err
+		}
+		if bi++; bi == len(c.blocks) {
+			ci, bi = ci+1, 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ci != len(clusters) {
+		return nil, fmt.Errorf("input changed while remuxing")
+	}
+	if err := write(cues); err != nil {
+		return nil, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("writing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("closing output: %w", err)
+	}
+	return selected, nil
+}
+
+// webmClusters groups the samples into clusters, in stream order. A cluster
+// starts at a keyframe of ref unless it is the first block of ref in the
+// current cluster, after webmClusterDuration ms, and whenever a block
+// timestamp would not fit relative to the cluster's. The keyframes of ref
+// (every cluster start without video) are indexed by the Cues.
+func webmClusters(in *remuxInput, byInput map[*remuxTrack]*webmTrack, ref *webmTrack) []*webmCluster {
+	var clusters []*webmCluster
+	var c *webmCluster
+	var start, lo, hi int64 // first and range of block timestamps in c
+	var hasRef bool         // c has a block of ref
+	next := make(map[*webmTrack]int, len(byInput))
+	for _, t := range in.order {
+		wt := byInput[t]
+		if wt == nil {
+			continue
+		}
+		i := next[wt]
+		next[wt]++
+		ts := wt.timestamps[i]
+		keyframe := wt == ref && wt.samples[i].key
+
+		if c == nil || keyframe && hasRef || ts-start >= webmClusterDuration ||
+			max(hi, ts)-min(lo, ts) > webmMaxClusterSpan {
+			c = &webmCluster{}
+			clusters = append(clusters, c)
+			start, lo, hi, hasRef = ts, ts, ts, false
+			if ref == nil {
+				c.cues = append(c.cues, webmBlock{track: wt, sample: i})
+			}
+		}
+		if keyframe {
+			c.cues = append(c.cues, webmBlock{track: wt, sample: i})
+		}
+		hasRef = hasRef || wt == ref
+		lo, hi = min(lo, ts), max(hi, ts)
+		c.timestamp = lo
+		c.blocks = append(c.blocks, webmBlock{track: wt, sample: i})
+	}
+
+	for _, c := range clusters {
+		c.size = int64(len(ebmlUint(mkvTimestampID, uint64(c.timestamp))))
+		for _, b := range c.blocks {
+			size := b.blockSize()
+			c.size += ebmlHeaderSize(mkvSimpleBlockID, size) + size
+		}
+	}
+	return clusters
+}
+
+// webmSeekHead returns the SeekHead pointing at Info, Tracks and Cues, if
+// cues is not negative. Positions are relative to the start of the Segment
+// data and always take eight bytes.
+func webmSeekHead(info, tracks, cues int64) []byte {
+	seek := func(id uint32, pos int64) []byte {
+		return ebmlElement(mkvSeekID,
+			ebmlElement(mkvSeekIDID, ebmlID(id)),
+			ebmlElement(mkvSeekPositionID, binary.BigEndian.AppendUint64(nil, uint64(pos))),
+		)
+	}
+	seeks := [][]byte{seek(mkvInfoID, info), seek(mkvTracksID, tracks)}
+	if cues >= 0 {
+		seeks = append(seeks, seek(mkvCuesID, cues))
+	}
+	return ebmlElement(mkvSeekHeadID, seeks...)
+}
+
+// webmCues returns the Cues element with a CuePoint for every indexed
+// block, pointing at its cluster.
+func webmCues(clusters []*webmCluster) []byte {
+	var points [][]byte
+	for _, c := range clusters {
+		for _, b := range c.cues {
+			points = append(points, ebmlElement(mkvCuePointID,
+				ebmlUint(mkvCueTimeID, uint64(b.track.timestamps[b.sample])),
+				ebmlElement(mkvCueTrackPosID,
+					ebmlUint(mkvCueTrackID, b.track.number),
+					ebmlUint(mkvCueClusterPosID, uint64(c.position)),
+				),
+			))
+		}
+	}
+	return ebmlElement(mkvCuesID, points...)
+}
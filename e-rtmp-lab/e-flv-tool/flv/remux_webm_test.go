@@ -0,0 +1,184 @@
+package flv
+
+import (
+	"bytes"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEBMLSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x80}},
+		{126, []byte{0xFE}},
+		{127, []byte{0x40, 0x7F}}, // 0xFF would be the unknown size
+		{300, []byte{0x41, 0x2C}},
+		{16382, []byte{0x7F, 0xFE}},
+		{16383, []byte{0x20, 0x3F, 0xFF}},
+		{1 << 28, []byte{0x08, 0x10, 0x00, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		if got := ebmlSize(tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("ebmlSize(%d) = % X, want % X", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestEBMLElement(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"one-byte ID", ebmlUint(mkvTrackNumberID, 1), []byte{0xD7, 0x81, 0x01}},
+		{"two-byte ID", ebmlUint(ebmlVersionID, 1), []byte{0x42, 0x86, 0x81, 0x01}},
+		{"three-byte ID", ebmlUint(mkvTimestampScaleID, 1e6), []byte{0x2A, 0xD7, 0xB1, 0x83, 0x0F, 0x42, 0x40}},
+		{"four-byte ID", ebmlElement(mkvCuesID), []byte{0x1C, 0x53, 0xBB, 0x6B, 0x80}},
+		{"zero", ebmlUint(mkvTimestampID, 0), []byte{0xE7, 0x81, 0x00}},
+		{"string", ebmlString(ebmlDocTypeID, "webm"), []byte{0x42, 0x82, 0x84, 'w', 'e', 'b', 'm'}},
+		{"float", ebmlFloat(mkvDurationID, 1.5), []byte{0x44, 0x89, 0x88, 0x3F, 0xF8, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s: % X, want % X", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// testEBMLElement is an element read back from a WebM file.
+type testEBMLElement struct {
+	id         uint32
+	pos        int64 // of the ID
+	data, size int64 // position and size of the data
+}
+
+// readTestEBML reads the elements of data from pos to end.
+func readTestEBML(t *testing.T, data []byte, pos, end int64) []testEBMLElement {
+	t.Helper()
+	vint := func(keepMarker bool) uint64 {
+		if pos >= end {
+			t.Fatalf("element header at %d past the end", pos)
+		}
+		length := bits.LeadingZeros8(data[pos]) + 1
+		if length > 8 || pos+int64(length) > end {
+			t.Fatalf("invalid variable-length integer at %d", pos)
+		}
+		v := uint64(data[pos])
+		if !keepMarker {
+			v &= 0xFF >> length
+		}
+		for _, b := range data[pos+1 : pos+int64(length)] {
+			v = v<<8 | uint64(b)
+		}
+		pos += int64(length)
+		return v
+	}
+
+	var elements []testEBMLElement
+	for pos < end {
+		e := testEBMLElement{pos: pos}
+		e.id = uint32(vint(true))
+		e.size = int64(vint(false))
+		e.data = pos
+		if e.data+e.size > end {
+			t.Fatalf("element %X at %d: size %d past the end of its parent", e.id, e.pos, e.size)
+		}
+		elements = append(elements, e)
+		pos += e.size
+	}
+	return elements
+}
+
+// uintValue returns the value of an unsigned integer element.
+func (e testEBMLElement) uintValue(data []byte) uint64 {
+	var v uint64
+	for _, b := range data[e.data : e.data+e.size] {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func TestWebMCuesPositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.webm")
+	if err := RemuxFLV("../../assets/testsrc.flv", path, "webm", 0); err != nil {
+		t.Fatalf("RemuxFLV: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top := readTestEBML(t, data, 0, int64(len(data)))
+	if len(top) != 2 || top[0].id != ebmlHeaderID || top[1].id != mkvSegmentID {
+		t.Fatalf("top-level elements %v, want EBML header and Segment", top)
+	}
+	segment := top[1].data
+	if end := top[1].data + top[1].size; end != int64(len(data)) {
+		t.Fatalf("Segment ends at %d, file size %d", end, len(data))
+	}
+
+	// Positions are relative to the start of the Segment data.
+	children := map[int64]testEBMLElement{}
+	clusterTimestamps := map[int64]uint64{}
+	var seekHead, cues testEBMLElement
+	for _, e := range readTestEBML(t, data, segment, top[1].data+top[1].size) {
+		children[e.pos-segment] = e
+		switch e.id {
+		case mkvSeekHeadID:
+			seekHead = e
+		case mkvCuesID:
+			cues = e
+		case mkvClusterID:
+			// Every element size of the cluster must add up.
+			blocks := readTestEBML(t, data, e.data, e.data+e.size)
+			if len(blocks) == 0 || blocks[0].id != mkvTimestampID {
+				t.Fatalf("cluster at %d does not start with its Timestamp", e.pos)
+			}
+			clusterTimestamps[e.pos-segment] = blocks[0].uintValue(data)
+		}
+	}
+	if len(clusterTimestamps) == 0 || cues.size == 0 {
+		t.Fatalf("got %d clusters and Cues of %d bytes", len(clusterTimestamps), cues.size)
+	}
+
+	for _, seek := range readTestEBML(t, data, seekHead.data, seekHead.data+seekHead.size) {
+		fields := readTestEBML(t, data, seek.data, seek.data+seek.size)
+		if len(fields) != 2 || fields[0].id != mkvSeekIDID || fields[1].id != mkvSeekPositionID {
+			t.Fatalf("Seek at %d: unexpected children", seek.pos)
+		}
+		id := uint32(fields[0].uintValue(data))
+		position := int64(fields[1].uintValue(data))
+		if e, ok := children[position]; !ok || e.id != id {
+			t.Errorf("SeekHead: element %X at %d not found", id, position)
+		}
+	}
+
+	points := readTestEBML(t, data, cues.data, cues.data+cues.size)
+	if len(points) == 0 {
+		t.Fatalf("no CuePoints")
+	}
+	for _, point := range points {
+		fields := readTestEBML(t, data, point.data, point.data+point.size)
+		if len(fields) != 2 || fields[0].id != mkvCueTimeID || fields[1].id != mkvCueTrackPosID {
+			t.Fatalf("CuePoint at %d: unexpected children", point.pos)
+		}
+		cueTime := fields[0].uintValue(data)
+		var clusterPosition int64 = -1
+		for _, f := range readTestEBML(t, data, fields[1].data, fields[1].data+fields[1].size) {
+			if f.id == mkvCueClusterPosID {
+				clusterPosition = int64(f.uintValue(data))
+			}
+		}
+		timestamp, ok := clusterTimestamps[clusterPosition]
+		if !ok {
+			t.Fatalf("CuePoint at %d ms: no cluster at %d", cueTime, clusterPosition)
+		}
+		if timestamp > cueTime {
+			t.Errorf("CuePoint at %d ms points to a cluster starting at %d ms", cueTime, timestamp)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package flv
+
+import "fmt"
+
+// scriptData is a decoded script data tag: a method name followed by its
+// arguments.
+type scriptData struct {
+	tagIndex  uint64
+	timestamp uint32
+	amf3      bool   // tag type 15
+	wrapper   string // "@setDataFrame" or "@clearDataFrame" if the method was wrapped
+	method    string
+	args      []any
+}
+
+// onMetaData returns the properties of an onMetaData call, or nil if sd is
+// not one.
+func (sd *scriptData) onMetaData() []AMF0Property {
+	if sd.method != "onMetaData" || len(sd.args) == 0 {
+		return nil
+	}
+	switch v := sd.args[0].(type) {
+	case []AMF0Property:
+		return v
+	case AMF0ECMAArray:
+		return v.Properties
+	case AMF0TypedObject:
+		return v.Properties
+	}
+	return nil
+}
+
+// parseScriptData decodes the AMF0 values of a script tag payload (after the
+// format selector of tag type 15). The first value is the method name, the
+// rest are its arguments. Data frames set over RTMP with @setDataFrame (or
+// removed with @clearDataFrame) are unwrapped so that the handler name
+// becomes the method. On a decode error the values read so far are returned
+// along with the error.
+func parseScriptData(payload []byte) (*scriptData, error) {
+	sd := &scriptData{}
+	// References are resolved across all values of the tag.
+	d := &amf0Decoder{data: payload}
+	var values []any
+	var err error
+	for d.offset < len(payload) {
+		var v AMF0Value
+		v, err = d.readValue()
+		if err != nil {
+			switch v.Kind {
+			case AMF0KindObject, AMF0KindECMAArray, AMF0KindTypedObject, AMF0KindStrictArray:
+				values = append(values, v.Interface())
+			}
+			break
+		}
+		values = append(values, v.Interface())
+	}
+
+	if len(values) > 0 {
+		if method, ok := amf0StringValue(values[0]); ok {
+			sd.method = method
+			sd.args = values[1:]
+		} else {
+			sd.args = values
+			if err == nil {
+				err = fmt.Errorf("method name is not a string")
+			}
+		}
+	}
+	if sd.method == "@setDataFrame" || sd.method == "@clearDataFrame" {
+		if handler, ok := firstString(sd.args); ok {
+			sd.wrapper = sd.method
+			sd.method = handler
+			sd.args = sd.args[1:]
+		}
+	}
+	return sd, err
+}
+
+func firstString(values []any) (string, bool) {
+	if len(values) == 0 {
+		return "", false
+	}
+	s, ok := amf0StringValue(values[0])
+	return s, ok
+}
+
+// scriptPayload returns the AMF0 values of a script tag. AMF3 script tags
+// start with a format selector; 0 (AMF0) is the only one defined, and ok is
+// false for any other.
+func scriptPayload(tag *Tag) (payload []byte, ok bool) {
+	if tag.Type != TagTypeScriptAMF3 {
+		return tag.Data, true
+	}
+	if len(tag.Data) < 1 || tag.Data[0] != 0 {
+		return nil, false
+	}
+	return tag.Data[1:], true
+}
+
+// collectScriptData decodes a script tag into info. onMetaData calls are also
+// added to the metadata blocks. Problems are reported as warnings.
+func (info *fileInfo) collectScriptData(tag *Tag) {
+	amf3 := tag.Type == TagTypeScriptAMF3
+	payload, ok := scriptPayload(tag)
+	if !ok {
+		info.warnings = append(info.warnings,
+			fmt.Sprintf("AMF3 script tag #%d: unsupported format selector, skipping", info.totalTags))
+		return
+	}
+
+	sd, err := parseScriptData(payload)
+	if err != nil {
+		info.warnings = append(info.warnings, fmt.Sprintf("script tag #%d: %v", info.totalTags, err))
+	}
+	sd.tagIndex = info.totalTags
+	sd.timestamp = tag.Timestamp
+	sd.amf3 = amf3
+	info.scripts = append(info.scripts, sd)
+
+	if props := sd.onMetaData(); props != nil {
+		info.metadataBlocks = append(info.metadataBlocks, props)
+	}
+}
+
+func printScriptData(scripts []*scriptData) {
+	fmt.Printf("Script Data\n")
+	for _, sd := range scripts {
+		method := sd.method
+		if method == "" {
+			method = "(unknown)"
+		}
+		fmt.Printf("  tag #%d at %d ms: %s", sd.tagIndex, sd.timestamp, method)
+		if sd.wrapper != "" {
+			fmt.Printf(" (via %s)", sd.wrapper)
+		}
+		if sd.amf3 {
+			fmt.Printf(" [AMF3]")
+		}
+		fmt.Println()
+		if sd.onMetaData() != nil {
+			// Listed in full in the onMetaData section.
+			continue
+		}
+		for i, arg := range sd.args {
+			printAMF0Property(AMF0Property{Name: fmt.Sprintf("arg%d", i+1), Value: arg}, 2)
+		}
+	}
+}
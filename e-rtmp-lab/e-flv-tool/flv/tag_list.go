@@ -0,0 +1,243 @@
+package flv
+
+import "fmt"
+
+// tagEntry describes one tag for the verbose tag listing.
+type tagEntry struct {
+	index      uint64
+	offset     int64 // file offset of the tag header
+	tagType    TagType
+	dataSize   int
+	timestamp  uint32 // 32-bit DTS in ms (TimestampExtended in the upper 8 bits)
+	streamID   uint32
+	hasCTO     bool
+	cto        int32 // composition time offset in ms, valid if hasCTO
+	hasPTS     bool
+	pts        int64 // DTS + CTO for coded video frames, valid if hasPTS
+	hasNano    bool
+	nanoOffset uint32 // ModEx TimestampOffsetNano, valid if hasNano
+	frameType  string // VideoFrameType name, video only
+	codec      string // FourCC, or legacy codec name and ID
+	packetType string // E-RTMP (or legacy AVC/AAC) packet type name
+}
+
+// VideoFrameType names.
+var videoFrameTypeNames = map[byte]string{
+	1: "KeyFrame",
+	2: "InterFrame",
+	3: "DisposableInterFrame",
+	4: "GeneratedKeyFrame",
+	5: "Command",
+}
+
+// VideoPacketType names.
+var videoPacketTypeNames = map[byte]string{
+	0: "SequenceStart",
+	1: "CodedFrames",
+	2: "SequenceEnd",
+	3: "CodedFramesX",
+	4: "Metadata",
+	5: "MPEG2TSSequenceStart",
+	6: "Multitrack",
+	7: "ModEx",
+}
+
+// AudioPacketType names.
+var audioPacketTypeNames = map[byte]string{
+	0: "SequenceStart",
+	1: "CodedFrames",
+	2: "SequenceEnd",
+	4: "MultichannelConfig",
+	5: "Multitrack",
+	7: "ModEx",
+}
+
+// Legacy VideoCodecId names.
+var videoCodecNames = map[byte]string{
+	2: "SorensonH263",
+	3: "Screen",
+	4: "On2VP6",
+	5: "On2VP6A",
+	6: "ScreenV2",
+	7: "AVC",
+}
+
+// Legacy SoundFormat names.
+var soundFormatNames = map[byte]string{
+	0:  "LPcmPlatformEndian",
+	1:  "AdPcm",
+	2:  "Mp3",
+	3:  "LPcmLittleEndian",
+	4:  "Nellymoser16KMono",
+	5:  "Nellymoser8KMono",
+	6:  "Nellymoser",
+	7:  "G711ALaw",
+	8:  "G711MuLaw",
+	10: "Aac",
+	11: "Speex",
+	14: "Mp3_8K",
+	15: "Native",
+}
+
+// Legacy AVCPacketType and AACPacketType names.
+var (
+	avcPacketTypeNames = map[byte]string{0: "SequenceHeader", 1: "NALU", 2: "EndOfSequence"}
+	aacPacketTypeNames = map[byte]string{0: "SequenceHeader", 1: "Raw"}
+)
+
+// enumName returns names[v], or "Reserved(v)" if v has no name.
+func enumName(names map[byte]string, v byte) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reserved(%d)", v)
+}
+
+func (t TagType) String() string {
+	switch t {
+	case TagTypeAudio:
+		return "audio"
+	case TagTypeVideo:
+		return "video"
+	case TagTypeScript:
+		return "script"
+	case TagTypeScriptAMF3:
+		return "amf3"
+	default:
+		return fmt.Sprintf("type %d", byte(t))
+	}
+}
+
+// readSI24 decodes a big-endian signed 24-bit integer.
+func readSI24(b []byte) int32 {
+	return int32(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8) >> 8
+}
+
+// describeTag fills the header-derived fields of e from the tag payload.
+func describeTag(e *tagEntry, data []byte) {
+	switch e.tagType {
+	case TagTypeVideo:
+		describeVideoTag(e, data)
+	case TagTypeAudio:
+		describeAudioTag(e, data)
+	}
+}
+
+func describeVideoTag(e *tagEntry, data []byte) {
+	if len(data) < 1 {
+		return
+	}
+
+	// Header fields are reported even if the rest of the packet cannot be
+	// decoded.
+	pkt, err := ParseVideoPacket(data)
+	if data[0]&0x80 == 0 {
+		// Legacy: [FrameType(4)|CodecID(4)] [AvcPacketType(1)] [CTO(3)]
+		codecID := data[0] & 0x0F
+		e.frameType = enumName(videoFrameTypeNames, data[0]>>4)
+		e.codec = fmt.Sprintf("%s(%d)", enumName(videoCodecNames, codecID), codecID)
+		if err == nil && codecID == videoCodecIDAVC && !pkt.HasCommand {
+			e.packetType = enumName(avcPacketTypeNames, data[1])
+		}
+	} else {
+		packetType := data[0] & 0x0F
+		e.packetType = enumName(videoPacketTypeNames, packetType)
+		if err == nil {
+			e.packetType = packetTypeChain(videoPacketTypeNames, len(pkt.ModEx) > 0, pkt.Multitrack,
+				byte(videoPacketTypeMultitrack), byte(pkt.PacketType))
+			e.hasNano, e.nanoOffset = pkt.HasTimestampOffsetNano, pkt.TimestampOffsetNano
+			packetType = byte(pkt.PacketType)
+		}
+		if packetType != videoPacketTypeMetadata {
+			// FrameType is ignored for Metadata packets.
+			e.frameType = enumName(videoFrameTypeNames, (data[0]>>4)&0x07)
+		}
+	}
+	if err != nil || len(pkt.Tracks) == 0 {
+		return
+	}
+
+	// For multitrack packets the codec and timing shown are those of the
+	// first track.
+	track := pkt.Tracks[0]
+	if pkt.Enhanced {
+		e.codec = track.FourCC
+	}
+	e.hasCTO = track.HasCTO
+	e.cto = track.CompositionTimeOffset
+	if pkt.PacketType == VideoPacketTypeCodedFrames || pkt.PacketType == VideoPacketTypeCodedFramesX {
+		e.hasPTS = true
+		e.pts = track.PTS(e.timestamp)
+	}
+}
+
+func describeAudioTag(e *tagEntry, data []byte) {
+	if len(data) < 1 {
+		e.packetType = "Silence"
+		return
+	}
+
+	pkt, err := ParseAudioPacket(data)
+	soundFormat := data[0] >> 4
+	if soundFormat != soundFormatExAudio {
+		e.codec = fmt.Sprintf("%s(%d)", enumName(soundFormatNames, soundFormat), soundFormat)
+		if err == nil && soundFormat == soundFormatAAC {
+			e.packetType = enumName(aacPacketTypeNames, data[1])
+		}
+		return
+	}
+
+	e.packetType = enumName(audioPacketTypeNames, data[0]&0x0F)
+	if err != nil {
+		return
+	}
+	e.packetType = packetTypeChain(audioPacketTypeNames, len(pkt.ModEx) > 0, pkt.Multitrack,
+		byte(audioPacketTypeMultitrack), byte(pkt.PacketType))
+	e.hasNano, e.nanoOffset = pkt.HasTimestampOffsetNano, pkt.TimestampOffsetNano
+	if len(pkt.Tracks) > 0 {
+		// For multitrack packets the codec shown is that of the first track.
+		e.codec = pkt.Tracks[0].FourCC
+	}
+}
+
+// packetTypeChain names the packet types of an enhanced tag in bitstream
+// order, e.g. "ModEx/Multitrack/CodedFrames".
+func packetTypeChain(names map[byte]string, modEx, multitrack bool, multitrackPacketType, packetType byte) string {
+	var chain string
+	if modEx {
+		chain += "ModEx/"
+	}
+	if multitrack {
+		chain += enumName(names, multitrackPacketType) + "/"
+	}
+	return chain + enumName(names, packetType)
+}
+
+func printTagList(entries []tagEntry) {
+	fmt.Printf("Tag List\n")
+	fmt.Printf("  %6s  %10s  %-6s  %8s  %10s  %6s  %10s  %-20s  %-16s  %s\n",
+		"#", "Offset", "Type", "Size", "DTS", "CTO", "PTS", "Frame", "Codec", "Packet")
+	for _, e := range entries {
+		cto, pts := "-", "-"
+		if e.hasCTO {
+			cto = fmt.Sprint(e.cto)
+		}
+		if e.hasPTS {
+			pts = fmt.Sprint(e.pts)
+		}
+		packet := orDash(e.packetType)
+		if e.hasNano {
+			packet += fmt.Sprintf(" (TimestampOffsetNano %d)", e.nanoOffset)
+		}
+		fmt.Printf("  %6d  %10d  %-6s  %8d  %10d  %6s  %10s  %-20s  %-16s  %s\n",
+			e.index, e.offset, e.tagType, e.dataSize, e.timestamp, cto, pts,
+			orDash(e.frameType), orDash(e.codec), packet)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
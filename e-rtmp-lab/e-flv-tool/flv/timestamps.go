@@ -0,0 +1,466 @@
+package flv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// avDriftWindow is the length of the windows, in milliseconds of audio DTS,
+// over which the A/V skew is averaged to measure drift.
+const avDriftWindow = 10000
+
+// tsTrack collects the timestamp statistics of one audio or video track.
+// Only CodedFrames packets are checked: sequence headers usually share the
+// DTS of the first frame.
+type tsTrack struct {
+	trackType string // "audio" or "video"
+	trackID   byte
+	codec     string
+
+	frames      uint64
+	firstTS     uint32
+	lastTS      uint32
+	maxDelta    uint32 // largest forward step between two frames
+	regressions int
+	gaps        int
+	duplicates  int
+	wraps       int
+}
+
+// tsProblem is one timestamp problem, located at the tag where it was found.
+type tsProblem struct {
+	tagIndex  uint64
+	offset    int64
+	timestamp uint32
+	kind      string // "regression", "gap", "duplicate", "wraparound", "avOffset" or "avDrift"
+	message   string
+}
+
+// avWindow accumulates the A/V skew samples of one drift window.
+type avWindow struct {
+	tagIndex uint64 // first sample of the window
+	offset   int64
+	start    uint32 // audio DTS of the first sample
+	end      uint32 // audio DTS of the last sample
+	sum      float64
+	samples  int
+}
+
+func (w avWindow) skew() float64 {
+	return w.sum / float64(w.samples)
+}
+
+// avSync compares audio and video track 0. The skew is the difference
+// between the DTS of an audio frame and the DTS of the last video frame
+// before it in the stream; averaged over windows it shows whether the audio
+// timeline drifts away from the video timeline.
+type avSync struct {
+	videoFirst, audioFirst uint32
+	videoLast              uint32
+	videoSeen, audioSeen   bool
+	windows                []avWindow
+}
+
+// startOffset returns how much later (in ms) audio starts than video.
+func (s *avSync) startOffset() int64 {
+	return int64(s.audioFirst) - int64(s.videoFirst)
+}
+
+// drift returns the change of the average skew from the first to the last
+// window, and the largest change from the first window of any window.
+func (s *avSync) drift() (end, peak float64) {
+	if len(s.windows) == 0 {
+		return 0, 0
+	}
+	first := s.windows[0].skew()
+	for _, w := range s.windows {
+		if d := w.skew() - first; math.Abs(d) > math.Abs(peak) {
+			peak = d
+		}
+	}
+	return s.windows[len(s.windows)-1].skew() - first, peak
+}
+
+type tsCheck struct {
+	maxGap      uint32
+	maxAVOffset uint32
+	tracks      []*tsTrack
+	av          avSync
+	problems    []tsProblem
+}
+
+// TimestampsFLV checks the DTS of the audio and video frames of an FLV/E-FLV
+// file, per track: timestamps that go back, gaps larger than maxGap ms,
+// duplicate timestamps, and 24-bit timestamps that wrap around without
+// TimestampExtended being incremented (or the reverse). It also measures the
+// start offset and drift between audio and video track 0 and reports them as
+// problems if they exceed maxAVOffset ms. If any problem is found, the report
+// is printed and an error is returned.
+func TimestampsFLV(inputPath string, jsonOutput bool, maxGap, maxAVOffset uint32) error {
+	c, err := readTimestamps(inputPath, maxGap, maxAVOffset)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		if err := printTimestampsJSON(inputPath, c); err != nil {
+			return err
+		}
+	} else {
+		printTimestampsText(inputPath, c)
+	}
+	if n := len(c.problems); n > 0 {
+		return fmt.Errorf("%d timestamp problem(s) found", n)
+	}
+	return nil
+}
+
+func readTimestamps(inputPath string, maxGap, maxAVOffset uint32) (*tsCheck, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tsCheck{maxGap: maxGap, maxAVOffset: maxAVOffset}
+	var tagIndex uint64
+	for {
+		tag, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tagIndex++
+
+		switch tag.Type {
+		case TagTypeVideo:
+			pkt, err := ParseVideoPacket(tag.Data)
+			if err != nil {
+				continue
+			}
+			if pkt.PacketType != VideoPacketTypeCodedFrames && pkt.PacketType != VideoPacketTypeCodedFramesX {
+				continue
+			}
+			for _, vt := range pkt.Tracks {
+				codec := vt.FourCC
+				if codec == "" {
+					codec = enumName(videoCodecNames, pkt.CodecID)
+				}
+				c.addFrame(c.track("video", vt.TrackID, codec), tagIndex, tag)
+			}
+		case TagTypeAudio:
+			pkt, err := ParseAudioPacket(tag.Data)
+			if err != nil {
+				continue
+			}
+			if pkt.PacketType != AudioPacketTypeCodedFrames {
+				continue
+			}
+			for _, at := range pkt.Tracks {
+				codec := at.FourCC
+				if codec == "" {
+					codec = enumName(soundFormatNames, pkt.SoundFormat)
+				}
+				c.addFrame(c.track("audio", at.TrackID, codec), tagIndex, tag)
+			}
+		}
+	}
+	c.av.finish()
+	c.checkAVSync()
+	return c, nil
+}
+
+func (c *tsCheck) track(trackType string, trackID byte, codec string) *tsTrack {
+	for _, t := range c.tracks {
+		if t.trackType == trackType && t.trackID == trackID {
+			return t
+		}
+	}
+	t := &tsTrack{trackType: trackType, trackID: trackID, codec: codec}
+	c.tracks = append(c.tracks, t)
+	return t
+}
+
+func (c *tsCheck) addProblem(tagIndex uint64, tag *Tag, kind, format string, args ...any) {
+	c.problems = append(c.problems, tsProblem{
+		tagIndex:  tagIndex,
+		offset:    tag.Offset,
+		timestamp: tag.Timestamp,
+		kind:      kind,
+		message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// isWrap reports whether a step of d ms is 2^24 ms give or take tolerance,
+// the size of a jump caused by a missing or spurious TimestampExtended
+// increment.
+func isWrap(d, tolerance uint32) bool {
+	const wrap = 1 << 24
+	return d >= wrap-tolerance && d <= wrap+tolerance
+}
+
+func (c *tsCheck) addFrame(t *tsTrack, tagIndex uint64, tag *Tag) {
+	ts := tag.Timestamp
+	name := fmt.Sprintf("%s track %d", t.trackType, t.trackID)
+	wrapped := false
+	if t.frames == 0 {
+		t.firstTS = ts
+	} else {
+		prev := t.lastTS
+		switch {
+		case ts < prev && isWrap(prev-ts, c.maxGap):
+			wrapped = true
+			t.wraps++
+			c.addProblem(tagIndex, tag, "wraparound",
+				"%s: DTS wraps from %d to %d ms; the 24-bit timestamp overflowed without incrementing TimestampExtended", name, prev, ts)
+		case ts < prev:
+			t.regressions++
+			c.addProblem(tagIndex, tag, "regression", "%s: DTS goes back from %d to %d ms (-%d ms)", name, prev, ts, prev-ts)
+		case ts > prev && isWrap(ts-prev, c.maxGap):
+			wrapped = true
+			t.wraps++
+			c.addProblem(tagIndex, tag, "wraparound",
+				"%s: DTS jumps from %d to %d ms; TimestampExtended was incremented without the 24-bit timestamp wrapping", name, prev, ts)
+		case ts == prev:
+			t.duplicates++
+			c.addProblem(tagIndex, tag, "duplicate", "%s: DTS %d ms repeats", name, ts)
+		default:
+			d := ts - prev
+			if d > t.maxDelta {
+				t.maxDelta = d
+			}
+			if d > c.maxGap {
+				t.gaps++
+				c.addProblem(tagIndex, tag, "gap", "%s: gap of %d ms (DTS %d to %d ms)", name, d, prev, ts)
+			}
+		}
+	}
+	t.frames++
+	t.lastTS = ts
+
+	// A frame right after a broken wraparound would skew the A/V
+	// measurements by 2^24 ms.
+	if t.trackID == 0 && !wrapped {
+		c.av.add(t.trackType, tagIndex, tag)
+	}
+}
+
+func (s *avSync) add(trackType string, tagIndex uint64, tag *Tag) {
+	ts := tag.Timestamp
+	if trackType == "video" {
+		if !s.videoSeen {
+			s.videoSeen = true
+			s.videoFirst = ts
+		}
+		s.videoLast = ts
+		return
+	}
+	if !s.audioSeen {
+		s.audioSeen = true
+		s.audioFirst = ts
+	}
+	if !s.videoSeen || ts < s.audioFirst {
+		return
+	}
+	skew := float64(int64(ts) - int64(s.videoLast))
+	i := int((ts - s.audioFirst) / avDriftWindow)
+	if n := len(s.windows); n == 0 || i > int((s.windows[n-1].start-s.audioFirst)/avDriftWindow) {
+		s.windows = append(s.windows, avWindow{tagIndex: tagIndex, offset: tag.Offset, start: ts})
+	}
+	w := &s.windows[len(s.windows)-1]
+	w.end = ts
+	w.sum += skew
+	w.samples++
+}
+
+// finish merges a last window that covers less than half a window into the
+// previous one, so that a few trailing samples do not count as drift.
+func (s *avSync) finish() {
+	n := len(s.windows)
+	if n < 2 || s.windows[n-1].end-s.windows[n-1].start >= avDriftWindow/2 {
+		return
+	}
+	last := s.windows[n-1]
+	prev := &s.windows[n-2]
+	prev.end = last.end
+	prev.sum += last.sum
+	prev.samples += last.samples
+	s.windows = s.windows[:n-1]
+}
+
+// checkAVSync reports the A/V start offset and the first window whose skew
+// has drifted by more than maxAVOffset.
+func (c *tsCheck) checkAVSync() {
+	s := &c.av
+	if !s.videoSeen || !s.audioSeen {
+		return
+	}
+	limit := float64(c.maxAVOffset)
+	if off := s.startOffset(); math.Abs(float64(off)) > limit {
+		c.problems = append(c.problems, tsProblem{
+			timestamp: s.audioFirst,
+			kind:      "avOffset",
+			message:   fmt.Sprintf("audio starts %+d ms from video (audio %d ms, video %d ms)", off, s.audioFirst, s.videoFirst),
+		})
+	}
+	if len(s.windows) == 0 {
+		return
+	}
+	first := s.windows[0].skew()
+	for _, w := range s.windows[1:] {
+		if d := w.skew() - first; math.Abs(d) > limit {
+			c.problems = append(c.problems, tsProblem{
+				tagIndex:  w.tagIndex,
+				offset:    w.offset,
+				timestamp: w.start,
+				kind:      "avDrift",
+				message:   fmt.Sprintf("A/V skew drifted by %+.1f ms (from %.1f ms to %.1f ms)", d, first, w.skew()),
+			})
+			break
+		}
+	}
+}
+
+func printTimestampsText(inputPath string, c *tsCheck) {
+	fmt.Printf("File: %s\n", inputPath)
+	fmt.Printf("Thresholds: gap %d ms, A/V offset %d ms\n", c.maxGap, c.maxAVOffset)
+
+	fmt.Println()
+	fmt.Printf("Timestamps\n")
+	if len(c.tracks) == 0 {
+		fmt.Printf("  No audio or video frames\n")
+	} else {
+		fmt.Printf("  %-5s  %5s  %-12s  %8s  %10s  %10s  %9s  %11s  %4s  %10s  %5s\n",
+			"Type", "Track", "Codec", "Frames", "First DTS", "Last DTS", "Max delta", "Regressions", "Gaps", "Duplicates", "Wraps")
+		for _, t := range c.tracks {
+			fmt.Printf("  %-5s  %5d  %-12s  %8d  %10d  %10d  %9d  %11d  %4d  %10d  %5d\n",
+				t.trackType, t.trackID, orDash(t.codec), t.frames, t.firstTS, t.lastTS, t.maxDelta,
+				t.regressions, t.gaps, t.duplicates, t.wraps)
+		}
+	}
+
+	if s := &c.av; s.videoSeen && s.audioSeen {
+		fmt.Println()
+		fmt.Printf("A/V Sync (track 0)\n")
+		fmt.Printf("  Start offset: %+d ms (audio %d ms, video %d ms)\n", s.startOffset(), s.audioFirst, s.videoFirst)
+		if len(s.windows) > 0 {
+			end, peak := s.drift()
+			fmt.Printf("  Skew:         %.1f ms at start, %.1f ms at end\n", s.windows[0].skew(), s.windows[len(s.windows)-1].skew())
+			fmt.Printf("  Drift:        %+.1f ms (max %+.1f ms over %d s windows)\n", end, peak, avDriftWindow/1000)
+		}
+	}
+
+	fmt.Println()
+	if len(c.problems) == 0 {
+		fmt.Printf("Problems: none\n")
+		return
+	}
+	fmt.Printf("Problems: %d\n", len(c.problems))
+	for _, p := range c.problems {
+		if p.tagIndex == 0 {
+			fmt.Printf("  %s\n", p.message)
+			continue
+		}
+		fmt.Printf("  tag #%d at offset %d: %s\n", p.tagIndex, p.offset, p.message)
+	}
+}
+
+// timestampReport is the document printed by `eflv timestamps --json`.
+type timestampReport struct {
+	File        string                   `json:"file"`
+	MaxGap      uint32                   `json:"maxGap"`
+	MaxAVOffset uint32                   `json:"maxAvOffset"`
+	Tracks      []timestampReportTrack   `json:"tracks"`
+	AVSync      *timestampReportAVSync   `json:"avSync"` // null unless audio and video track 0 have frames
+	Problems    []timestampReportProblem `json:"problems"`
+}
+
+type timestampReportTrack struct {
+	TrackType   string `json:"trackType"`
+	TrackID     byte   `json:"trackId"`
+	Codec       string `json:"codec"`
+	Frames      uint64 `json:"frames"`
+	FirstDTS    uint32 `json:"firstDts"`
+	LastDTS     uint32 `json:"lastDts"`
+	MaxDelta    uint32 `json:"maxDelta"`
+	Regressions int    `json:"regressions"`
+	Gaps        int    `json:"gaps"`
+	Duplicates  int    `json:"duplicates"`
+	Wraps       int    `json:"wraps"`
+}
+
+type timestampReportAVSync struct {
+	StartOffset int64    `json:"startOffset"` // audio first DTS - video first DTS, ms
+	StartSkew   *float64 `json:"startSkew"`   // null without skew samples
+	EndSkew     *float64 `json:"endSkew"`
+	Drift       float64  `json:"drift"`
+	MaxDrift    float64  `json:"maxDrift"`
+}
+
+type timestampReportProblem struct {
+	Index   uint64 `json:"index,omitempty"` // omitted for whole-stream problems
+	Offset  int64  `json:"offset,omitempty"`
+	DTS     uint32 `json:"dts"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func printTimestampsJSON(inputPath string, c *tsCheck) error {
+	report := timestampReport{
+		File:        inputPath,
+		MaxGap:      c.maxGap,
+		MaxAVOffset: c.maxAVOffset,
+		Tracks:      []timestampReportTrack{},
+		Problems:    []timestampReportProblem{},
+	}
+	for _, t := range c.tracks {
+		report.Tracks = append(report.Tracks, timestampReportTrack{
+			TrackType:   t.trackType,
+			TrackID:     t.trackID,
+			Codec:       t.codec,
+			Frames:      t.frames,
+			FirstDTS:    t.firstTS,
+			LastDTS:     t.lastTS,
+			MaxDelta:    t.maxDelta,
+			Regressions: t.regressions,
+			Gaps:        t.gaps,
+			Duplicates:  t.duplicates,
+			Wraps:       t.wraps,
+		})
+	}
+	if s := &c.av; s.videoSeen && s.audioSeen {
+		av := &timestampReportAVSync{StartOffset: s.startOffset()}
+		if len(s.windows) > 0 {
+			start, end := s.windows[0].skew(), s.windows[len(s.windows)-1].skew()
+			av.StartSkew, av.EndSkew = &start, &end
+			av.Drift, av.MaxDrift = s.drift()
+		}
+		report.AVSync = av
+	}
+	for _, p := range c.problems {
+		report.Problems = append(report.Problems, timestampReportProblem{
+			Index:   p.tagIndex,
+			Offset:  p.offset,
+			DTS:     p.timestamp,
+			Kind:    p.kind,
+			Message: p.message,
+		})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encoding JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package flv
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// metadataTrack describes one audio or video track as advertised by
+// onMetaData: trackId 0 by the top-level fields and additional tracks by
+// audioTrackIdInfoMap / videoTrackIdInfoMap. Map entries may list only the
+// properties that differ from the top-level fields (delta style); missing
+// properties are taken from the top level. Zero values mean "not given".
+type metadataTrack struct {
+	trackType  string // "audio" or "video"
+	trackID    byte
+	codec      string // FourCC, or the legacy codec name if it has none
+	fourCC     string // FourCC used to compare with the tags, "" if unknown
+	bitrate    float64
+	width      float64
+	height     float64
+	frameRate  float64
+	sampleRate float64
+	channels   float64
+	language   string
+}
+
+// trackMapKeys are the onMetaData property names read for each media type.
+var trackMapKeys = map[string]struct {
+	infoMap, codecID, bitrate string
+}{
+	"video": {"videoTrackIdInfoMap", "videocodecid", "videodatarate"},
+	"audio": {"audioTrackIdInfoMap", "audiocodecid", "audiodatarate"},
+}
+
+// collectMetadataTracks builds the track table from the first onMetaData
+// block if it has a track map, and checks it against the tracks seen in the
+// tags. Media types that have neither a track map nor tags with a trackId
+// other than 0 are not checked.
+func (info *fileInfo) collectMetadataTracks() {
+	if len(info.metadataBlocks) == 0 {
+		return
+	}
+	meta := info.metadataBlocks[0]
+
+	hasMap := map[string]bool{}
+	for _, trackType := range []string{"video", "audio"} {
+		keys := trackMapKeys[trackType]
+		v, ok := amf0Lookup(meta, keys.infoMap)
+		if !ok {
+			continue
+		}
+		entries, ok := amf0Properties(v)
+		if !ok {
+			info.warnings = append(info.warnings, fmt.Sprintf("onMetaData %s is not an object", keys.infoMap))
+			continue
+		}
+		hasMap[trackType] = true
+		var tracks []metadataTrack
+		if t, ok := metadataTrackFrom(trackType, 0, meta, nil); ok {
+			tracks = append(tracks, t)
+		}
+		for _, e := range entries {
+			id, err := strconv.ParseUint(e.Name, 10, 8)
+			if err != nil {
+				info.warnings = append(info.warnings, fmt.Sprintf("onMetaData %s: invalid trackId %q", keys.infoMap, e.Name))
+				continue
+			}
+			props, ok := amf0Properties(e.Value)
+			if !ok {
+				info.warnings = append(info.warnings, fmt.Sprintf("onMetaData %s: track %s is not an object", keys.infoMap, e.Name))
+				continue
+			}
+			if id == 0 {
+				info.warnings = append(info.warnings, fmt.Sprintf("onMetaData %s: track 0 is described by the top-level fields", keys.infoMap))
+			}
+			t, _ := metadataTrackFrom(trackType, byte(id), props, meta)
+			tracks = append(tracks, t)
+		}
+		sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].trackID < tracks[j].trackID })
+		info.metadataTracks = append(info.metadataTracks, tracks...)
+	}
+
+	for _, trackType := range []string{"video", "audio"} {
+		multitrack := false
+		for _, s := range info.tracks {
+			if s.trackType == trackType && s.trackID != 0 {
+				multitrack = true
+			}
+		}
+		if !hasMap[trackType] && !multitrack {
+			continue
+		}
+		info.checkMetadataTracks(trackType, meta)
+	}
+}
+
+// checkMetadataTracks warns about tracks advertised in onMetaData that no tag
+// carries, tracks carried by tags that onMetaData does not describe, and
+// codecs that differ.
+func (info *fileInfo) checkMetadataTracks(trackType string, meta []AMF0Property) {
+	advertised := map[byte]metadataTrack{}
+	for _, t := range info.metadataTracks {
+		if t.trackType == trackType {
+			advertised[t.trackID] = t
+		}
+	}
+	if len(advertised) == 0 {
+		// Multitrack tags without a track map: only trackId 0 is described.
+		if t, ok := metadataTrackFrom(trackType, 0, meta, nil); ok {
+			advertised[0] = t
+		}
+	}
+
+	seen := map[byte]bool{}
+	for _, s := range info.tracks {
+		if s.trackType != trackType {
+			continue
+		}
+		seen[s.trackID] = true
+		t, ok := advertised[s.trackID]
+		switch {
+		case !ok:
+			info.warnings = append(info.warnings, fmt.Sprintf("%s track %d (%s) is not described in onMetaData %s",
+				trackType, s.trackID, orDash(s.fourCC), trackMapKeys[trackType].infoMap))
+		case t.fourCC != "" && s.fourCC != "" && t.fourCC != s.fourCC:
+			info.warnings = append(info.warnings, fmt.Sprintf("onMetaData advertises %s track %d as %s, but its tags carry %s",
+				trackType, s.trackID, t.fourCC, s.fourCC))
+		}
+	}
+
+	ids := make([]int, 0, len(advertised))
+	for id := range advertised {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if !seen[byte(id)] {
+			info.warnings = append(info.warnings, fmt.Sprintf("onMetaData advertises %s track %d (%s), but no %s tag carries it",
+				trackType, id, orDash(advertised[byte(id)].codec), trackType))
+		}
+	}
+}
+
+// metadataTrackFrom reads the properties of one track from props, falling
+// back to defaults for missing ones. For trackId 0 (defaults nil) it reports
+// whether props describe the media type at all.
+func metadataTrackFrom(trackType string, trackID byte, props, defaults []AMF0Property) (metadataTrack, bool) {
+	found := false
+	lookup := func(names ...string) (any, bool) {
+		for _, list := range [][]AMF0Property{props, defaults} {
+			for _, name := range names {
+				if v, ok := amf0Lookup(list, name); ok {
+					found = true
+					return v, true
+				}
+			}
+		}
+		return nil, false
+	}
+	number := func(names ...string) float64 {
+		v, _ := lookup(names...)
+		n, _ := v.(float64)
+		return n
+	}
+
+	keys := trackMapKeys[trackType]
+	t := metadataTrack{trackType: trackType, trackID: trackID}
+	if v, ok := lookup(keys.codecID); ok {
+		t.codec, t.fourCC = metadataCodec(trackType, v)
+	}
+	t.bitrate = number(keys.bitrate)
+	if trackType == "video" {
+		t.width = number("width")
+		t.height = number("height")
+		t.frameRate = number("framerate")
+	} else {
+		t.sampleRate = number("audiosamplerate", "samplerate")
+		t.channels = number("channels", "audiochannels")
+		if t.channels == 0 {
+			if v, ok := lookup("stereo"); ok {
+				if stereo, _ := v.(bool); stereo {
+					t.channels = 2
+				} else {
+					t.channels = 1
+				}
+			}
+		}
+	}
+	if v, ok := lookup("language"); ok {
+		t.language, _ = amf0StringValue(v)
+	}
+	return t, found
+}
+
+// metadataCodec converts an onMetaData codec ID (a FourCC as number, a
+// legacy CodecId or a FourCC string) into a display name and the FourCC the
+// tags use.
+func metadataCodec(trackType string, v any) (name, fourCC string) {
+	switch v := v.(type) {
+	case string:
+		return v, v
+	case float64:
+		n := uint32(v)
+		if n > 15 {
+			s := string([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+			return s, s
+		}
+		if trackType == "video" {
+			if byte(n) == videoCodecIDAVC {
+				return "avc1", "avc1"
+			}
+			return enumName(videoCodecNames, byte(n)), ""
+		}
+		switch byte(n) {
+		case soundFormatAAC:
+			return "mp4a", "mp4a"
+		case soundFormatMP3:
+			return ".mp3", ".mp3"
+		}
+		return enumName(soundFormatNames, byte(n)), ""
+	}
+	return "", ""
+}
+
+func printMetadataTracks(tracks []metadataTrack) {
+	fmt.Printf("Tracks (onMetaData)\n")
+	fmt.Printf("  %-5s  %5s  %-6s  %14s  %-10s  %9s  %11s  %8s  %s\n",
+		"Type", "Track", "Codec", "Bitrate", "Resolution", "FrameRate", "SampleRate", "Channels", "Language")
+	for _, t := range tracks {
+		bitrate, resolution, frameRate, sampleRate, channels := "-", "-", "-", "-", "-"
+		if t.bitrate != 0 {
+			bitrate = fmt.Sprintf("%.1f kbps", t.bitrate)
+		}
+		if t.width != 0 || t.height != 0 {
+			resolution = fmt.Sprintf("%gx%g", t.width, t.height)
+		}
+		if t.frameRate != 0 {
+			frameRate = fmt.Sprintf("%.3f", t.frameRate)
+		}
+		if t.sampleRate != 0 {
+			sampleRate = fmt.Sprintf("%g Hz", t.sampleRate)
+		}
+		if t.channels != 0 {
+			channels = fmt.Sprintf("%g", t.channels)
+		}
+		fmt.Printf("  %-5s  %5d  %-6s  %14s  %-10s  %9s  %11s  %8s  %s\n",
+			t.trackType, t.trackID, orDash(t.codec), bitrate, resolution, frameRate, sampleRate, channels, orDash(t.language))
+	}
+}
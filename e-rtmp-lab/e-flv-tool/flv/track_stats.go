@@ -0,0 +1,149 @@
+package flv
+
+import "fmt"
+
+// trackStats summarizes one audio or video track, keyed by media type and
+// trackId (0 for single-track packets). Frames are the CodedFrames and
+// CodedFramesX packets of the track (for audio, one packet of samples);
+// sequence headers, metadata and command frames only register the track.
+type trackStats struct {
+	trackType string // "audio" or "video"
+	trackID   byte
+	fourCC    string // first FourCC seen, "" for legacy codecs without one
+	codec     string // FourCC or legacy codec name
+
+	frames    uint64
+	keyFrames uint64 // video only
+	bytes     uint64 // track body bytes of the frames
+	firstTS   uint32
+	lastTS    uint32
+
+	// Peak bitrate is measured over one-second intervals of DTS.
+	second      uint32
+	secondBytes uint64
+	peakBytes   uint64
+
+	// Frame timing (see frame_rate.go): the DTS steps between frames, and
+	// for audio the number of samples the frames carry.
+	deltas         map[uint32]uint64 // step in ms → count
+	samples        uint64
+	lastSamples    uint64 // samples of the last frame
+	samplesUnknown bool   // some frame has an unknown sample count
+}
+
+// track returns the statistics of a track, adding it on first use.
+func (info *fileInfo) track(trackType string, trackID byte, fourCC, codec string) *trackStats {
+	for _, t := range info.tracks {
+		if t.trackType == trackType && t.trackID == trackID {
+			if t.fourCC == "" {
+				t.fourCC = fourCC
+			}
+			if t.codec == "" {
+				t.codec = codec
+			}
+			return t
+		}
+	}
+	t := &trackStats{trackType: trackType, trackID: trackID, fourCC: fourCC, codec: codec}
+	info.tracks = append(info.tracks, t)
+	return t
+}
+
+func (t *trackStats) addFrame(timestamp uint32, size int, keyFrame bool) {
+	if t.frames == 0 {
+		t.firstTS = timestamp
+		t.second = timestamp / 1000
+	} else if timestamp >= t.lastTS {
+		if t.deltas == nil {
+			t.deltas = map[uint32]uint64{}
+		}
+		t.deltas[timestamp-t.lastTS]++
+	}
+	t.frames++
+	if keyFrame {
+		t.keyFrames++
+	}
+	t.bytes += uint64(size)
+	t.lastTS = timestamp
+
+	if s := timestamp / 1000; s != t.second {
+		t.second = s
+		t.secondBytes = 0
+	}
+	t.secondBytes += uint64(size)
+	if t.secondBytes > t.peakBytes {
+		t.peakBytes = t.secondBytes
+	}
+}
+
+// duration returns the track duration in milliseconds: the span from the
+// first to the last frame plus one average frame interval.
+func (t *trackStats) duration() float64 {
+	if t.frames < 2 || t.lastTS <= t.firstTS {
+		return 0
+	}
+	span := float64(t.lastTS - t.firstTS)
+	return span + span/float64(t.frames-1)
+}
+
+// avgBitrate returns the average bitrate in kbit/s, or 0 if the duration is
+// unknown.
+func (t *trackStats) avgBitrate() float64 {
+	d := t.duration()
+	if d == 0 {
+		return 0
+	}
+	return float64(t.bytes) * 8 / d
+}
+
+// peakBitrate returns the highest bitrate of any one-second interval in
+// kbit/s.
+func (t *trackStats) peakBitrate() float64 {
+	return float64(t.peakBytes) * 8 / 1000
+}
+
+func (info *fileInfo) collectVideoStats(timestamp uint32, pkt *VideoPacket) {
+	for _, track := range pkt.Tracks {
+		codec := track.FourCC
+		if codec == "" {
+			codec = enumName(videoCodecNames, pkt.CodecID)
+		}
+		t := info.track("video", track.TrackID, track.FourCC, codec)
+		if pkt.PacketType == VideoPacketTypeCodedFrames || pkt.PacketType == VideoPacketTypeCodedFramesX {
+			t.addFrame(timestamp, len(track.Data), pkt.FrameType.IsKeyFrame())
+		}
+	}
+}
+
+func (info *fileInfo) collectAudioStats(timestamp uint32, pkt *AudioPacket) {
+	for _, track := range pkt.Tracks {
+		codec := track.FourCC
+		if codec == "" {
+			codec = enumName(soundFormatNames, pkt.SoundFormat)
+		}
+		t := info.track("audio", track.TrackID, track.FourCC, codec)
+		if pkt.PacketType == AudioPacketTypeCodedFrames {
+			t.addFrame(timestamp, len(track.Data), false)
+			t.addSamples(audioFrameSamples(track.FourCC, track.Data))
+		}
+	}
+}
+
+func printTrackStats(tracks []*trackStats) {
+	fmt.Printf("Tracks\n")
+	fmt.Printf("  %-5s  %5s  %-12s  %8s  %9s  %10s  %10s  %11s  %10s  %10s  %10s\n",
+		"Type", "Track", "Codec", "Frames", "KeyFrames", "First DTS", "Last DTS", "Duration", "Bytes", "Avg kbps", "Peak kbps")
+	for _, t := range tracks {
+		keyFrames := "-"
+		if t.trackType == "video" {
+			keyFrames = fmt.Sprint(t.keyFrames)
+		}
+		first, last := "-", "-"
+		if t.frames > 0 {
+			first, last = fmt.Sprint(t.firstTS), fmt.Sprint(t.lastTS)
+		}
+		fmt.Printf("  %-5s  %5d  %-12s  %8d  %9s  %10s  %10s  %8.0f ms  %10d  %10.1f  %10.1f\n",
+			t.trackType, t.trackID, orDash(t.codec), t.frames, keyFrames, first, last,
+			t.duration(), t.bytes, t.avgBitrate(), t.peakBitrate())
+	}
+}
@@ -0,0 +1,292 @@
+package flv
+
+import "fmt"
+
+// VideoFrameType is the frame type carried in the first byte of every video
+// tag (4 bits in the legacy header, 3 bits in the ExVideoTagHeader).
+type VideoFrameType byte
+
+const (
+	VideoFrameTypeKeyFrame             VideoFrameType = 1
+	VideoFrameTypeInterFrame           VideoFrameType = 2
+	VideoFrameTypeDisposableInterFrame VideoFrameType = 3 // H.263 only
+	VideoFrameTypeGeneratedKeyFrame    VideoFrameType = 4 // server use only
+	VideoFrameTypeCommand              VideoFrameType = 5 // video info/command frame
+)
+
+func (t VideoFrameType) String() string {
+	return enumName(videoFrameTypeNames, byte(t))
+}
+
+// IsKeyFrame reports whether the frame is a KeyFrame or GeneratedKeyFrame.
+func (t VideoFrameType) IsKeyFrame() bool {
+	return t == VideoFrameTypeKeyFrame || t == VideoFrameTypeGeneratedKeyFrame
+}
+
+// VideoPacketType is the E-RTMP VideoPacketType. Legacy AVC packets are
+// mapped onto it (sequence header → SequenceStart, NALU → CodedFrames, end
+// of sequence → SequenceEnd).
+type VideoPacketType byte
+
+const (
+	VideoPacketTypeSequenceStart        VideoPacketType = 0
+	VideoPacketTypeCodedFrames          VideoPacketType = 1
+	VideoPacketTypeSequenceEnd          VideoPacketType = 2
+	VideoPacketTypeCodedFramesX         VideoPacketType = 3 // CodedFrames with an implied zero composition time offset
+	VideoPacketTypeMetadata             VideoPacketType = 4
+	VideoPacketTypeMPEG2TSSequenceStart VideoPacketType = 5
+	VideoPacketTypeMultitrack           VideoPacketType = 6
+	VideoPacketTypeModEx                VideoPacketType = 7
+)
+
+func (t VideoPacketType) String() string {
+	return enumName(videoPacketTypeNames, byte(t))
+}
+
+// VideoPacket is a decoded video tag payload.
+type VideoPacket struct {
+	Enhanced  bool // ExVideoTagHeader; false for the legacy VideoTagHeader
+	FrameType VideoFrameType
+	// PacketType is the packet type of the tracks. For Multitrack packets it
+	// is the packet type shared by all tracks, Multitrack is set and
+	// MultitrackType holds the AvMultitrackType (0 OneTrack, 1 ManyTracks,
+	// 2 ManyTracksManyCodecs).
+	PacketType     VideoPacketType
+	Multitrack     bool
+	MultitrackType byte
+	CodecID        byte // legacy VideoCodecId, 0 for enhanced packets
+
+	// Command frames carry a VideoCommand (0 StartSeek, 1 EndSeek) instead
+	// of video data and have no tracks.
+	HasCommand bool
+	Command    byte
+
+	// ModEx holds the packet modifier extensions that preceded the packet
+	// type, in bitstream order. TimestampOffsetNano (ModExType 0) is also
+	// decoded into the fields below; it refines the tag timestamp by up to
+	// 999999 ns and applies to every track of the packet.
+	ModEx                  []ModEx
+	HasTimestampOffsetNano bool
+	TimestampOffsetNano    uint32
+
+	Tracks []VideoTrack
+}
+
+// VideoTrack is the body of one track of a video packet. Single-track
+// packets have exactly one track with TrackID 0.
+type VideoTrack struct {
+	TrackID byte
+	FourCC  string // "avc1" for legacy AVC, "" for other legacy codecs
+	// CompositionTimeOffset is the SI24 offset in milliseconds carried by
+	// CodedFrames of avc1/hvc1/vvc1. It is zero for CodedFramesX (where it is
+	// implied) and for codecs that do not reorder frames.
+	CompositionTimeOffset int32
+	HasCTO                bool // CompositionTimeOffset was present in the bitstream
+	// Data is the track body after the composition time offset. It aliases
+	// the slice passed to ParseVideoPacket.
+	Data []byte
+}
+
+// PTS returns the presentation timestamp in milliseconds of a frame whose
+// tag carries the given DTS.
+func (t *VideoTrack) PTS(dts uint32) int64 {
+	return int64(dts) + int64(t.CompositionTimeOffset)
+}
+
+// VideoPacket decodes the payload of a video tag.
+func (t *Tag) VideoPacket() (*VideoPacket, error) {
+	if t.Type != TagTypeVideo {
+		return nil, fmt.Errorf("not a video tag: %s", t.Type)
+	}
+	return ParseVideoPacket(t.Data)
+}
+
+// ParseVideoPacket decodes a video tag payload with either a legacy
+// VideoTagHeader or an E-RTMP ExVideoTagHeader:
+//
+//	Legacy:      [FrameType(4)|CodecID(4)] ([AVCPacketType(1)] [CTO(3)] for AVC) [body...]
+//	Enhanced:    [IsEx(1)|FrameType(3)|PacketType(4)] [FourCC(4)] ([CTO(3)]) [body...]
+//	Command:     [IsEx(1)|FrameType=5(3)|PacketType(4)] [VideoCommand(1)]
+//	Multitrack:  [IsEx(1)|FrameType(3)|PacketType=6(4)] [AvMultitrackType(4)|PacketType(4)]
+//	             ([FourCC(4)]) then per track ([FourCC(4)]) [TrackID(1)] ([Size(3)]) [body]
+//	ModEx:       [header with PacketType=7] then per modifier [Size(1 or 3)] [modExData]
+//	             [ModExType(4)|PacketType(4)] while PacketType is ModEx, then one of the above
+func ParseVideoPacket(data []byte) (*VideoPacket, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("empty video tag")
+	}
+
+	if data[0]&0x80 == 0 {
+		return parseLegacyVideoPacket(data)
+	}
+
+	pkt := &VideoPacket{
+		Enhanced:   true,
+		FrameType:  VideoFrameType((data[0] >> 4) & 0x07),
+		PacketType: VideoPacketType(data[0] & 0x0F),
+	}
+	pos := 1
+
+	if pkt.PacketType == VideoPacketTypeModEx {
+		modEx, packetType, next, err := readModEx(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		pkt.ModEx = modEx.entries
+		pkt.HasTimestampOffsetNano = modEx.hasNano
+		pkt.TimestampOffsetNano = modEx.nanoOffset
+		pkt.PacketType = VideoPacketType(packetType)
+		pos = next
+	}
+
+	// FrameType is ignored for Metadata packets.
+	if pkt.FrameType == VideoFrameTypeCommand && pkt.PacketType != VideoPacketTypeMetadata {
+		if len(data) < pos+1 {
+			return nil, fmt.Errorf("truncated video command frame")
+		}
+		pkt.HasCommand = true
+		pkt.Command = data[pos]
+		return pkt, nil
+	}
+
+	if pkt.PacketType != VideoPacketTypeMultitrack {
+		if len(data) < pos+4 {
+			return nil, fmt.Errorf("truncated ExVideoTagHeader")
+		}
+		track := VideoTrack{FourCC: string(data[pos : pos+4])}
+		if err := track.setBody(pkt.PacketType, data[pos+4:]); err != nil {
+			return nil, err
+		}
+		pkt.Tracks = []VideoTrack{track}
+		return pkt, nil
+	}
+
+	if len(data) < pos+1 {
+		return nil, fmt.Errorf("truncated multitrack header")
+	}
+	pkt.Multitrack = true
+	pkt.MultitrackType = data[pos] >> 4
+	pkt.PacketType = VideoPacketType(data[pos] & 0x0F)
+	pos++
+	if pkt.PacketType == VideoPacketTypeMultitrack || pkt.PacketType == VideoPacketTypeModEx {
+		return nil, fmt.Errorf("invalid packet type %s inside multitrack packet", pkt.PacketType)
+	}
+	if pkt.MultitrackType > avMultitrackManyTracksManyCodecs {
+		return nil, fmt.Errorf("unknown AvMultitrackType %d", pkt.MultitrackType)
+	}
+
+	var sharedFourCC string
+	if pkt.MultitrackType != avMultitrackManyTracksManyCodecs {
+		if len(data) < pos+4 {
+			return nil, fmt.Errorf("truncated multitrack header")
+		}
+		sharedFourCC = string(data[pos : pos+4])
+		pos += 4
+	}
+
+	for pos < len(data) {
+		track := VideoTrack{FourCC: sharedFourCC}
+		if pkt.MultitrackType == avMultitrackManyTracksManyCodecs {
+			if len(data) < pos+4 {
+				return nil, fmt.Errorf("truncated track FourCC")
+			}
+			track.FourCC = string(data[pos : pos+4])
+			pos += 4
+		}
+		if len(data) < pos+1 {
+			return nil, fmt.Errorf("truncated track ID")
+		}
+		track.TrackID = data[pos]
+		pos++
+
+		end := len(data)
+		if pkt.MultitrackType != avMultitrackOneTrack {
+			if len(data) < pos+3 {
+				return nil, fmt.Errorf("truncated track size")
+			}
+			size := int(data[pos])<<16 | int(data[pos+1])<<8 | int(data[pos+2])
+			pos += 3
+			if pos+size > len(data) {
+				return nil, fmt.Errorf("track %d: size %d exceeds packet", track.TrackID, size)
+			}
+			end = pos + size
+		}
+
+		if err := track.setBody(pkt.PacketType, data[pos:end]); err != nil {
+			return nil, fmt.Errorf("track %d: %w", track.TrackID, err)
+		}
+		pkt.Tracks = append(pkt.Tracks, track)
+		pos = end
+		if pkt.MultitrackType == avMultitrackOneTrack {
+			break
+		}
+	}
+	return pkt, nil
+}
+
+// setBody stores body as the track data, first splitting off the SI24
+// composition time offset for CodedFrames of codecs that carry one.
+func (t *VideoTrack) setBody(packetType VideoPacketType, body []byte) error {
+	if packetType == VideoPacketTypeCodedFrames && hasCompositionTimeOffset(t.FourCC) {
+		if len(body) < 3 {
+			return fmt.Errorf("truncated composition time offset")
+		}
+		t.CompositionTimeOffset = readSI24(body[0:3])
+		t.HasCTO = true
+		body = body[3:]
+	}
+	t.Data = body
+	return nil
+}
+
+// hasCompositionTimeOffset reports whether CodedFrames of the codec start
+// with an SI24 composition time offset.
+func hasCompositionTimeOffset(fourCC string) bool {
+	return fourCC == "avc1" || fourCC == "hvc1" || fourCC == "vvc1"
+}
+
+func parseLegacyVideoPacket(data []byte) (*VideoPacket, error) {
+	pkt := &VideoPacket{
+		FrameType:  VideoFrameType(data[0] >> 4),
+		PacketType: VideoPacketTypeCodedFrames,
+		CodecID:    data[0] & 0x0F,
+	}
+
+	if pkt.FrameType == VideoFrameTypeCommand {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated video command frame")
+		}
+		pkt.HasCommand = true
+		pkt.Command = data[1]
+		return pkt, nil
+	}
+
+	if pkt.CodecID != videoCodecIDAVC {
+		pkt.Tracks = []VideoTrack{{Data: data[1:]}}
+		return pkt, nil
+	}
+
+	// [AVCPacketType(1)] [CTO(3)]; the CTO is only meaningful for NALUs but
+	// is present for every AVC packet type.
+	if len(data) < 5 {
+		return nil, fmt.Errorf("truncated AVC video tag")
+	}
+	track := VideoTrack{
+		FourCC:                "avc1",
+		CompositionTimeOffset: readSI24(data[2:5]),
+		HasCTO:                true,
+		Data:                  data[5:],
+	}
+	switch data[1] {
+	case 0:
+		pkt.PacketType = VideoPacketTypeSequenceStart
+	case 1:
+		pkt.PacketType = VideoPacketTypeCodedFrames
+	case 2:
+		pkt.PacketType = VideoPacketTypeSequenceEnd
+	default:
+		return nil, fmt.Errorf("unknown AVC packet type %d", data[1])
+	}
+	pkt.Tracks = []VideoTrack{track}
+	return pkt, nil
+}
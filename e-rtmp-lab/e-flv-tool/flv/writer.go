@@ -0,0 +1,143 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Writer writes an FLV/E-FLV stream tag by tag. NewWriter writes the file
+// header; every Write*Tag method writes one tag followed by its
+// PreviousTagSize. Timestamps are 32-bit DTS values in milliseconds; the
+// upper 8 bits go into the TimestampExtended byte of the tag header.
+//
+// Writer does not buffer; wrap the destination in a bufio.Writer when
+// writing to a file.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the 9-byte FLV header with the given HasAudio/HasVideo
+// flags, followed by PreviousTagSize0.
+func NewWriter(w io.Writer, hasAudio, hasVideo bool) (*Writer, error) {
+	buf := [13]byte{'F', 'L', 'V', 1, headerFlags(hasAudio, hasVideo)}
+	binary.BigEndian.PutUint32(buf[5:9], 9)
+	// buf[9:13] is PreviousTagSize0, always 0.
+	if _, err := w.Write(buf[:]); err != nil {
+		return nil, fmt.Errorf("writing header: %w", err)
+	}
+	return &Writer{w: w}, nil
+}
+
+// headerFlags returns the TypeFlags byte of the FLV header.
+func headerFlags(hasAudio, hasVideo bool) byte {
+	var flags byte
+	if hasAudio {
+		flags |= 0x04
+	}
+	if hasVideo {
+		flags |= 0x01
+	}
+	return flags
+}
+
+// WriteTag writes tag as is: the tag header, tag.Data and the PreviousTagSize
+// that follows it. tag.Offset is ignored.
+func (w *Writer) WriteTag(tag *Tag) error {
+	return w.writeTag(tag.Type, tag.Timestamp, tag.StreamID, nil, tag.Data)
+}
+
+// WriteScriptTag writes a script data tag. data is the AMF0 payload, usually
+// a method name string followed by its arguments.
+func (w *Writer) WriteScriptTag(timestamp uint32, data []byte) error {
+	return w.writeTag(TagTypeScript, timestamp, 0, nil, data)
+}
+
+// WriteLegacyVideoTag writes a video tag with a legacy VideoTagHeader
+// [FrameType(4)|CodecID(4)]. For AVC, data starts with the AVCPacketType and
+// the SI24 composition time offset.
+func (w *Writer) WriteLegacyVideoTag(timestamp uint32, frameType, codecID byte, data []byte) error {
+	if frameType > 0x0F || codecID > 0x0F {
+		return fmt.Errorf("invalid legacy video header: frame type %d, codec ID %d", frameType, codecID)
+	}
+	return w.writeTag(TagTypeVideo, timestamp, 0, []byte{frameType<<4 | codecID}, data)
+}
+
+// WriteEnhancedVideoTag writes a video tag with an E-RTMP ExVideoTagHeader
+// [IsExHeader(1)|FrameType(3)|PacketType(4)] followed by fourCC. fourCC must
+// be empty for packets that carry none (command frames, Multitrack packets,
+// whose FourCC is part of the multitrack header in data). For CodedFrames of
+// avc1/hvc1, data starts with the SI24 composition time offset.
+func (w *Writer) WriteEnhancedVideoTag(timestamp uint32, frameType, packetType byte, fourCC string, data []byte) error {
+	if frameType > 0x07 || packetType > 0x0F {
+		return fmt.Errorf("invalid enhanced video header: frame type %d, packet type %d", frameType, packetType)
+	}
+	if fourCC != "" && len(fourCC) != 4 {
+		return fmt.Errorf("invalid FourCC %q", fourCC)
+	}
+	header := append([]byte{0x80 | frameType<<4 | packetType}, fourCC...)
+	return w.writeTag(TagTypeVideo, timestamp, 0, header, data)
+}
+
+// WriteLegacyAudioTag writes an audio tag with a legacy AudioTagHeader
+// [SoundFormat(4)|SoundRate(2)|SoundSize(1)|SoundType(1)]. For AAC, data
+// starts with the AACPacketType.
+func (w *Writer) WriteLegacyAudioTag(timestamp uint32, soundFormat, soundRate, soundSize, soundType byte, data []byte) error {
+	if soundFormat > 0x0F || soundFormat == soundFormatExAudio || soundRate > 0x03 || soundSize > 1 || soundType > 1 {
+		return fmt.Errorf("invalid legacy audio header: sound format %d, rate %d, size %d, type %d",
+			soundFormat, soundRate, soundSize, soundType)
+	}
+	header := soundFormat<<4 | soundRate<<2 | soundSize<<1 | soundType
+	return w.writeTag(TagTypeAudio, timestamp, 0, []byte{header}, data)
+}
+
+// WriteEnhancedAudioTag writes an audio tag with an E-RTMP ExAudioTagHeader
+// [SoundFormat=9(4)|AudioPacketType(4)] followed by fourCC. fourCC must be
+// empty for Multitrack packets, whose FourCC is part of the multitrack
+// header in data.
+func (w *Writer) WriteEnhancedAudioTag(timestamp uint32, packetType byte, fourCC string, data []byte) error {
+	if packetType > 0x0F {
+		return fmt.Errorf("invalid enhanced audio header: packet type %d", packetType)
+	}
+	if fourCC != "" && len(fourCC) != 4 {
+		return fmt.Errorf("invalid FourCC %q", fourCC)
+	}
+	header := append([]byte{soundFormatExAudio<<4 | packetType}, fourCC...)
+	return w.writeTag(TagTypeAudio, timestamp, 0, header, data)
+}
+
+// writeTag writes the 11-byte tag header, the payload (header followed by
+// data) and the PreviousTagSize that follows it.
+func (w *Writer) writeTag(tagType TagType, timestamp, streamID uint32, header, data []byte) error {
+	dataSize := len(header) + len(data)
+	if dataSize > 0xFFFFFF {
+		return fmt.Errorf("tag payload too large: %d bytes", dataSize)
+	}
+
+	var tagHeader [11]byte
+	tagHeader[0] = byte(tagType)
+	tagHeader[1] = byte(dataSize >> 16)
+	tagHeader[2] = byte(dataSize >> 8)
+	tagHeader[3] = byte(dataSize)
+	tagHeader[4] = byte(timestamp >> 16)
+	tagHeader[5] = byte(timestamp >> 8)
+	tagHeader[6] = byte(timestamp)
+	tagHeader[7] = byte(timestamp >> 24) // TimestampExtended
+	tagHeader[8] = byte(streamID >> 16)
+	tagHeader[9] = byte(streamID >> 8)
+	tagHeader[10] = byte(streamID)
+	if _, err := w.w.Write(tagHeader[:]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+
+	var previousTagSize [4]byte
+	binary.BigEndian.PutUint32(previousTagSize[:], uint32(len(tagHeader)+dataSize))
+	_, err := w.w.Write(previousTagSize[:])
+	return err
+}